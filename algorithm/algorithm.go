@@ -2,9 +2,11 @@ package algorithm
 
 import (
 	"database/sql"
+	"fmt"
 	"sync"
 
 	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
 )
 
 var (
@@ -14,7 +16,7 @@ var (
 
 // ShardingAlgorithm is a algorithm for assign sharding target.
 //
-// octillery currently supports modulo and hashmap.
+// octillery currently supports modulo, hashmap, consistent_hash, range, lookup and time_bucket.
 // If use the other new algorithm, implement the following interface as plugin ( new_algorithm.go )
 // and call algorithm.Register("algorithm_name", &NewAlgorithmStructure{}).
 // Also, new_algorithm.go file should put inside github.com/aokabi/octillery/algorithm directory.
@@ -26,6 +28,45 @@ type ShardingAlgorithm interface {
 	Shard(conns []*sql.DB, lastInsertID int64) (*sql.DB, error)
 }
 
+// KeyShardingAlgorithm is implemented by sharding algorithm supporting typed shard_key ( string, []byte, uint64 ).
+// If algorithm doesn't implement this interface, only shard_key that can be represented by int64 is supported.
+type KeyShardingAlgorithm interface {
+	// assign sharding target by connection list and typed shard_key
+	ShardByKey(conns []*sql.DB, key ShardKey) (*sql.DB, error)
+}
+
+// RangeShardingAlgorithm is implemented by sharding algorithm that is able to find shards for range of shard_key.
+// Query having range condition ( e.g. BETWEEN ) for shard_key is executed only for returned shards.
+type RangeShardingAlgorithm interface {
+	// assign sharding targets that may have shard_key included in keyRange
+	ShardsByRange(conns []*sql.DB, keyRange *ShardKeyRange) ([]*sql.DB, error)
+}
+
+// HashSlotRange is the range of hash slots [Start, End] assigned to a shard.
+type HashSlotRange struct {
+	Start uint32
+	End   uint32
+}
+
+func (r *HashSlotRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprint(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// HashSlotAlgorithm is implemented by sharding algorithm that assigns hash slots to shards.
+type HashSlotAlgorithm interface {
+	// returns ranges of hash slots assigned to each connection by order of conns. this is called after Init.
+	HashSlots(conns []*sql.DB) [][]*HashSlotRange
+}
+
+// Configurable is implemented by sharding algorithm that has parameters in configuration file.
+type Configurable interface {
+	// configure algorithm by table configuration. this is called before Init.
+	Configure(cfg *config.TableConfig) error
+}
+
 // Register register sharding algorithm with name
 func Register(name string, algorithmFactory func() ShardingAlgorithm) {
 	algorithmsMu.Lock()
@@ -47,3 +88,44 @@ func LoadShardingAlgorithm(name string) (ShardingAlgorithm, error) {
 	}
 	return algorithmFactory(), nil
 }
+
+// LoadShardingAlgorithmByConfig load algorithm by table configuration.
+// If algorithm implements Configurable, it is configured by table configuration.
+func LoadShardingAlgorithmByConfig(cfg *config.TableConfig) (ShardingAlgorithm, error) {
+	logic, err := LoadShardingAlgorithm(cfg.Algorithm)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if configurable, ok := logic.(Configurable); ok {
+		if err := configurable.Configure(cfg); err != nil {
+			return nil, errors.Wrapf(err, "cannot configure sharding algorithm %s", cfg.Algorithm)
+		}
+	}
+	return logic, nil
+}
+
+// ShardByKey assign sharding target by algorithm and typed shard_key.
+func ShardByKey(logic ShardingAlgorithm, conns []*sql.DB, key ShardKey) (*sql.DB, error) {
+	if keyLogic, ok := logic.(KeyShardingAlgorithm); ok {
+		return keyLogic.ShardByKey(conns, key)
+	}
+	id, ok := key.Int64()
+	if !ok {
+		return nil, errors.Errorf("sharding algorithm doesn't support shard_key %s", key)
+	}
+	return logic.Shard(conns, id)
+}
+
+// ShardsByRange assign sharding targets by algorithm and range of shard_key.
+// If algorithm doesn't implement RangeShardingAlgorithm, all connections are returned.
+func ShardsByRange(logic ShardingAlgorithm, conns []*sql.DB, keyRange *ShardKeyRange) ([]*sql.DB, error) {
+	rangeLogic, ok := logic.(RangeShardingAlgorithm)
+	if !ok || keyRange == nil {
+		return conns, nil
+	}
+	shardConns, err := rangeLogic.ShardsByRange(conns, keyRange)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return shardConns, nil
+}
@@ -1,9 +1,14 @@
 package algorithm
 
 import (
+	"container/list"
 	"database/sql"
 	"database/sql/driver"
+	"fmt"
 	"testing"
+	"time"
+
+	"github.com/aokabi/octillery/config"
 )
 
 type TestDriver struct {
@@ -167,3 +172,516 @@ func TestHashMap(t *testing.T) {
 		})
 	})
 }
+
+func TestWeightedHashMap(t *testing.T) {
+	conns := []*sql.DB{}
+	shards := []map[string]*config.DatabaseConfig{}
+	for i, weight := range []int{1, 2, 1} {
+		conn, err := sql.Open("sqlite3", fmt.Sprintf("shard_%d", i+1))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		conns = append(conns, conn)
+		shards = append(shards, map[string]*config.DatabaseConfig{
+			fmt.Sprintf("shard_%d", i+1): {Weight: weight},
+		})
+	}
+	hashmap, err := LoadShardingAlgorithmByConfig(&config.TableConfig{Algorithm: "hashmap", Shards: shards})
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if !hashmap.Init(conns) {
+		t.Fatal("cannot initialize algorithm")
+	}
+	t.Run("hash slots", func(t *testing.T) {
+		slots := hashmap.(HashSlotAlgorithm).HashSlots(conns)
+		expected := []string{"0-254", "255-766", "767-1022"}
+		for idx, slot := range slots {
+			if len(slot) != 1 || slot[0].String() != expected[idx] {
+				t.Fatalf("invalid hash slots %v", slot)
+			}
+		}
+	})
+	t.Run("distribution", func(t *testing.T) {
+		counts := map[*sql.DB]int{}
+		for id := int64(1); id <= 4000; id++ {
+			conn, err := hashmap.Shard(conns, id)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			counts[conn]++
+		}
+		if counts[conns[1]] < counts[conns[0]]*3/2 || counts[conns[1]] < counts[conns[2]]*3/2 {
+			t.Fatalf("keys are not distributed by weight %d:%d:%d", counts[conns[0]], counts[conns[1]], counts[conns[2]])
+		}
+	})
+	t.Run("too large weight", func(t *testing.T) {
+		shards := []map[string]*config.DatabaseConfig{
+			{"shard_1": {Weight: 1}},
+			{"shard_2": {Weight: 1023}},
+		}
+		if _, err := LoadShardingAlgorithmByConfig(&config.TableConfig{Algorithm: "hashmap", Shards: shards}); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestDeclaredHashSlots(t *testing.T) {
+	conn1, err := sql.Open("sqlite3", "shard_1")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	conn2, err := sql.Open("sqlite3", "shard_2")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	shard1 := map[string]*config.DatabaseConfig{"shard_1": {Slots: []string{"0-99", "500-1022"}}}
+	shard2 := map[string]*config.DatabaseConfig{"shard_2": {Slots: []string{"100-499"}}}
+	newHashMap := func(shards []map[string]*config.DatabaseConfig, conns []*sql.DB) ShardingAlgorithm {
+		hashmap, err := LoadShardingAlgorithmByConfig(&config.TableConfig{Algorithm: "hashmap", Shards: shards})
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if !hashmap.Init(conns) {
+			t.Fatal("cannot initialize algorithm")
+		}
+		return hashmap
+	}
+	hashmap := newHashMap([]map[string]*config.DatabaseConfig{shard1, shard2}, []*sql.DB{conn1, conn2})
+	reordered := newHashMap([]map[string]*config.DatabaseConfig{shard2, shard1}, []*sql.DB{conn2, conn1})
+	slots := hashmap.(HashSlotAlgorithm).HashSlots([]*sql.DB{conn1, conn2})
+	if len(slots[0]) != 2 || slots[0][1].String() != "500-1022" || len(slots[1]) != 1 || slots[1][0].String() != "100-499" {
+		t.Fatal("invalid hash slots")
+	}
+	for id := int64(1); id <= 100; id++ {
+		conn, err := hashmap.Shard([]*sql.DB{conn1, conn2}, id)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		reorderedConn, err := reordered.Shard([]*sql.DB{conn2, conn1}, id)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if conn != reorderedConn {
+			t.Fatalf("shard for %d is changed by order of shards", id)
+		}
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	openConns := func(num int) []*sql.DB {
+		conns := []*sql.DB{}
+		for i := 0; i < num; i++ {
+			conn, err := sql.Open("sqlite3", "")
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			conns = append(conns, conn)
+		}
+		return conns
+	}
+	tableConfig := func(shardNum int) *config.TableConfig {
+		shards := []map[string]*config.DatabaseConfig{}
+		for i := 0; i < shardNum; i++ {
+			shards = append(shards, map[string]*config.DatabaseConfig{
+				fmt.Sprintf("shard_%d", i+1): {},
+			})
+		}
+		return &config.TableConfig{
+			Algorithm:      "consistent_hash",
+			VirtualNodeNum: 100,
+			Shards:         shards,
+		}
+	}
+	t.Run("load by config", func(t *testing.T) {
+		consistentHash, err := LoadShardingAlgorithmByConfig(tableConfig(2))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		conns := openConns(2)
+		t.Run("init", func(t *testing.T) {
+			if !consistentHash.Init(conns) {
+				t.Fatal("cannot initialize algorithm")
+			}
+		})
+		t.Run("shard", func(t *testing.T) {
+			shardConn, err := consistentHash.Shard(conns, 1)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if shardConn != conns[0] && shardConn != conns[1] {
+				t.Fatal("cannot get shard connection")
+			}
+		})
+	})
+	t.Run("invalid virtual nodes", func(t *testing.T) {
+		cfg := tableConfig(2)
+		cfg.VirtualNodeNum = -1
+		if _, err := LoadShardingAlgorithmByConfig(cfg); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("add shard", func(t *testing.T) {
+		conns := openConns(4)
+		before, err := LoadShardingAlgorithmByConfig(tableConfig(3))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if !before.Init(conns[:3]) {
+			t.Fatal("cannot initialize algorithm")
+		}
+		after, err := LoadShardingAlgorithmByConfig(tableConfig(4))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if !after.Init(conns) {
+			t.Fatal("cannot initialize algorithm")
+		}
+		keyNum := 10000
+		movedNum := 0
+		for id := int64(1); id <= int64(keyNum); id++ {
+			beforeConn, err := before.Shard(conns[:3], id)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			afterConn, err := after.Shard(conns, id)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if beforeConn == afterConn {
+				continue
+			}
+			if afterConn != conns[3] {
+				t.Fatalf("key %d is moved to existing shard", id)
+			}
+			movedNum++
+		}
+		if movedNum == 0 || movedNum > keyNum/3 {
+			t.Fatalf("invalid number of moved keys %d", movedNum)
+		}
+	})
+}
+
+func TestShardsByRange(t *testing.T) {
+	int64Ptr := func(v int64) *int64 { return &v }
+	shards := []map[string]*config.DatabaseConfig{
+		{"shard_1": {Range: &config.RangeConfig{From: 1, To: int64Ptr(100)}}},
+		{"shard_2": {Range: &config.RangeConfig{From: 100, To: int64Ptr(200)}}},
+		{"shard_3": {Range: &config.RangeConfig{From: 200}}},
+	}
+	logic, err := LoadShardingAlgorithmByConfig(&config.TableConfig{Algorithm: "range", Shards: shards})
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	conns := []*sql.DB{}
+	for i := 0; i < len(shards); i++ {
+		conn, err := sql.Open("sqlite3", "")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		conns = append(conns, conn)
+	}
+	if !logic.Init(conns) {
+		t.Fatal("cannot initialize algorithm")
+	}
+	for _, tc := range []struct {
+		keyRange *ShardKeyRange
+		indexes  []int
+	}{
+		{&ShardKeyRange{From: int64Ptr(50), To: int64Ptr(150)}, []int{0, 1}},
+		{&ShardKeyRange{From: int64Ptr(100), To: int64Ptr(200)}, []int{1}},
+		{&ShardKeyRange{From: int64Ptr(150)}, []int{1, 2}},
+		{&ShardKeyRange{To: int64Ptr(100)}, []int{0}},
+		{NewShardKeyRangeByValue(300), []int{2}},
+	} {
+		shardConns, err := ShardsByRange(logic, conns, tc.keyRange)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if len(shardConns) != len(tc.indexes) {
+			t.Fatalf("invalid shards for range %s", tc.keyRange)
+		}
+		for idx, shardIndex := range tc.indexes {
+			if shardConns[idx] != conns[shardIndex] {
+				t.Fatalf("invalid shards for range %s", tc.keyRange)
+			}
+		}
+	}
+	t.Run("not supported algorithm", func(t *testing.T) {
+		modulo, err := LoadShardingAlgorithm("modulo")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		shardConns, err := ShardsByRange(modulo, conns, NewShardKeyRangeByValue(1))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if len(shardConns) != len(conns) {
+			t.Fatal("all shards must be returned")
+		}
+	})
+	t.Run("intersect and union", func(t *testing.T) {
+		left := &ShardKeyRange{From: int64Ptr(10), To: int64Ptr(20)}
+		right := &ShardKeyRange{From: int64Ptr(15)}
+		if r := left.Intersect(right); r.String() != "[15, 20)" {
+			t.Fatalf("invalid range %s", r)
+		}
+		if r := left.Union(right); r.String() != "[10, +inf)" {
+			t.Fatalf("invalid range %s", r)
+		}
+		if !left.Intersect(&ShardKeyRange{To: int64Ptr(10)}).IsEmpty() {
+			t.Fatal("range must be empty")
+		}
+	})
+}
+
+func TestTimeBucket(t *testing.T) {
+	conns := []*sql.DB{}
+	for i := 0; i < 3; i++ {
+		conn, err := sql.Open("sqlite3", fmt.Sprintf("shard_%d", i+1))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		conns = append(conns, conn)
+	}
+	newTimeBucket := func(bucket *config.TimeBucketConfig) ShardingAlgorithm {
+		logic, err := LoadShardingAlgorithmByConfig(&config.TableConfig{Algorithm: "time_bucket", TimeBucket: bucket})
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if !logic.Init(conns) {
+			t.Fatal("cannot initialize algorithm")
+		}
+		return logic
+	}
+	validateShards := func(t *testing.T, logic ShardingAlgorithm, values map[string]int) {
+		for value, shardIndex := range values {
+			conn, err := ShardByKey(logic, conns, NewStringKey(value))
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if conn != conns[shardIndex] {
+				t.Fatalf("invalid shard for %s", value)
+			}
+		}
+	}
+	t.Run("month", func(t *testing.T) {
+		validateShards(t, newTimeBucket(&config.TimeBucketConfig{Unit: "month", Start: "2024-01-01"}), map[string]int{
+			"2024-01-01 00:00:00": 0,
+			"2024-01-31 23:59:59": 0,
+			"2024-02-01":          1,
+			"2024-03-15 12:00:00": 2,
+			"2024-04-01 00:00:00": 0,
+			"2023-12-31 23:59:59": 2,
+		})
+	})
+	t.Run("week", func(t *testing.T) {
+		validateShards(t, newTimeBucket(&config.TimeBucketConfig{Unit: "week", Start: "2024-01-01", BucketsPerShard: 2}), map[string]int{
+			"2024-01-01": 0,
+			"2024-01-14": 0, // Sunday of second week
+			"2024-01-15": 1,
+			"2024-01-29": 2,
+			"2024-02-12": 0,
+			"2023-12-31": 2,
+		})
+	})
+	t.Run("location", func(t *testing.T) {
+		logic := newTimeBucket(&config.TimeBucketConfig{Unit: "month", Start: "2024-01-01", Location: "Asia/Tokyo"})
+		conn, err := ShardByKey(logic, conns, NewTimeKey(time.Date(2024, 1, 31, 20, 0, 0, 0, time.UTC)))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if conn != conns[1] {
+			t.Fatal("bucket must be decided by location")
+		}
+	})
+	t.Run("invalid config", func(t *testing.T) {
+		for _, bucket := range []*config.TimeBucketConfig{
+			nil,
+			{Unit: "day"},
+			{Unit: "month", Start: "2024/01/01"},
+			{Unit: "month", Location: "Unknown/Location"},
+		} {
+			if _, err := LoadShardingAlgorithmByConfig(&config.TableConfig{Algorithm: "time_bucket", TimeBucket: bucket}); err == nil {
+				t.Fatal("cannot handle error")
+			}
+		}
+	})
+}
+
+func TestLookupCache(t *testing.T) {
+	newLookup := func(cacheTTL int) *lookupShardingAlgorithm {
+		return &lookupShardingAlgorithm{
+			lookup:    &config.LookupConfig{CacheTTL: cacheTTL, CacheSize: 2},
+			cache:     map[ShardKey]*list.Element{},
+			cacheList: list.New(),
+		}
+	}
+	t.Run("evict least recently used", func(t *testing.T) {
+		l := newLookup(0)
+		l.setCache(NewIntKey(1), 0)
+		l.setCache(NewIntKey(2), 1)
+		if _, exists := l.cachedShardIndex(NewIntKey(1)); !exists {
+			t.Fatal("cannot find cache")
+		}
+		l.setCache(NewIntKey(3), 0)
+		if len(l.cache) != 2 || l.cacheList.Len() != 2 {
+			t.Fatalf("cache is not bounded. size = %d", len(l.cache))
+		}
+		if _, exists := l.cachedShardIndex(NewIntKey(2)); exists {
+			t.Fatal("least recently used cache is not evicted")
+		}
+		for _, key := range []ShardKey{NewIntKey(1), NewIntKey(3)} {
+			if _, exists := l.cachedShardIndex(key); !exists {
+				t.Fatalf("cannot find cache of %s", key)
+			}
+		}
+		l.setCache(NewIntKey(1), 1)
+		if shardIndex, _ := l.cachedShardIndex(NewIntKey(1)); shardIndex != 1 || len(l.cache) != 2 {
+			t.Fatal("cannot update cache")
+		}
+	})
+	t.Run("expire", func(t *testing.T) {
+		l := newLookup(1)
+		l.setCache(NewIntKey(1), 0)
+		l.cache[NewIntKey(1)].Value.(*lookupCache).expiredAt = time.Now().Add(-time.Second)
+		if _, exists := l.cachedShardIndex(NewIntKey(1)); exists {
+			t.Fatal("cannot expire cache")
+		}
+		if len(l.cache) != 0 || l.cacheList.Len() != 0 {
+			t.Fatal("expired cache is not removed")
+		}
+	})
+}
+
+func TestShardKey(t *testing.T) {
+	t.Run("new shard key", func(t *testing.T) {
+		str := "user@example.com"
+		for _, tc := range []struct {
+			value interface{}
+			kind  ShardKeyKind
+			text  string
+		}{
+			{1, ShardKeyInt, "1"},
+			{int32(-2), ShardKeyInt, "-2"},
+			{uint64(18446744073709551615), ShardKeyUint, "18446744073709551615"},
+			{str, ShardKeyString, str},
+			{&str, ShardKeyString, str},
+			{[]byte("uuid"), ShardKeyBytes, "uuid"},
+		} {
+			key, err := NewShardKey(tc.value)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if key.Kind() != tc.kind || key.String() != tc.text {
+				t.Fatalf("invalid shard key %s", key)
+			}
+		}
+		var nilPtr *int64
+		for _, value := range []interface{}{nil, nilPtr, 1.5} {
+			if _, err := NewShardKey(value); err == nil {
+				t.Fatal("cannot handle error")
+			}
+		}
+	})
+	t.Run("int64", func(t *testing.T) {
+		if id, ok := NewUintKey(10).Int64(); !ok || id != 10 {
+			t.Fatal("cannot convert to int64")
+		}
+		if _, ok := NewUintKey(1 << 63).Int64(); ok {
+			t.Fatal("cannot handle overflow")
+		}
+		if _, ok := NewStringKey("10").Int64(); ok {
+			t.Fatal("cannot handle string key")
+		}
+	})
+	t.Run("comparable", func(t *testing.T) {
+		if NewBytesKey([]byte("a")) != NewBytesKey([]byte("a")) {
+			t.Fatal("cannot compare bytes key")
+		}
+		if NewBytesKey([]byte("a")) == NewStringKey("a") {
+			t.Fatal("cannot compare different kind")
+		}
+		if (ShardKey{}).IsValid() {
+			t.Fatal("invalid zero value")
+		}
+	})
+	t.Run("time key", func(t *testing.T) {
+		jst := time.FixedZone("JST", 9*60*60)
+		value := time.Date(2024, 2, 1, 9, 0, 0, 0, jst)
+		key, err := NewShardKey(&value)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if key.Kind() != ShardKeyTime || key != NewTimeKey(value.UTC()) {
+			t.Fatal("cannot create time key")
+		}
+		if key.String() != "2024-02-01 00:00:00" {
+			t.Fatalf("invalid time key %s", key)
+		}
+		for _, k := range []ShardKey{key, NewStringKey("2024-02-01 00:00:00"), NewStringKey("2024-02-01T09:00:00+09:00"), NewIntKey(value.Unix())} {
+			if v, ok := k.TimeIn(time.UTC); !ok || !v.Equal(value) {
+				t.Fatalf("cannot convert %s to time", k)
+			}
+		}
+		if _, ok := NewStringKey("user@example.com").TimeIn(time.UTC); ok {
+			t.Fatal("cannot handle invalid datetime")
+		}
+	})
+	t.Run("composite key", func(t *testing.T) {
+		key := NewCompositeKey(NewIntKey(1), NewStringKey(`a,"b"`), NewUintKey(1<<63))
+		if key.String() != `(1,"a,\"b\"",9223372036854775808)` {
+			t.Fatalf("invalid composite key %s", key)
+		}
+		if key != NewCompositeKey(NewIntKey(1), NewStringKey(`a,"b"`), NewUintKey(1<<63)) {
+			t.Fatal("cannot compare composite key")
+		}
+		components, err := key.Components()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if len(components) != 3 ||
+			components[0] != NewIntKey(1) ||
+			components[1] != NewStringKey(`a,"b"`) ||
+			components[2] != NewUintKey(1<<63) {
+			t.Fatalf("cannot decode composite key %v", components)
+		}
+		if _, ok := key.Int64(); ok {
+			t.Fatal("composite key is not integer")
+		}
+	})
+	t.Run("shard by key", func(t *testing.T) {
+		conn1, _ := sql.Open("sqlite3", "")
+		conn2, _ := sql.Open("sqlite3", "")
+		conns := []*sql.DB{conn1, conn2}
+		hashmap, err := LoadShardingAlgorithm("hashmap")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		hashmap.Init(conns)
+		byID, err := hashmap.Shard(conns, 10)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		byKey, err := ShardByKey(hashmap, conns, NewIntKey(10))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if byID != byKey {
+			t.Fatal("hash of integer key is changed")
+		}
+		if _, err := ShardByKey(hashmap, conns, NewStringKey("f47ac10b-58cc-4372-a567-0e02b2c3d479")); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		modulo, err := LoadShardingAlgorithm("modulo")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if conn, err := ShardByKey(modulo, conns, NewIntKey(1)); err != nil || conn != conn2 {
+			t.Fatal("cannot shard by integer key")
+		}
+		if _, err := ShardByKey(modulo, conns, NewStringKey("user@example.com")); err == nil {
+			t.Fatal("cannot handle unsupported key")
+		}
+	})
+}
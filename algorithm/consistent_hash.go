@@ -0,0 +1,116 @@
+package algorithm
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
+	"github.com/aokabi/octillery/debug"
+)
+
+const (
+	defaultVirtualNodeNum = 160
+)
+
+type consistentHashNode struct {
+	hash      uint64
+	connIndex int
+}
+
+type consistentHashShardingAlgorithm struct {
+	virtualNodeNum int
+	shardNames     []string
+	weights        []int
+	nodes          []*consistentHashNode
+}
+
+func consistentHash(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value)
+	return h.Sum64()
+}
+
+// Configure read virtual_nodes, shard names and weights.
+// Virtual nodes are placed by shard name, so adding new shard moves only keys for it.
+// Each shard has virtual_nodes multiplied by its weight.
+func (c *consistentHashShardingAlgorithm) Configure(cfg *config.TableConfig) error {
+	if cfg.VirtualNodeNum < 0 {
+		return errors.Errorf("invalid virtual_nodes %d", cfg.VirtualNodeNum)
+	}
+	c.virtualNodeNum = cfg.VirtualNodeNum
+	c.shardNames = cfg.ShardNames()
+	c.weights = cfg.ShardWeights()
+	return nil
+}
+
+func (c *consistentHashShardingAlgorithm) weight(connIndex int) int {
+	if len(c.weights) <= connIndex {
+		return 1
+	}
+	return c.weights[connIndex]
+}
+
+func (c *consistentHashShardingAlgorithm) nodeName(connIndex int) string {
+	if len(c.shardNames) == 0 {
+		return fmt.Sprintf("%d", connIndex)
+	}
+	return c.shardNames[connIndex]
+}
+
+func (c *consistentHashShardingAlgorithm) Init(conns []*sql.DB) bool {
+	if len(conns) == 0 {
+		return false
+	}
+	if len(c.shardNames) > 0 && len(c.shardNames) != len(conns) {
+		return false
+	}
+	if c.virtualNodeNum == 0 {
+		c.virtualNodeNum = defaultVirtualNodeNum
+	}
+	c.nodes = make([]*consistentHashNode, 0, len(conns)*c.virtualNodeNum)
+	for idx := range conns {
+		name := c.nodeName(idx)
+		for i := 0; i < c.virtualNodeNum*c.weight(idx); i++ {
+			c.nodes = append(c.nodes, &consistentHashNode{
+				hash:      consistentHash([]byte(fmt.Sprintf("%s#%d", name, i))),
+				connIndex: idx,
+			})
+		}
+	}
+	sort.SliceStable(c.nodes, func(i, j int) bool {
+		return c.nodes[i].hash < c.nodes[j].hash
+	})
+	return true
+}
+
+func (c *consistentHashShardingAlgorithm) Shard(conns []*sql.DB, shardID int64) (*sql.DB, error) {
+	return c.ShardByKey(conns, NewIntKey(shardID))
+}
+
+func (c *consistentHashShardingAlgorithm) ShardByKey(conns []*sql.DB, key ShardKey) (*sql.DB, error) {
+	if len(c.nodes) == 0 {
+		return nil, errors.New("consistent hash ring is not initialized")
+	}
+	hash := consistentHash(key.Bytes())
+	nodeIndex := sort.Search(len(c.nodes), func(i int) bool {
+		return c.nodes[i].hash >= hash
+	})
+	if nodeIndex == len(c.nodes) {
+		nodeIndex = 0
+	}
+	connIndex := c.nodes[nodeIndex].connIndex
+	debug.Printf("shardKey = %s hash = %d connIndex = %d", key, hash, connIndex)
+	if connIndex >= len(conns) {
+		return nil, errors.Errorf("cannot get connection by index %d. shardKey = %s", connIndex, key)
+	}
+	return conns[connIndex], nil
+}
+
+func init() {
+	Register("consistent_hash", func() ShardingAlgorithm {
+		return &consistentHashShardingAlgorithm{}
+	})
+}
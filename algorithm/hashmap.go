@@ -2,15 +2,15 @@ package algorithm
 
 import (
 	"database/sql"
-	"fmt"
 	"hash/crc32"
 
 	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
 	"github.com/aokabi/octillery/debug"
 )
 
 const (
-	hashSlotMaxSize = 1023
+	hashSlotMaxSize = config.HashSlotNum
 )
 
 type hashMapCluster struct {
@@ -21,9 +21,43 @@ type hashMapCluster struct {
 
 type hashMapShardingAlgorithm struct {
 	hashSlotSize uint32
+	weights      []int
+	slots        [][]*config.HashSlotRangeConfig
 	clusters     []*hashMapCluster
 }
 
+// Configure read hash slots or weight of each shard.
+// If hash slots are declared explicitly, weight isn't used.
+func (h *hashMapShardingAlgorithm) Configure(cfg *config.TableConfig) error {
+	if err := cfg.HashSlotError(); err != nil {
+		return errors.WithStack(err)
+	}
+	slots, err := cfg.ShardHashSlots()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	h.slots = slots
+	weights := cfg.ShardWeights()
+	totalWeight := 0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	if totalWeight > hashSlotMaxSize {
+		return errors.Errorf("total weight %d exceeds the number of hash slots %d", totalWeight, hashSlotMaxSize)
+	}
+	h.weights = weights
+	return nil
+}
+
+func (h *hashMapShardingAlgorithm) isWeighted() bool {
+	for _, weight := range h.weights {
+		if weight != h.weights[0] {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *hashMapShardingAlgorithm) addCluster(startSlot uint32, endSlot uint32, conn *sql.DB) {
 	if h.clusters == nil {
 		h.clusters = make([]*hashMapCluster, 0)
@@ -48,6 +82,12 @@ func (h *hashMapShardingAlgorithm) Init(conns []*sql.DB) bool {
 	if len(conns) < 2 {
 		return false
 	}
+	if h.slots != nil {
+		return h.initDeclaredClusters(conns)
+	}
+	if h.isWeighted() {
+		return h.initWeightedClusters(conns)
+	}
 	eachClusterSlotNum := uint32(hashSlotMaxSize / len(conns))
 	startSlotNum := uint32(0)
 	endSlotNum := eachClusterSlotNum
@@ -64,13 +104,76 @@ func (h *hashMapShardingAlgorithm) Init(conns []*sql.DB) bool {
 	return true
 }
 
+// initDeclaredClusters assigns hash slots declared in configuration file to shards.
+// Slot ownership doesn't depend on order of shards.
+func (h *hashMapShardingAlgorithm) initDeclaredClusters(conns []*sql.DB) bool {
+	if len(h.slots) != len(conns) {
+		return false
+	}
+	for idx, conn := range conns {
+		for _, r := range h.slots[idx] {
+			h.addCluster(r.Start, r.End, conn)
+		}
+	}
+	h.hashSlotSize = hashSlotMaxSize
+	return true
+}
+
+// initWeightedClusters allocates hash slots to shards in proportion to weight of them.
+// If all shards have the same weight, slots are allocated evenly by Init to keep existing layout.
+func (h *hashMapShardingAlgorithm) initWeightedClusters(conns []*sql.DB) bool {
+	if len(h.weights) != len(conns) {
+		return false
+	}
+	totalWeight := 0
+	for _, weight := range h.weights {
+		totalWeight += weight
+	}
+	startSlotNum := uint32(0)
+	cumulativeWeight := 0
+	lastIndex := len(conns) - 1
+	for idx, conn := range conns {
+		cumulativeWeight += h.weights[idx]
+		endSlotNum := uint32(hashSlotMaxSize*cumulativeWeight/totalWeight) - 1
+		if idx == lastIndex {
+			endSlotNum = hashSlotMaxSize
+		}
+		h.addCluster(startSlotNum, endSlotNum, conn)
+		startSlotNum = endSlotNum + 1
+	}
+	h.hashSlotSize = hashSlotMaxSize
+	return true
+}
+
+// HashSlots returns ranges of hash slots assigned to each connection.
+func (h *hashMapShardingAlgorithm) HashSlots(conns []*sql.DB) [][]*HashSlotRange {
+	slots := make([][]*HashSlotRange, len(conns))
+	for _, cluster := range h.clusters {
+		for idx, conn := range conns {
+			if conn != cluster.conn {
+				continue
+			}
+			endSlot := cluster.endSlot
+			if endSlot >= h.hashSlotSize {
+				endSlot = h.hashSlotSize - 1
+			}
+			slots[idx] = append(slots[idx], &HashSlotRange{Start: cluster.startSlot, End: endSlot})
+		}
+	}
+	return slots
+}
+
 func (h *hashMapShardingAlgorithm) Shard(conns []*sql.DB, shardID int64) (*sql.DB, error) {
-	hash := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%d", shardID)))
+	return h.ShardByKey(conns, NewIntKey(shardID))
+}
+
+func (h *hashMapShardingAlgorithm) ShardByKey(conns []*sql.DB, key ShardKey) (*sql.DB, error) {
+	hash := crc32.ChecksumIEEE(key.Bytes())
 	hashSlot := hash % h.hashSlotSize
 	clusterIndex, err := h.hashSlotToClusterIndex(hashSlot)
-	debug.Printf("shardId = %d hash = %d hashSlot = %d clusterIndex = %d", shardID, hash, hashSlot, clusterIndex)
+	debug.Printf("shardKey = %s hash = %d hashSlot = %d clusterIndex = %d", key, hash, hashSlot, clusterIndex)
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot get clusterIndex from hashSlot %d. shardId = %d, hash = %d", hashSlot, shardID, hash)
+		return nil, errors.Wrapf(err, "cannot get clusterIndex from hashSlot %d. shardKey = %s, hash = %d", hashSlot, key, hash)
 	}
 	return h.clusters[clusterIndex].conn, nil
 }
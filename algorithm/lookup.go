@@ -0,0 +1,165 @@
+package algorithm
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
+	"github.com/aokabi/octillery/connection/adapter"
+	"github.com/aokabi/octillery/debug"
+)
+
+const (
+	unmappedShardIndex = -1
+)
+
+type lookupCache struct {
+	key        ShardKey
+	shardIndex int
+	expiredAt  time.Time
+}
+
+type lookupShardingAlgorithm struct {
+	lookup     *config.LookupConfig
+	query      string
+	conn       *sql.DB
+	fallback   ShardingAlgorithm
+	shardNames []string
+	cacheMu    sync.Mutex
+	cache      map[ShardKey]*list.Element
+	cacheList  *list.List
+}
+
+// Configure open connection to mapping table and load fallback algorithm.
+func (l *lookupShardingAlgorithm) Configure(cfg *config.TableConfig) error {
+	lookup := cfg.Lookup
+	if lookup == nil || lookup.Database == nil {
+		return errors.New("cannot find lookup definition. lookup table must be resolved by config.Load()")
+	}
+	dbAdapter, err := adapter.Adapter(lookup.Database.Adapter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fallbackConfig := *cfg
+	fallbackConfig.Algorithm = lookup.Fallback
+	fallback, err := LoadShardingAlgorithmByConfig(&fallbackConfig)
+	if err != nil {
+		return errors.Wrap(err, "cannot load fallback algorithm")
+	}
+	conn, err := dbAdapter.OpenConnection(lookup.Database, "")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	l.lookup = lookup
+	l.query = fmt.Sprintf("select %s from %s where %s = ?", lookup.ShardColumnName, lookup.TableName, lookup.KeyColumnName)
+	l.conn = conn
+	l.fallback = fallback
+	l.shardNames = cfg.ShardNames()
+	l.cache = map[ShardKey]*list.Element{}
+	l.cacheList = list.New()
+	return nil
+}
+
+func (l *lookupShardingAlgorithm) Init(conns []*sql.DB) bool {
+	if l.conn == nil || len(l.shardNames) != len(conns) {
+		return false
+	}
+	return l.fallback.Init(conns)
+}
+
+func (l *lookupShardingAlgorithm) cachedShardIndex(key ShardKey) (int, bool) {
+	l.cacheMu.Lock()
+	defer l.cacheMu.Unlock()
+	elem, exists := l.cache[key]
+	if !exists {
+		return 0, false
+	}
+	cache := elem.Value.(*lookupCache)
+	if l.lookup.CacheTTL > 0 && time.Now().After(cache.expiredAt) {
+		l.cacheList.Remove(elem)
+		delete(l.cache, key)
+		return 0, false
+	}
+	l.cacheList.MoveToFront(elem)
+	return cache.shardIndex, true
+}
+
+// setCache caches mapped shard index. least recently used mapping is evicted if cache is full.
+func (l *lookupShardingAlgorithm) setCache(key ShardKey, shardIndex int) {
+	l.cacheMu.Lock()
+	defer l.cacheMu.Unlock()
+	expiredAt := time.Now().Add(time.Duration(l.lookup.CacheTTL) * time.Second)
+	if elem, exists := l.cache[key]; exists {
+		cache := elem.Value.(*lookupCache)
+		cache.shardIndex = shardIndex
+		cache.expiredAt = expiredAt
+		l.cacheList.MoveToFront(elem)
+		return
+	}
+	l.cache[key] = l.cacheList.PushFront(&lookupCache{
+		key:        key,
+		shardIndex: shardIndex,
+		expiredAt:  expiredAt,
+	})
+	for l.cacheList.Len() > l.lookup.MaxCacheSize() {
+		oldest := l.cacheList.Back()
+		l.cacheList.Remove(oldest)
+		delete(l.cache, oldest.Value.(*lookupCache).key)
+	}
+}
+
+func (l *lookupShardingAlgorithm) lookupShardIndex(key ShardKey) (int, error) {
+	if shardIndex, exists := l.cachedShardIndex(key); exists {
+		return shardIndex, nil
+	}
+	var shardName string
+	if err := l.conn.QueryRow(l.query, key.Value()).Scan(&shardName); err != nil {
+		if err != sql.ErrNoRows {
+			return 0, errors.Wrapf(err, "cannot lookup shard by shardKey %s", key)
+		}
+		// don't cache missing key to find mapping inserted later
+		return unmappedShardIndex, nil
+	}
+	for idx, name := range l.shardNames {
+		if name == shardName {
+			l.setCache(key, idx)
+			return idx, nil
+		}
+	}
+	return 0, errors.Errorf("unknown shard name %s for shardKey %s", shardName, key)
+}
+
+func (l *lookupShardingAlgorithm) Shard(conns []*sql.DB, shardID int64) (*sql.DB, error) {
+	return l.ShardByKey(conns, NewIntKey(shardID))
+}
+
+func (l *lookupShardingAlgorithm) ShardByKey(conns []*sql.DB, key ShardKey) (*sql.DB, error) {
+	shardIndex, err := l.lookupShardIndex(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if shardIndex == unmappedShardIndex {
+		debug.Printf("shardKey = %s is not found in lookup table. use fallback algorithm", key)
+		return ShardByKey(l.fallback, conns, key)
+	}
+	debug.Printf("shardKey = %s shardIndex = %d", key, shardIndex)
+	return conns[shardIndex], nil
+}
+
+// Close close connection to mapping table.
+func (l *lookupShardingAlgorithm) Close() error {
+	if l.conn == nil {
+		return nil
+	}
+	return l.conn.Close()
+}
+
+func init() {
+	Register("lookup", func() ShardingAlgorithm {
+		return &lookupShardingAlgorithm{}
+	})
+}
@@ -0,0 +1,74 @@
+package algorithm
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
+	"github.com/aokabi/octillery/debug"
+)
+
+type rangeShard struct {
+	shardRange *config.RangeConfig
+	conn       *sql.DB
+}
+
+type rangeShardingAlgorithm struct {
+	ranges []*config.RangeConfig
+	shards []*rangeShard
+}
+
+// Configure read range of each shard.
+func (r *rangeShardingAlgorithm) Configure(cfg *config.TableConfig) error {
+	if err := cfg.RangeError(); err != nil {
+		return errors.WithStack(err)
+	}
+	r.ranges = cfg.ShardRanges()
+	return nil
+}
+
+func (r *rangeShardingAlgorithm) Init(conns []*sql.DB) bool {
+	if len(conns) == 0 || len(r.ranges) != len(conns) {
+		return false
+	}
+	r.shards = make([]*rangeShard, 0, len(conns))
+	for idx, conn := range conns {
+		r.shards = append(r.shards, &rangeShard{
+			shardRange: r.ranges[idx],
+			conn:       conn,
+		})
+	}
+	return true
+}
+
+func (r *rangeShardingAlgorithm) Shard(conns []*sql.DB, shardID int64) (*sql.DB, error) {
+	for idx, shard := range r.shards {
+		if shard.shardRange.Contains(shardID) {
+			debug.Printf("shardId = %d shardIndex = %d", shardID, idx)
+			return shard.conn, nil
+		}
+	}
+	return nil, errors.Errorf("cannot find shard for shardId %d", shardID)
+}
+
+// ShardsByRange returns shards whose range overlaps keyRange.
+func (r *rangeShardingAlgorithm) ShardsByRange(conns []*sql.DB, keyRange *ShardKeyRange) ([]*sql.DB, error) {
+	shardConns := []*sql.DB{}
+	for idx, shard := range r.shards {
+		if keyRange.To != nil && *keyRange.To <= shard.shardRange.From {
+			continue
+		}
+		if keyRange.From != nil && shard.shardRange.To != nil && *shard.shardRange.To <= *keyRange.From {
+			continue
+		}
+		debug.Printf("range = %s shardIndex = %d", keyRange, idx)
+		shardConns = append(shardConns, shard.conn)
+	}
+	return shardConns, nil
+}
+
+func init() {
+	Register("range", func() ShardingAlgorithm {
+		return &rangeShardingAlgorithm{}
+	})
+}
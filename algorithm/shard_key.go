@@ -0,0 +1,245 @@
+package algorithm
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ShardKeyKind the kind of value held by ShardKey
+type ShardKeyKind int
+
+const (
+	// ShardKeyInvalid the kind of ShardKey that doesn't have value
+	ShardKeyInvalid ShardKeyKind = iota
+	// ShardKeyInt the kind of signed integer value
+	ShardKeyInt
+	// ShardKeyUint the kind of unsigned integer value
+	ShardKeyUint
+	// ShardKeyString the kind of string value ( e.g. email, UUID )
+	ShardKeyString
+	// ShardKeyBytes the kind of binary value ( e.g. BINARY(16) UUID )
+	ShardKeyBytes
+	// ShardKeyComposite the kind of tuple of values for composite shard_key
+	ShardKeyComposite
+	// ShardKeyTime the kind of datetime value ( e.g. created_at )
+	ShardKeyTime
+)
+
+// shardKeyTimeLayouts are layouts to parse string value as datetime
+var shardKeyTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// ShardKey is the value of sharding key passed to sharding algorithm.
+// It holds int64, uint64, string, []byte or time.Time value and is comparable by == operator.
+type ShardKey struct {
+	kind      ShardKeyKind
+	intValue  int64
+	uintValue uint64
+	strValue  string
+}
+
+// NewIntKey creates ShardKey by signed integer value
+func NewIntKey(value int64) ShardKey {
+	return ShardKey{kind: ShardKeyInt, intValue: value}
+}
+
+// NewUintKey creates ShardKey by unsigned integer value.
+// Value that can be represented by int64 is the same key as NewIntKey, so signed and unsigned arguments are routed to the same shard.
+func NewUintKey(value uint64) ShardKey {
+	if value <= uint64(math.MaxInt64) {
+		return NewIntKey(int64(value))
+	}
+	return ShardKey{kind: ShardKeyUint, uintValue: value}
+}
+
+// NewStringKey creates ShardKey by string value
+func NewStringKey(value string) ShardKey {
+	return ShardKey{kind: ShardKeyString, strValue: value}
+}
+
+// NewBytesKey creates ShardKey by binary value
+func NewBytesKey(value []byte) ShardKey {
+	return ShardKey{kind: ShardKeyBytes, strValue: string(value)}
+}
+
+// NewTimeKey creates ShardKey by datetime value.
+// Time zone isn't held, so the same instant in different time zone is the same key.
+func NewTimeKey(value time.Time) ShardKey {
+	return ShardKey{kind: ShardKeyTime, intValue: value.UnixNano()}
+}
+
+// NewCompositeKey creates ShardKey by tuple of values ordered by shard_key columns.
+// Values are encoded to single text like (1,"user@example.com") to keep ShardKey comparable.
+func NewCompositeKey(keys ...ShardKey) ShardKey {
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		switch key.kind {
+		case ShardKeyString, ShardKeyBytes, ShardKeyTime:
+			values = append(values, strconv.Quote(key.String()))
+		default:
+			values = append(values, key.String())
+		}
+	}
+	return ShardKey{kind: ShardKeyComposite, strValue: "(" + strings.Join(values, ",") + ")"}
+}
+
+// NewShardKey creates ShardKey by value of query argument.
+// Supported types are integer, string, []byte, time.Time and pointer of them.
+func NewShardKey(value interface{}) (ShardKey, error) {
+	if bytes, ok := value.([]byte); ok {
+		return NewBytesKey(bytes), nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ShardKey{}, errors.New("shard_key does not allow nil")
+		}
+		v = v.Elem()
+	}
+	if v.IsValid() && v.Type() == reflect.TypeOf(time.Time{}) {
+		return NewTimeKey(v.Interface().(time.Time)), nil
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewIntKey(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return NewUintKey(v.Uint()), nil
+	case reflect.String:
+		return NewStringKey(v.String()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return NewBytesKey(v.Bytes()), nil
+		}
+	case reflect.Invalid:
+		return ShardKey{}, errors.New("shard_key does not allow nil")
+	}
+	return ShardKey{}, errors.Errorf("unsupport shard_key type %s", reflect.TypeOf(value))
+}
+
+// Kind returns kind of value
+func (k ShardKey) Kind() ShardKeyKind {
+	return k.kind
+}
+
+// IsValid returns whether ShardKey has value or not
+func (k ShardKey) IsValid() bool {
+	return k.kind != ShardKeyInvalid
+}
+
+// Int64 returns integer value. If value cannot be represented by int64, returns false.
+func (k ShardKey) Int64() (int64, bool) {
+	switch k.kind {
+	case ShardKeyInt:
+		return k.intValue, true
+	case ShardKeyUint:
+		if k.uintValue <= uint64(math.MaxInt64) {
+			return int64(k.uintValue), true
+		}
+	}
+	return 0, false
+}
+
+// TimeIn returns datetime value in loc.
+// String value is parsed as datetime in loc, and integer value is treated as unix time in seconds.
+// If value cannot be represented by time.Time, returns false.
+func (k ShardKey) TimeIn(loc *time.Location) (time.Time, bool) {
+	switch k.kind {
+	case ShardKeyTime:
+		return time.Unix(0, k.intValue).In(loc), true
+	case ShardKeyString:
+		for _, layout := range shardKeyTimeLayouts {
+			if t, err := time.ParseInLocation(layout, k.strValue, loc); err == nil {
+				return t.In(loc), true
+			}
+		}
+	case ShardKeyInt, ShardKeyUint:
+		if sec, ok := k.Int64(); ok {
+			return time.Unix(sec, 0).In(loc), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Bytes returns value as byte sequence for hashing.
+// Integer value is formatted as decimal, so it is compatible with hash of previous versions.
+func (k ShardKey) Bytes() []byte {
+	return []byte(k.String())
+}
+
+// Components returns values of composite shard_key by order of shard_key columns.
+// Binary value in tuple is returned as string value.
+func (k ShardKey) Components() ([]ShardKey, error) {
+	if k.kind != ShardKeyComposite {
+		return []ShardKey{k}, nil
+	}
+	text := strings.TrimSuffix(strings.TrimPrefix(k.strValue, "("), ")")
+	keys := []ShardKey{}
+	for len(text) > 0 {
+		var value string
+		if text[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(text)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			unquoted, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			keys = append(keys, NewStringKey(unquoted))
+			text = text[len(quoted):]
+		} else {
+			if idx := strings.Index(text, ","); idx >= 0 {
+				value, text = text[:idx], text[idx:]
+			} else {
+				value, text = text, ""
+			}
+			if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+				keys = append(keys, NewIntKey(id))
+			} else if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+				keys = append(keys, NewUintKey(id))
+			} else {
+				return nil, errors.Errorf("invalid composite shard_key %s", k.strValue)
+			}
+		}
+		text = strings.TrimPrefix(text, ",")
+	}
+	return keys, nil
+}
+
+// Value returns value for query argument
+func (k ShardKey) Value() interface{} {
+	switch k.kind {
+	case ShardKeyInt:
+		return k.intValue
+	case ShardKeyUint:
+		return k.uintValue
+	case ShardKeyString:
+		return k.strValue
+	case ShardKeyBytes:
+		return []byte(k.strValue)
+	case ShardKeyTime:
+		return time.Unix(0, k.intValue).UTC()
+	}
+	return nil
+}
+
+// String returns value as string. Integer value is formatted as decimal, and datetime value is formatted in UTC.
+func (k ShardKey) String() string {
+	switch k.kind {
+	case ShardKeyTime:
+		return time.Unix(0, k.intValue).UTC().Format(shardKeyTimeLayouts[0])
+	case ShardKeyInt:
+		return strconv.FormatInt(k.intValue, 10)
+	case ShardKeyUint:
+		return strconv.FormatUint(k.uintValue, 10)
+	}
+	return k.strValue
+}
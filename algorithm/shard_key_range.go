@@ -0,0 +1,83 @@
+package algorithm
+
+import (
+	"fmt"
+	"math"
+)
+
+// ShardKeyRange is the range of integer shard_key. range is [From, To).
+// If From or To is nil, range is unbounded for the direction.
+type ShardKeyRange struct {
+	From *int64
+	To   *int64
+}
+
+// NewShardKeyRangeByValue creates range including only value.
+func NewShardKeyRangeByValue(value int64) *ShardKeyRange {
+	if value == math.MaxInt64 {
+		return &ShardKeyRange{From: &value}
+	}
+	to := value + 1
+	return &ShardKeyRange{From: &value, To: &to}
+}
+
+// Contains returns whether range includes value or not.
+func (r *ShardKeyRange) Contains(value int64) bool {
+	if r.From != nil && value < *r.From {
+		return false
+	}
+	return r.To == nil || value < *r.To
+}
+
+// IsEmpty returns whether range doesn't include any value.
+func (r *ShardKeyRange) IsEmpty() bool {
+	return r.From != nil && r.To != nil && *r.From >= *r.To
+}
+
+// Intersect returns range included by both ranges.
+func (r *ShardKeyRange) Intersect(other *ShardKeyRange) *ShardKeyRange {
+	intersected := &ShardKeyRange{From: r.From, To: r.To}
+	if other.From != nil && (intersected.From == nil || *other.From > *intersected.From) {
+		intersected.From = other.From
+	}
+	if other.To != nil && (intersected.To == nil || *other.To < *intersected.To) {
+		intersected.To = other.To
+	}
+	return intersected
+}
+
+// Union returns the smallest range including both ranges.
+func (r *ShardKeyRange) Union(other *ShardKeyRange) *ShardKeyRange {
+	if r.IsEmpty() {
+		return other
+	}
+	if other.IsEmpty() {
+		return r
+	}
+	united := &ShardKeyRange{}
+	if r.From != nil && other.From != nil {
+		united.From = r.From
+		if *other.From < *r.From {
+			united.From = other.From
+		}
+	}
+	if r.To != nil && other.To != nil {
+		united.To = r.To
+		if *other.To > *r.To {
+			united.To = other.To
+		}
+	}
+	return united
+}
+
+func (r *ShardKeyRange) String() string {
+	from := "-inf"
+	if r.From != nil {
+		from = fmt.Sprint(*r.From)
+	}
+	to := "+inf"
+	if r.To != nil {
+		to = fmt.Sprint(*r.To)
+	}
+	return fmt.Sprintf("[%s, %s)", from, to)
+}
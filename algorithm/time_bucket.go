@@ -0,0 +1,107 @@
+package algorithm
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
+	"github.com/aokabi/octillery/debug"
+)
+
+const (
+	daysPerWeek = 7
+)
+
+type timeBucketShardingAlgorithm struct {
+	unit            string
+	start           time.Time
+	bucketsPerShard int
+	location        *time.Location
+}
+
+// Configure read unit, start and rotation of buckets.
+func (t *timeBucketShardingAlgorithm) Configure(cfg *config.TableConfig) error {
+	if err := cfg.TimeBucketError(); err != nil {
+		return errors.WithStack(err)
+	}
+	loc, err := cfg.TimeBucket.TimeLocation()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	start, err := cfg.TimeBucket.StartTime()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	t.unit = cfg.TimeBucket.Unit
+	t.start = start
+	t.location = loc
+	t.bucketsPerShard = cfg.TimeBucket.BucketsPerShard
+	if t.bucketsPerShard == 0 {
+		t.bucketsPerShard = 1
+	}
+	return nil
+}
+
+func (t *timeBucketShardingAlgorithm) Init(conns []*sql.DB) bool {
+	if len(conns) == 0 || t.location == nil {
+		return false
+	}
+	return true
+}
+
+// daysFromEpoch returns the number of days from 1970-01-01 to the date of value.
+func daysFromEpoch(value time.Time) int64 {
+	year, month, day := value.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+}
+
+// floorDiv divides a by b rounding toward negative infinity.
+func floorDiv(a int64, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// bucketIndex returns the number of buckets from the first bucket to the bucket of value.
+// If value is before the first bucket, it returns negative number.
+func (t *timeBucketShardingAlgorithm) bucketIndex(value time.Time) int64 {
+	value = value.In(t.location)
+	if t.unit == "month" {
+		return int64(value.Year()*12+int(value.Month())) - int64(t.start.Year()*12+int(t.start.Month()))
+	}
+	// week starts on Monday. 1970-01-01 is Thursday
+	weekOf := func(days int64) int64 {
+		return floorDiv(days+3, daysPerWeek)
+	}
+	return weekOf(daysFromEpoch(value)) - weekOf(daysFromEpoch(t.start))
+}
+
+func (t *timeBucketShardingAlgorithm) Shard(conns []*sql.DB, shardID int64) (*sql.DB, error) {
+	return t.ShardByKey(conns, NewIntKey(shardID))
+}
+
+// ShardByKey assigns shard by bucket of datetime value.
+// Buckets are mapped to shards in rotation, so new bucket is mapped to the next shard of previous bucket.
+func (t *timeBucketShardingAlgorithm) ShardByKey(conns []*sql.DB, key ShardKey) (*sql.DB, error) {
+	value, ok := key.TimeIn(t.location)
+	if !ok {
+		return nil, errors.Errorf("cannot convert shard_key %s to datetime", key)
+	}
+	bucketIndex := t.bucketIndex(value)
+	shardNum := int64(len(conns))
+	shardIndex := floorDiv(bucketIndex, int64(t.bucketsPerShard)) % shardNum
+	if shardIndex < 0 {
+		shardIndex += shardNum
+	}
+	debug.Printf("shardKey = %s bucketIndex = %d shardIndex = %d", key, bucketIndex, shardIndex)
+	return conns[shardIndex], nil
+}
+
+func init() {
+	Register("time_bucket", func() ShardingAlgorithm {
+		return &timeBucketShardingAlgorithm{}
+	})
+}
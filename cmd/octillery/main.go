@@ -40,6 +40,7 @@ type Option struct {
 	Console   ConsoleCommand   `description:"database console" command:"console"`
 	Install   InstallCommand   `description:"install database adapter" command:"install"`
 	Shard     ShardCommand     `description:"get sharded database information by sharding key" command:"shard"`
+	Recover   RecoverCommand   `description:"recover in-doubt distributed transactions by coordinator log" command:"recover"`
 }
 
 // VersionCommand type for version command
@@ -77,8 +78,16 @@ type InstallCommand struct {
 
 // ShardCommand type for shard command
 type ShardCommand struct {
-	ShardID int64  `long:"id"     short:"i" description:"id of sharding key column" required:"id"`
-	Config  string `long:"config" short:"c" description:"database configuration file path" required:"config path"`
+	ShardID  int64  `long:"id"     short:"i" description:"id of sharding key column"`
+	ShardKey string `long:"key"    short:"k" description:"string value of sharding key column ( e.g. email, UUID )"`
+	Layout   bool   `long:"layout" short:"l" description:"print weight and hash slots of each shard"`
+	Config   string `long:"config" short:"c" description:"database configuration file path" required:"config path"`
+}
+
+// RecoverCommand type for recover command
+type RecoverCommand struct {
+	DryRun bool   `long:"dry-run"          description:"show in-doubt transactions only"`
+	Config string `long:"config" short:"c" description:"database configuration file path" required:"config path"`
 }
 
 var opts Option
@@ -620,7 +629,7 @@ func (cmd *ShardCommand) Execute(args []string) error {
 	if !tableConfig.IsShard {
 		return errors.Errorf("%s table is not sharded", tableName)
 	}
-	logic, err := algorithm.LoadShardingAlgorithm(tableConfig.Algorithm)
+	logic, err := algorithm.LoadShardingAlgorithmByConfig(tableConfig)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -637,7 +646,14 @@ func (cmd *ShardCommand) Execute(args []string) error {
 	if !logic.Init(conns) {
 		return errors.New("cannot initialize sharding algorithm")
 	}
-	conn, err := logic.Shard(conns, cmd.ShardID)
+	if cmd.Layout {
+		return errors.WithStack(cmd.printLayout(tableConfig, logic, conns))
+	}
+	key := algorithm.NewIntKey(cmd.ShardID)
+	if cmd.ShardKey != "" {
+		key = algorithm.NewStringKey(cmd.ShardKey)
+	}
+	conn, err := algorithm.ShardByKey(logic, conns, key)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -663,6 +679,76 @@ func (cmd *ShardCommand) Execute(args []string) error {
 	return errors.New("cannot find target database")
 }
 
+// printLayout prints weight and hash slots of each shard by order of shards.
+// Hash slots are printed only if sharding algorithm assigns them to shards.
+func (cmd *ShardCommand) printLayout(tableConfig *config.TableConfig, logic algorithm.ShardingAlgorithm, conns []*coresql.DB) error {
+	var slots [][]*algorithm.HashSlotRange
+	if slotLogic, ok := logic.(algorithm.HashSlotAlgorithm); ok {
+		slots = slotLogic.HashSlots(conns)
+	}
+	weights := tableConfig.ShardWeights()
+	for idx, shardName := range tableConfig.ShardNames() {
+		info := struct {
+			Name   string   `json:"name"`
+			Weight int      `json:"weight"`
+			Slots  []string `json:"slots,omitempty"`
+		}{
+			Name:   shardName,
+			Weight: weights[idx],
+		}
+		if idx < len(slots) {
+			for _, slot := range slots[idx] {
+				info.Slots = append(info.Slots, slot.String())
+			}
+		}
+		bytes, err := json.Marshal(info)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		fmt.Println(string(bytes))
+	}
+	return nil
+}
+
+// Execute executes recover command
+func (cmd *RecoverCommand) Execute(args []string) error {
+	if err := octillery.LoadConfig(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	store := connection.CoordinatorLog()
+	if store == nil {
+		return errors.New("coordinator_log is not defined in configuration file")
+	}
+	if cmd.DryRun {
+		logs, err := store.Logs()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, log := range logs {
+			bytes, err := json.Marshal(log)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			fmt.Println(string(bytes))
+		}
+		return nil
+	}
+	db, err := sql.Open("", "")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer db.Close()
+	results, err := db.Recover()
+	for _, result := range results {
+		bytes, err := json.Marshal(result)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		fmt.Println(string(bytes))
+	}
+	return errors.WithStack(err)
+}
+
 func main() {
 	parser := flags.NewParser(&opts, flags.Default)
 	parser.Parse()
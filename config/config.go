@@ -3,6 +3,10 @@ package config
 import (
 	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
@@ -33,6 +37,60 @@ type DatabaseConfig struct {
 
 	// backup server's dsn list ( currently not support )
 	Backups []string `yaml:"backup"`
+
+	// range of shard_key assigned to this shard by range algorithm
+	Range *RangeConfig `yaml:"range"`
+
+	// relative weight of this shard for hashmap and consistent_hash algorithm. if not specified, weight is 1
+	Weight int `yaml:"weight"`
+
+	// hash slots assigned to this shard by hashmap algorithm ( e.g. ["0-511", "1000"] )
+	Slots []string `yaml:"slots"`
+}
+
+// HashSlotNum the number of hash slots used by hashmap algorithm
+const HashSlotNum = 1023
+
+// HashSlotRangeConfig type for range of hash slots. range is [Start, End].
+type HashSlotRangeConfig struct {
+	Start uint32
+	End   uint32
+}
+
+func parseHashSlotRange(value string) (*HashSlotRangeConfig, error) {
+	bounds := strings.SplitN(strings.TrimSpace(value), "-", 2)
+	start, err := strconv.ParseUint(strings.TrimSpace(bounds[0]), 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid hash slot %s", value)
+	}
+	end := start
+	if len(bounds) > 1 {
+		end, err = strconv.ParseUint(strings.TrimSpace(bounds[1]), 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid hash slot %s", value)
+		}
+	}
+	if start > end || end >= HashSlotNum {
+		return nil, errors.Errorf("invalid hash slot %s. slot must be between 0 and %d", value, HashSlotNum-1)
+	}
+	return &HashSlotRangeConfig{Start: uint32(start), End: uint32(end)}, nil
+}
+
+// RangeConfig type for range of shard_key. range is [from, to).
+type RangeConfig struct {
+	// minimum value of shard_key ( inclusive )
+	From int64 `yaml:"from"`
+
+	// maximum value of shard_key ( exclusive ). if not specified, range is unbounded
+	To *int64 `yaml:"to"`
+}
+
+// Contains returns whether range includes value or not.
+func (c *RangeConfig) Contains(value int64) bool {
+	if value < c.From {
+		return false
+	}
+	return c.To == nil || value < *c.To
 }
 
 // TableConfig type for table definition
@@ -48,16 +106,329 @@ type TableConfig struct {
 	// column name for deciding sharding target
 	// this column's value is passed to sharding algorithm
 	// if not specified, shard_column value is used as shard_key
-	ShardKeyColumnName string `yaml:"shard_key"`
+	// if multiple columns are listed as shard_key, column names are joined by comma
+	ShardKeyColumnName string `yaml:"-"`
+
+	// column names for deciding sharding target.
+	// multiple columns ( e.g. [region_id, user_id] ) are used as composite shard_key
+	ShardKeyColumnNames []string `yaml:"-"`
 
 	// sharding algorithm ( default: modulo )
 	Algorithm string `yaml:"algorithm"`
 
+	// number of virtual nodes for each shard used by consistent_hash algorithm ( default: 160 )
+	VirtualNodeNum int `yaml:"virtual_nodes"`
+
+	// mapping table definition used by lookup algorithm
+	Lookup *LookupConfig `yaml:"lookup"`
+
+	// bucket definition used by time_bucket algorithm
+	TimeBucket *TimeBucketConfig `yaml:"time_bucket"`
+
 	// support unique id in between all shards
 	Sequencer *DatabaseConfig `yaml:"sequencer"`
 
 	// shard configurations
 	Shards []map[string]*DatabaseConfig `yaml:"shards"`
+
+	// max number of shards accessed concurrently by query for all shards
+	// if not specified, max_parallelism of top level is used
+	MaxParallelism int `yaml:"max_parallelism"`
+
+	// allow DELETE query with WHERE clause not including shard_key.
+	// it is executed for all shards
+	AllowScatterDelete bool `yaml:"allow_scatter_delete"`
+
+	// allow UPDATE query not including shard_key.
+	// it is executed for all shards
+	AllowScatterWrite bool `yaml:"allow_scatter_write"`
+}
+
+// LookupConfig type for mapping table from shard_key to shard name.
+type LookupConfig struct {
+	// name of not sharded table defined in config file
+	TableName string `yaml:"table"`
+
+	// column name of shard_key value in mapping table
+	KeyColumnName string `yaml:"key_column"`
+
+	// column name of shard name in mapping table
+	ShardColumnName string `yaml:"shard_column"`
+
+	// sharding algorithm for shard_key not found in mapping table ( default: modulo )
+	Fallback string `yaml:"fallback"`
+
+	// seconds to cache mapping in process. if zero, cache never expires
+	CacheTTL int `yaml:"cache_ttl"`
+
+	// max number of mappings cached in process. least recently used mapping is evicted ( default: 10000 )
+	CacheSize int `yaml:"cache_size"`
+
+	// database configuration of mapping table. this is resolved by Load()
+	Database *DatabaseConfig `yaml:"-"`
+}
+
+// DefaultLookupCacheSize is default max number of mappings cached by lookup algorithm.
+const DefaultLookupCacheSize = 10000
+
+// MaxCacheSize returns max number of mappings cached in process.
+func (c *LookupConfig) MaxCacheSize() int {
+	if c.CacheSize == 0 {
+		return DefaultLookupCacheSize
+	}
+	return c.CacheSize
+}
+
+// TimeBucketConfig type for buckets of datetime shard_key.
+// Buckets are mapped to shards in rotation from the first shard.
+type TimeBucketConfig struct {
+	// unit of bucket. month or week ( week starts on Monday )
+	Unit string `yaml:"unit"`
+
+	// date of the first bucket mapped to the first shard like 2024-01-01 ( default: 1970-01-01 )
+	Start string `yaml:"start"`
+
+	// number of consecutive buckets mapped to the same shard ( default: 1 )
+	BucketsPerShard int `yaml:"buckets_per_shard"`
+
+	// time zone to decide bucket like Asia/Tokyo ( default: UTC )
+	Location string `yaml:"location"`
+}
+
+// TimeLocation returns time zone to decide bucket.
+func (c *TimeBucketConfig) TimeLocation() (*time.Location, error) {
+	if c.Location == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(c.Location)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return loc, nil
+}
+
+// StartTime returns the beginning of the first bucket.
+func (c *TimeBucketConfig) StartTime() (time.Time, error) {
+	loc, err := c.TimeLocation()
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+	if c.Start == "" {
+		return time.Date(1970, 1, 1, 0, 0, 0, 0, loc), nil
+	}
+	start, err := time.ParseInLocation("2006-01-02", c.Start, loc)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid start %s", c.Start)
+	}
+	return start, nil
+}
+
+// TimeBucketError returns error of bucket definition for time_bucket algorithm.
+func (c *TableConfig) TimeBucketError() error {
+	if c.TimeBucket == nil {
+		return errors.New("cannot find time_bucket definition")
+	}
+	switch c.TimeBucket.Unit {
+	case "month", "week":
+	default:
+		return errors.Errorf("invalid unit '%s'. unit must be month or week", c.TimeBucket.Unit)
+	}
+	if c.TimeBucket.BucketsPerShard < 0 {
+		return errors.Errorf("invalid buckets_per_shard %d", c.TimeBucket.BucketsPerShard)
+	}
+	if _, err := c.TimeBucket.StartTime(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// ShardRanges returns range of each shard by order of shards.
+// If range is not defined, it returns nil for the shard.
+func (c *TableConfig) ShardRanges() []*RangeConfig {
+	ranges := make([]*RangeConfig, 0, len(c.Shards))
+	for _, shard := range c.Shards {
+		for _, cfg := range shard {
+			ranges = append(ranges, cfg.Range)
+		}
+	}
+	return ranges
+}
+
+// RangeError returns error of range definition for range algorithm.
+// Ranges must be defined for all shards without gaps and overlaps.
+func (c *TableConfig) RangeError() error {
+	ranges := c.ShardRanges()
+	if len(ranges) == 0 {
+		return errors.New("cannot find shards for range algorithm")
+	}
+	for idx, r := range ranges {
+		if r == nil {
+			return errors.Errorf("cannot find range of shard at index %d", idx)
+		}
+		if r.To != nil && r.From >= *r.To {
+			return errors.Errorf("invalid range [%d, %d) of shard at index %d", r.From, *r.To, idx)
+		}
+	}
+	sorted := make([]*RangeConfig, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].From < sorted[j].From
+	})
+	for idx := 0; idx < len(sorted)-1; idx++ {
+		current, next := sorted[idx], sorted[idx+1]
+		if current.To == nil || *current.To > next.From {
+			return errors.Errorf("range overlaps at %d", next.From)
+		}
+		if *current.To < next.From {
+			return errors.Errorf("range has gap between %d and %d", *current.To, next.From)
+		}
+	}
+	return nil
+}
+
+// ShardWeights returns weight of each shard by order of shards.
+// If weight is not specified, it returns 1 for the shard.
+func (c *TableConfig) ShardWeights() []int {
+	weights := make([]int, 0, len(c.Shards))
+	for _, shard := range c.Shards {
+		for _, cfg := range shard {
+			weight := cfg.Weight
+			if weight == 0 {
+				weight = 1
+			}
+			weights = append(weights, weight)
+		}
+	}
+	return weights
+}
+
+// WeightError returns error of weight definition.
+func (c *TableConfig) WeightError() error {
+	for _, shard := range c.Shards {
+		for shardName, cfg := range shard {
+			if cfg.Weight < 0 {
+				return errors.Errorf("invalid weight %d of shard %s", cfg.Weight, shardName)
+			}
+		}
+	}
+	return nil
+}
+
+// ShardHashSlots returns hash slots of each shard by order of shards.
+// If hash slots are not declared for any shards, it returns nil.
+func (c *TableConfig) ShardHashSlots() ([][]*HashSlotRangeConfig, error) {
+	isDeclared := false
+	for _, shard := range c.Shards {
+		for _, cfg := range shard {
+			if len(cfg.Slots) > 0 {
+				isDeclared = true
+			}
+		}
+	}
+	if !isDeclared {
+		return nil, nil
+	}
+	slots := make([][]*HashSlotRangeConfig, 0, len(c.Shards))
+	for _, shard := range c.Shards {
+		for shardName, cfg := range shard {
+			if len(cfg.Slots) == 0 {
+				return nil, errors.Errorf("cannot find hash slots of shard %s", shardName)
+			}
+			if cfg.Weight != 0 {
+				return nil, errors.Errorf("cannot use both weight and slots for shard %s", shardName)
+			}
+			ranges := make([]*HashSlotRangeConfig, 0, len(cfg.Slots))
+			for _, value := range cfg.Slots {
+				r, err := parseHashSlotRange(value)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid hash slots of shard %s", shardName)
+				}
+				ranges = append(ranges, r)
+			}
+			slots = append(slots, ranges)
+		}
+	}
+	return slots, nil
+}
+
+// HashSlotError returns error of hash slots definition for hashmap algorithm.
+// If hash slots are declared, they must cover all slots without overlaps.
+func (c *TableConfig) HashSlotError() error {
+	slots, err := c.ShardHashSlots()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if slots == nil {
+		return nil
+	}
+	sorted := []*HashSlotRangeConfig{}
+	for _, ranges := range slots {
+		sorted = append(sorted, ranges...)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	nextSlot := uint32(0)
+	for _, r := range sorted {
+		if r.Start < nextSlot {
+			return errors.Errorf("hash slots overlap at %d", r.Start)
+		}
+		if r.Start > nextSlot {
+			return errors.Errorf("hash slots %d-%d are not assigned", nextSlot, r.Start-1)
+		}
+		nextSlot = r.End + 1
+	}
+	if nextSlot < HashSlotNum {
+		return errors.Errorf("hash slots %d-%d are not assigned", nextSlot, HashSlotNum-1)
+	}
+	return nil
+}
+
+// ShardNames returns name of each shard by order of shards.
+func (c *TableConfig) ShardNames() []string {
+	names := make([]string, 0, len(c.Shards))
+	for _, shard := range c.Shards {
+		for shardName := range shard {
+			names = append(names, shardName)
+		}
+	}
+	return names
+}
+
+// UnmarshalYAML decodes table definition. shard_key accepts single column name or list of column names.
+func (c *TableConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawTableConfig TableConfig
+	if err := unmarshal((*rawTableConfig)(c)); err != nil {
+		return errors.WithStack(err)
+	}
+	var shardKey struct {
+		Value interface{} `yaml:"shard_key"`
+	}
+	if err := unmarshal(&shardKey); err != nil {
+		return errors.WithStack(err)
+	}
+	switch value := shardKey.Value.(type) {
+	case nil:
+	case string:
+		c.ShardKeyColumnNames = []string{value}
+	case []interface{}:
+		for _, v := range value {
+			columnName, ok := v.(string)
+			if !ok {
+				return errors.Errorf("invalid shard_key column %v", v)
+			}
+			c.ShardKeyColumnNames = append(c.ShardKeyColumnNames, columnName)
+		}
+	default:
+		return errors.Errorf("invalid shard_key %v", value)
+	}
+	c.ShardKeyColumnName = strings.Join(c.ShardKeyColumnNames, ",")
+	return nil
+}
+
+// IsCompositeShardKey returns whether multiple columns are used as shard_key.
+func (c *TableConfig) IsCompositeShardKey() bool {
+	return len(c.ShardKeyColumnNames) > 1
 }
 
 // IsUsedSequencer returns whether 'sequencer' parameter is defined or not in table configuration.
@@ -92,14 +463,206 @@ func (c *TableConfig) Error() error {
 	return nil
 }
 
+// CoordinatorLogTableName is default table name for coordinator log stored in database.
+const CoordinatorLogTableName = "octillery_coordinator_logs"
+
+// DefaultCoordinatorLogGracePeriod is default time to wait before in-doubt transaction is recovered.
+const DefaultCoordinatorLogGracePeriod = time.Minute
+
+// CoordinatorLogConfig is a definition of durable log for committing distributed transaction.
+type CoordinatorLogConfig struct {
+	DatabaseConfig `yaml:",inline"`
+
+	// kind of store ( 'file' or 'sql' )
+	Store string `yaml:"store"`
+
+	// directory path to put log files for 'file' store
+	Path string `yaml:"path"`
+
+	// table name to put logs for 'sql' store ( default: octillery_coordinator_logs )
+	Table string `yaml:"table"`
+
+	// seconds to wait before in-doubt transaction is recovered. commit must finish in this period ( default: 60 )
+	GracePeriod int `yaml:"grace_period"`
+}
+
+// GracePeriodDuration returns time to wait before in-doubt transaction is recovered.
+func (c *CoordinatorLogConfig) GracePeriodDuration() time.Duration {
+	if c.GracePeriod == 0 {
+		return DefaultCoordinatorLogGracePeriod
+	}
+	return time.Duration(c.GracePeriod) * time.Second
+}
+
+// TableName returns table name for 'sql' store.
+func (c *CoordinatorLogConfig) TableName() string {
+	if c.Table == "" {
+		return CoordinatorLogTableName
+	}
+	return c.Table
+}
+
+// Error returns error if coordinator log definition is invalid.
+func (c *CoordinatorLogConfig) Error() error {
+	if c.GracePeriod < 0 {
+		return errors.New("grace_period must not be negative")
+	}
+	switch c.Store {
+	case "file":
+		if c.Path == "" {
+			return errors.New("path is required for file store")
+		}
+	case "sql":
+		if c.Adapter == "" || c.NameOrPath == "" {
+			return errors.New("adapter and database are required for sql store")
+		}
+	default:
+		return errors.Errorf("unknown store %s. store must be 'file' or 'sql'", c.Store)
+	}
+	return nil
+}
+
+// OutboxTableName is default table name for change events stored in database.
+const OutboxTableName = "octillery_outbox"
+
+// OutboxConfig is a definition of sink for change events published after commit.
+type OutboxConfig struct {
+	DatabaseConfig `yaml:",inline"`
+
+	// kind of sink ( 'file' or 'sql' )
+	Sink string `yaml:"sink"`
+
+	// file path to append change events for 'file' sink
+	Path string `yaml:"path"`
+
+	// table name to insert change events for 'sql' sink ( default: octillery_outbox )
+	Table string `yaml:"table"`
+}
+
+// TableName returns table name for 'sql' sink.
+func (c *OutboxConfig) TableName() string {
+	if c.Table == "" {
+		return OutboxTableName
+	}
+	return c.Table
+}
+
+// Error returns error if outbox definition is invalid.
+func (c *OutboxConfig) Error() error {
+	switch c.Sink {
+	case "file":
+		if c.Path == "" {
+			return errors.New("path is required for file sink")
+		}
+	case "sql":
+		if c.Adapter == "" || c.NameOrPath == "" {
+			return errors.New("adapter and database are required for sql sink")
+		}
+	default:
+		return errors.Errorf("unknown sink %s. sink must be 'file' or 'sql'", c.Sink)
+	}
+	return nil
+}
+
 // A Config is a database configuration includes database sharding definition.
 type Config struct {
-	// distributed transaction support
-	DistributedTransaction bool `yaml:"distributed_transaction"`
+	// distributed transaction support ( distributed_transaction accepts boolean or "xa" )
+	DistributedTransaction bool `yaml:"-"`
+	// commit distributed transaction by two-phase commit with XA transaction ( MySQL only )
+	XATransaction bool `yaml:"-"`
+	// durable log for recovering distributed transaction ( default: disabled )
+	CoordinatorLog *CoordinatorLogConfig `yaml:"coordinator_log"`
+	// sink for change events of committed write queries ( default: disabled )
+	Outbox *OutboxConfig `yaml:"outbox"`
 	// map table name and configuration
 	Tables map[string]*TableConfig `yaml:"tables"`
 	// if true skip auto create database
 	SkipAutoSetup bool `yaml:"skip_auto_setup"`
+	// max number of shards accessed concurrently by query for all shards ( default: unlimited )
+	MaxParallelism int `yaml:"max_parallelism"`
+}
+
+// UnmarshalYAML decodes configuration. distributed_transaction accepts boolean or "xa".
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawConfig Config
+	if err := unmarshal((*rawConfig)(c)); err != nil {
+		return errors.WithStack(err)
+	}
+	var distributedTransaction struct {
+		Value interface{} `yaml:"distributed_transaction"`
+	}
+	if err := unmarshal(&distributedTransaction); err != nil {
+		return errors.WithStack(err)
+	}
+	switch value := distributedTransaction.Value.(type) {
+	case nil:
+	case bool:
+		c.DistributedTransaction = value
+		c.XATransaction = false
+	case string:
+		if strings.ToLower(value) != "xa" {
+			return errors.Errorf("invalid distributed_transaction %s", value)
+		}
+		c.DistributedTransaction = true
+		c.XATransaction = true
+	default:
+		return errors.Errorf("invalid distributed_transaction %v", value)
+	}
+	return nil
+}
+
+// XATransactionError returns error if distributed_transaction is "xa" and any database of tables is not MySQL.
+func (c *Config) XATransactionError() error {
+	if !c.XATransaction {
+		return nil
+	}
+	tableNames := make([]string, 0, len(c.Tables))
+	for tableName := range c.Tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	for _, tableName := range tableNames {
+		table := c.Tables[tableName]
+		if !table.IsShard {
+			if table.Adapter != "mysql" {
+				return errors.Errorf("XA transaction is not supported by '%s' adapter of %s", table.Adapter, tableName)
+			}
+			continue
+		}
+		for _, shard := range table.Shards {
+			for shardName, shardConfig := range shard {
+				if shardConfig.Adapter != "mysql" {
+					return errors.Errorf("XA transaction is not supported by '%s' adapter of %s for %s", shardConfig.Adapter, shardName, tableName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) resolveLookup(table *TableConfig) error {
+	lookup := table.Lookup
+	if lookup == nil {
+		return errors.New("cannot find lookup definition")
+	}
+	if lookup.KeyColumnName == "" || lookup.ShardColumnName == "" {
+		return errors.New("key_column and shard_column are required for lookup")
+	}
+	if lookup.Fallback == "lookup" {
+		return errors.New("cannot use lookup as fallback algorithm")
+	}
+	if lookup.CacheTTL < 0 || lookup.CacheSize < 0 {
+		return errors.New("cache_ttl and cache_size must not be negative")
+	}
+	lookupTable, exists := c.Tables[lookup.TableName]
+	if !exists {
+		return errors.Errorf("cannot find lookup table %s in config file", lookup.TableName)
+	}
+	if lookupTable.IsShard {
+		return errors.Errorf("lookup table %s must not be sharded", lookup.TableName)
+	}
+	lookup.Database = &lookupTable.DatabaseConfig
+	return nil
 }
 
 // ShardColumnName column name of unique id for all shards
@@ -123,6 +686,32 @@ func (c *Config) ShardKeyColumnName(tableName string) string {
 	return cfg.ShardKeyColumnName
 }
 
+// ShardKeyColumnNames column names for deciding sharding target.
+// If shard_key is not specified, it returns shard_column.
+func (c *Config) ShardKeyColumnNames(tableName string) []string {
+	cfg, exists := c.Tables[tableName]
+	if !exists {
+		return nil
+	}
+	if len(cfg.ShardKeyColumnNames) == 0 {
+		if cfg.ShardColumnName == "" {
+			return nil
+		}
+		return []string{cfg.ShardColumnName}
+	}
+	return cfg.ShardKeyColumnNames
+}
+
+// MaxParallelismByTableName max number of shards accessed concurrently for table.
+// If returns zero, all shards are accessed concurrently.
+func (c *Config) MaxParallelismByTableName(tableName string) int {
+	cfg, exists := c.Tables[tableName]
+	if !exists || cfg.MaxParallelism <= 0 {
+		return c.MaxParallelism
+	}
+	return cfg.MaxParallelism
+}
+
 // IsShardTable returns whether 'is_shard' parameter is defined or not in table configuration.
 func (c *Config) IsShardTable(tableName string) bool {
 	cfg, exists := c.Tables[tableName]
@@ -156,6 +745,45 @@ func Load(configPath string) (*Config, error) {
 	if err := yaml.Unmarshal(content, &config); err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if err := config.XATransactionError(); err != nil {
+		return nil, errors.Wrap(err, "invalid distributed_transaction definition")
+	}
+	if config.CoordinatorLog != nil {
+		if err := config.CoordinatorLog.Error(); err != nil {
+			return nil, errors.Wrap(err, "invalid coordinator_log definition")
+		}
+	}
+	if config.Outbox != nil {
+		if err := config.Outbox.Error(); err != nil {
+			return nil, errors.Wrap(err, "invalid outbox definition")
+		}
+	}
+	for tableName, table := range config.Tables {
+		if !table.IsShard {
+			continue
+		}
+		if err := table.WeightError(); err != nil {
+			return nil, errors.Wrapf(err, "invalid weight definition for %s", tableName)
+		}
+		switch table.Algorithm {
+		case "range":
+			if err := table.RangeError(); err != nil {
+				return nil, errors.Wrapf(err, "invalid range definition for %s", tableName)
+			}
+		case "hashmap":
+			if err := table.HashSlotError(); err != nil {
+				return nil, errors.Wrapf(err, "invalid hash slots definition for %s", tableName)
+			}
+		case "time_bucket":
+			if err := table.TimeBucketError(); err != nil {
+				return nil, errors.Wrapf(err, "invalid time_bucket definition for %s", tableName)
+			}
+		case "lookup":
+			if err := config.resolveLookup(table); err != nil {
+				return nil, errors.Wrapf(err, "invalid lookup definition for %s", tableName)
+			}
+		}
+	}
 	globalConfig = config
 	return config, nil
 }
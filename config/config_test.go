@@ -3,8 +3,10 @@ package config
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/aokabi/octillery/path"
+	"gopkg.in/yaml.v2"
 )
 
 func TestError(t *testing.T) {
@@ -49,6 +51,14 @@ func TestError(t *testing.T) {
 	if err := cfg.Tables["not_shard_key"].Error(); err == nil {
 		t.Fatal("cannot handle error")
 	}
+	// load invalid range definition
+	if _, err := Load(filepath.Join(path.ThisDirPath(), "invalid_range_config.yml")); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	// load invalid hash slots definition
+	if _, err := Load(filepath.Join(path.ThisDirPath(), "invalid_hash_slot_config.yml")); err == nil {
+		t.Fatal("cannot handle error")
+	}
 }
 
 // nolint: gocyclo
@@ -102,6 +112,42 @@ func TestConfig(t *testing.T) {
 			t.Fatal("cannot get shard column name from config")
 		}
 	})
+	t.Run("composite shard key", func(t *testing.T) {
+		cfg, _ := Get()
+		if cfg.Tables["user_items"].IsCompositeShardKey() {
+			t.Fatal("invalid composite shard key")
+		}
+		if !cfg.Tables["user_region_items"].IsCompositeShardKey() {
+			t.Fatal("cannot parse composite shard key")
+		}
+		names := cfg.ShardKeyColumnNames("user_region_items")
+		if len(names) != 2 || names[0] != "region_id" || names[1] != "user_id" {
+			t.Fatal("cannot get shard key column names from config")
+		}
+		if cfg.ShardKeyColumnName("user_region_items") != "region_id,user_id" {
+			t.Fatal("cannot get shard key column name from config")
+		}
+		if names := cfg.ShardKeyColumnNames("users"); len(names) != 1 || names[0] != "id" {
+			t.Fatal("cannot get shard key column names from config")
+		}
+	})
+	t.Run("max parallelism", func(t *testing.T) {
+		cfg, _ := Get()
+		if cfg.MaxParallelismByTableName("user_items") != 4 {
+			t.Fatal("cannot get max parallelism from config")
+		}
+		if cfg.MaxParallelismByTableName("users") != 0 {
+			t.Fatal("cannot get max parallelism from config")
+		}
+		cfg.MaxParallelism = 2
+		defer func() { cfg.MaxParallelism = 0 }()
+		if cfg.MaxParallelismByTableName("users") != 2 {
+			t.Fatal("cannot get max parallelism from config")
+		}
+		if cfg.MaxParallelismByTableName("user_items") != 4 {
+			t.Fatal("cannot get max parallelism from config")
+		}
+	})
 	t.Run("is shard table", func(t *testing.T) {
 		cfg, _ := Get()
 		if !cfg.IsShardTable("users") {
@@ -134,6 +180,236 @@ func TestConfig(t *testing.T) {
 			t.Fatal("not work")
 		}
 	})
+	t.Run("shard ranges", func(t *testing.T) {
+		cfg, _ := Get()
+		ranges := cfg.Tables["user_logs"].ShardRanges()
+		if len(ranges) != 2 {
+			t.Fatal("cannot get shard ranges from config")
+		}
+		if !ranges[0].Contains(1) || !ranges[0].Contains(999) || ranges[0].Contains(1000) {
+			t.Fatal("invalid range")
+		}
+		if !ranges[1].Contains(1000) || ranges[1].Contains(999) {
+			t.Fatal("invalid range")
+		}
+	})
+	t.Run("range error", func(t *testing.T) {
+		to := int64(10)
+		newTableConfig := func(ranges ...*RangeConfig) *TableConfig {
+			shards := []map[string]*DatabaseConfig{}
+			for _, r := range ranges {
+				shards = append(shards, map[string]*DatabaseConfig{"shard": {Range: r}})
+			}
+			return &TableConfig{IsShard: true, Algorithm: "range", Shards: shards}
+		}
+		if err := newTableConfig(&RangeConfig{From: 10}, &RangeConfig{From: 0, To: &to}).RangeError(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := newTableConfig(&RangeConfig{From: 0, To: &to}, &RangeConfig{From: 5}).RangeError(); err == nil {
+			t.Fatal("cannot handle overlap")
+		}
+		if err := newTableConfig(&RangeConfig{From: 0}, &RangeConfig{From: 5}).RangeError(); err == nil {
+			t.Fatal("cannot handle overlap")
+		}
+		if err := newTableConfig(&RangeConfig{From: 10, To: &to}).RangeError(); err == nil {
+			t.Fatal("cannot handle empty range")
+		}
+		if err := newTableConfig(&RangeConfig{From: 0, To: &to}, nil).RangeError(); err == nil {
+			t.Fatal("cannot handle undefined range")
+		}
+	})
+	t.Run("shard weights", func(t *testing.T) {
+		table := &TableConfig{IsShard: true, Shards: []map[string]*DatabaseConfig{
+			{"shard_1": {}},
+			{"shard_2": {Weight: 2}},
+		}}
+		weights := table.ShardWeights()
+		if len(weights) != 2 || weights[0] != 1 || weights[1] != 2 {
+			t.Fatalf("invalid weights %v", weights)
+		}
+		if err := table.WeightError(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		table.Shards[0]["shard_1"].Weight = -1
+		if err := table.WeightError(); err == nil {
+			t.Fatal("cannot handle negative weight")
+		}
+	})
+	t.Run("hash slots", func(t *testing.T) {
+		newTableConfig := func(slots ...[]string) *TableConfig {
+			shards := []map[string]*DatabaseConfig{}
+			for _, s := range slots {
+				shards = append(shards, map[string]*DatabaseConfig{"shard": {Slots: s}})
+			}
+			return &TableConfig{IsShard: true, Algorithm: "hashmap", Shards: shards}
+		}
+		table := newTableConfig([]string{"0-99", "600-1022"}, []string{"100-599"})
+		if err := table.HashSlotError(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		slots, err := table.ShardHashSlots()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if len(slots) != 2 || len(slots[0]) != 2 || slots[0][1].Start != 600 || slots[0][1].End != 1022 {
+			t.Fatal("cannot get hash slots from config")
+		}
+		if err := newTableConfig([]string{"0-599"}, []string{"500-1022"}).HashSlotError(); err == nil {
+			t.Fatal("cannot handle overlap")
+		}
+		if err := newTableConfig([]string{"0-599"}, []string{"600-1000"}).HashSlotError(); err == nil {
+			t.Fatal("cannot handle unassigned slots")
+		}
+		if err := newTableConfig([]string{"0-1022"}, nil).HashSlotError(); err == nil {
+			t.Fatal("cannot handle undefined slots")
+		}
+		if err := newTableConfig([]string{"0-1023"}).HashSlotError(); err == nil {
+			t.Fatal("cannot handle out of range slot")
+		}
+		if slots, err := newTableConfig(nil, nil).ShardHashSlots(); err != nil || slots != nil {
+			t.Fatal("hash slots must not be declared")
+		}
+	})
+	t.Run("time bucket", func(t *testing.T) {
+		cfg, _ := Get()
+		table := cfg.Tables["user_events"]
+		if err := table.TimeBucketError(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		start, err := table.TimeBucket.StartTime()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if start.Year() != 2024 || start.Month() != 1 || start.Day() != 1 || start.Location() != time.UTC {
+			t.Fatalf("invalid start %s", start)
+		}
+		if err := (&TableConfig{Algorithm: "time_bucket", TimeBucket: &TimeBucketConfig{Unit: "year"}}).TimeBucketError(); err == nil {
+			t.Fatal("cannot handle invalid unit")
+		}
+	})
+	t.Run("distributed transaction", func(t *testing.T) {
+		cfg, _ := Get()
+		if !cfg.DistributedTransaction || cfg.XATransaction {
+			t.Fatal("invalid default of distributed_transaction")
+		}
+		for text, expected := range map[string][2]bool{
+			"distributed_transaction: false": {false, false},
+			"distributed_transaction: true":  {true, false},
+			"distributed_transaction: xa":    {true, true},
+		} {
+			cfg := &Config{DistributedTransaction: true}
+			if err := yaml.Unmarshal([]byte(text), cfg); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if cfg.DistributedTransaction != expected[0] || cfg.XATransaction != expected[1] {
+				t.Fatalf("cannot decode %s", text)
+			}
+		}
+		if err := yaml.Unmarshal([]byte("distributed_transaction: 2pc"), &Config{}); err == nil {
+			t.Fatal("cannot handle invalid distributed_transaction")
+		}
+		mysqlShard := map[string]*DatabaseConfig{"user_shard_1": {Adapter: "mysql"}}
+		sqliteShard := map[string]*DatabaseConfig{"user_shard_2": {Adapter: "sqlite3"}}
+		xaConfig := &Config{XATransaction: true, Tables: map[string]*TableConfig{
+			"users": {IsShard: true, Shards: []map[string]*DatabaseConfig{mysqlShard}},
+			"items": {DatabaseConfig: DatabaseConfig{Adapter: "mysql"}},
+		}}
+		if err := xaConfig.XATransactionError(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		xaConfig.Tables["users"].Shards = append(xaConfig.Tables["users"].Shards, sqliteShard)
+		if err := xaConfig.XATransactionError(); err == nil {
+			t.Fatal("cannot reject XA transaction for sqlite3 shard")
+		}
+		xaConfig.Tables["users"].Shards = []map[string]*DatabaseConfig{mysqlShard}
+		xaConfig.Tables["items"].Adapter = "sqlite3"
+		if err := xaConfig.XATransactionError(); err == nil {
+			t.Fatal("cannot reject XA transaction for sqlite3 table")
+		}
+		xaConfig.XATransaction = false
+		if err := xaConfig.XATransactionError(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	})
+	t.Run("coordinator log", func(t *testing.T) {
+		if err := (&CoordinatorLogConfig{Store: "file", Path: "/tmp/octillery_coordinator_logs"}).Error(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		sqlConfig := &CoordinatorLogConfig{Store: "sql", DatabaseConfig: DatabaseConfig{Adapter: "sqlite3", NameOrPath: "/tmp/coordinator_log.bin"}}
+		if err := sqlConfig.Error(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if sqlConfig.TableName() != CoordinatorLogTableName {
+			t.Fatal("cannot get default table name")
+		}
+		if sqlConfig.GracePeriodDuration() != DefaultCoordinatorLogGracePeriod {
+			t.Fatal("cannot get default grace period")
+		}
+		if (&CoordinatorLogConfig{GracePeriod: 10}).GracePeriodDuration() != 10*time.Second {
+			t.Fatal("cannot get grace period")
+		}
+		for _, invalidConfig := range []*CoordinatorLogConfig{
+			{Store: "file"},
+			{Store: "sql", Table: "logs"},
+			{Store: "memory"},
+			{Store: "file", Path: "/tmp/octillery_coordinator_logs", GracePeriod: -1},
+		} {
+			if err := invalidConfig.Error(); err == nil {
+				t.Fatalf("cannot handle invalid config %v", invalidConfig)
+			}
+		}
+	})
+	t.Run("outbox", func(t *testing.T) {
+		if err := (&OutboxConfig{Sink: "file", Path: "/tmp/octillery_outbox.log"}).Error(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		sqlConfig := &OutboxConfig{Sink: "sql", DatabaseConfig: DatabaseConfig{Adapter: "sqlite3", NameOrPath: "/tmp/outbox.bin"}}
+		if err := sqlConfig.Error(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if sqlConfig.TableName() != OutboxTableName {
+			t.Fatal("cannot get default table name")
+		}
+		for _, invalidConfig := range []*OutboxConfig{
+			{Sink: "file"},
+			{Sink: "sql", Table: "events"},
+			{Sink: "kafka"},
+		} {
+			if err := invalidConfig.Error(); err == nil {
+				t.Fatalf("cannot handle invalid config %v", invalidConfig)
+			}
+		}
+	})
+	t.Run("lookup", func(t *testing.T) {
+		cfg, _ := Get()
+		lookup := cfg.Tables["user_profiles"].Lookup
+		if lookup == nil || lookup.Database == nil {
+			t.Fatal("cannot resolve lookup table")
+		}
+		if lookup.Database.NameOrPath != "/tmp/user_shard_lookup.bin" {
+			t.Fatal("invalid lookup database")
+		}
+		table := &TableConfig{IsShard: true, Algorithm: "lookup", Lookup: &LookupConfig{
+			TableName:       "users",
+			KeyColumnName:   "user_id",
+			ShardColumnName: "shard_name",
+		}}
+		if err := cfg.resolveLookup(table); err == nil {
+			t.Fatal("cannot handle sharded lookup table")
+		}
+		table.Lookup.TableName = "unknown"
+		if err := cfg.resolveLookup(table); err == nil {
+			t.Fatal("cannot handle unknown lookup table")
+		}
+		if lookup.MaxCacheSize() != DefaultLookupCacheSize {
+			t.Fatal("cannot get default cache size")
+		}
+		if err := cfg.resolveLookup(&TableConfig{Lookup: &LookupConfig{
+			TableName: "user_shard_lookups", KeyColumnName: "user_id", ShardColumnName: "shard_name", CacheSize: -1,
+		}}); err == nil {
+			t.Fatal("cannot handle negative cache_size")
+		}
+	})
 	t.Run("is used sequencer", func(t *testing.T) {
 		cfg, _ := Get()
 		if !cfg.Tables["users"].IsUsedSequencer() {
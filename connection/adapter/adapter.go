@@ -34,6 +34,13 @@ type DBAdapter interface {
 	InsertRowToSequencerIfNotExists(conn *sql.DB, tableName string) error
 }
 
+// ErrorClassifier is an optional interface of DBAdapter to classify errors returned by database driver.
+// If DBAdapter implements it, transaction failed by retryable error is retried by sql.RunInTx.
+type ErrorClassifier interface {
+	// returns whether transaction can succeed by retrying ( e.g. deadlock or lock wait timeout )
+	IsRetryableError(err error) bool
+}
+
 var (
 	adaptersMu sync.RWMutex
 	adapters   = make(map[string]DBAdapter)
@@ -60,3 +67,19 @@ func Adapter(name string) (DBAdapter, error) {
 	}
 	return adapter, nil
 }
+
+// IsRetryableError returns whether err is classified as retryable error by any registered adapters.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := errors.Cause(err)
+	adaptersMu.RLock()
+	defer adaptersMu.RUnlock()
+	for _, adapter := range adapters {
+		if classifier, ok := adapter.(ErrorClassifier); ok && classifier.IsRetryableError(cause) {
+			return true
+		}
+	}
+	return false
+}
@@ -35,6 +35,16 @@ func (t *TestAdapter) InsertRowToSequencerIfNotExists(conn *sql.DB, tableName st
 	return nil
 }
 
+type RetryTestAdapter struct {
+	*TestAdapter
+}
+
+var errRetryable = errors.New("retryable error")
+
+func (t *RetryTestAdapter) IsRetryableError(err error) bool {
+	return err == errRetryable
+}
+
 var (
 	adapterInstance DBAdapter
 )
@@ -51,6 +61,7 @@ func init() {
 	adapterInstance = &TestAdapter{}
 	Register("sqlite3", adapterInstance)
 	Register("sqlite3", adapterInstance)
+	Register("retry_test", &RetryTestAdapter{})
 }
 
 func TestAdapterInstance(t *testing.T) {
@@ -72,3 +83,15 @@ func TestAdapterInstance(t *testing.T) {
 		t.Fatalf("invalid adapter instance")
 	}
 }
+
+func TestIsRetryableError(t *testing.T) {
+	if !IsRetryableError(errors.WithStack(errRetryable)) {
+		t.Fatal("cannot classify retryable error")
+	}
+	if IsRetryableError(errors.New("error")) {
+		t.Fatal("invalid classification")
+	}
+	if IsRetryableError(nil) {
+		t.Fatal("invalid classification")
+	}
+}
@@ -139,3 +139,12 @@ func (adapter *MySQLAdapter) InsertRowToSequencerIfNotExists(conn *sql.DB, table
 	}
 	return nil
 }
+
+// IsRetryableError returns true if error is deadlock ( 1213 ) or lock wait timeout ( 1205 ).
+func (adapter *MySQLAdapter) IsRetryableError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+	return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+}
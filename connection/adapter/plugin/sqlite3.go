@@ -79,3 +79,14 @@ func (adapter *SQLiteAdapter) InsertRowToSequencerIfNotExists(conn *sql.DB, tabl
 	_, err := conn.Exec(fmt.Sprintf("insert into %s(id, seq_id) values (0, 1)", tableName))
 	return errors.Wrap(err, "cannot insert new row for sequncer")
 }
+
+// IsRetryableError returns true if error is SQLITE_BUSY.
+func (adapter *SQLiteAdapter) IsRetryableError(err error) bool {
+	switch sqliteErr := err.(type) {
+	case sqlite3.Error:
+		return sqliteErr.Code == sqlite3.ErrBusy
+	case *sqlite3.Error:
+		return sqliteErr.Code == sqlite3.ErrBusy
+	}
+	return false
+}
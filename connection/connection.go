@@ -4,9 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"net/url"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -24,6 +27,14 @@ type QueryLog struct {
 	Query        string        `json:"query"`
 	Args         []interface{} `json:"args"`
 	LastInsertID int64         `json:"lastInsertId"`
+	shardName    string
+}
+
+func shardNameByConnection(conn Connection) string {
+	if shardConn, ok := conn.(*DBShardConnection); ok {
+		return shardConn.ShardName
+	}
+	return ""
 }
 
 // Connection common interface for DBConnection and DBShardConnection
@@ -117,13 +128,43 @@ type DBConnection struct {
 	ShardKeyColumnName string
 	ShardColumnName    string
 	ShardConnections   *DBShardConnections
+	MaxParallelism     int
+}
+
+// shardTx is a transaction for a database. *sql.Tx or XA transaction implements this.
+type shardTx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	Commit() error
+	Rollback() error
+}
+
+var transactionSequence uint64
+
+// newTransactionID returns id of transaction unique in all processes.
+// It is used as global transaction id ( gtrid ) of XA transaction and id of coordinator log.
+func newTransactionID() string {
+	return fmt.Sprintf("octillery.%d.%d.%d", os.Getpid(), time.Now().UnixNano(), atomic.AddUint64(&transactionSequence, 1))
+}
+
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
 }
 
 // TxConnection manage transaction
 type TxConnection struct {
 	dsnList                    []string
-	dsnToTx                    map[string]*sql.Tx
-	txToWriteQueries           map[*sql.Tx][]*QueryLog
+	dsnToTx                    map[string]shardTx
+	txToWriteQueries           map[shardTx][]*QueryLog
+	id                         string
+	isXA                       bool
+	savepoints                 []*savepoint
+	unusableErr                error
 	ctx                        context.Context
 	opts                       *sql.TxOptions
 	WriteQueries               []*QueryLog
@@ -134,6 +175,9 @@ type TxConnection struct {
 }
 
 func (c *TxConnection) beginIfNotInitialized(conn Connection) error {
+	if c.unusableErr != nil {
+		return errors.WithStack(c.unusableErr)
+	}
 	dsn := conn.DSN()
 	tx := c.dsnToTx[dsn]
 	if !globalConfig.DistributedTransaction {
@@ -145,7 +189,10 @@ func (c *TxConnection) beginIfNotInitialized(conn Connection) error {
 	if tx != nil {
 		return nil
 	}
-	newTx, err := func() (*sql.Tx, error) {
+	newTx, err := func() (shardTx, error) {
+		if c.isXA {
+			return beginXA(contextOrBackground(c.ctx), conn.Conn(), c.id, len(c.dsnList)+1)
+		}
 		if c.ctx != nil {
 			return conn.Conn().BeginTx(c.ctx, c.opts)
 		}
@@ -154,6 +201,10 @@ func (c *TxConnection) beginIfNotInitialized(conn Connection) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if err := c.replaySavepoints(c.ctx, newTx); err != nil {
+		newTx.Rollback()
+		return errors.WithStack(err)
+	}
 	c.dsnList = append(c.dsnList, dsn)
 	c.dsnToTx[dsn] = newTx
 	return nil
@@ -165,12 +216,7 @@ func (c *TxConnection) Prepare(ctx context.Context, conn Connection, query strin
 		return nil, errors.WithStack(err)
 	}
 	tx := c.dsnToTx[conn.DSN()]
-	stmt, err := func() (*sql.Stmt, error) {
-		if ctx == nil {
-			return tx.Prepare(query)
-		}
-		return tx.PrepareContext(ctx, query)
-	}()
+	stmt, err := tx.PrepareContext(contextOrBackground(ctx), query)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -186,6 +232,7 @@ func (c *TxConnection) AddWriteQuery(conn Connection, result sql.Result, query s
 		Query:        query,
 		Args:         args,
 		LastInsertID: id,
+		shardName:    shardNameByConnection(conn),
 	}
 	tx := c.dsnToTx[conn.DSN()]
 	c.txToWriteQueries[tx] = append(c.txToWriteQueries[tx], queryLog)
@@ -205,7 +252,10 @@ func (c *TxConnection) Stmt(ctx context.Context, conn Connection, stmt *sql.Stmt
 	if err := c.beginIfNotInitialized(conn); err != nil {
 		return nil, errors.WithStack(err)
 	}
-	tx := c.dsnToTx[conn.DSN()]
+	tx, ok := c.dsnToTx[conn.DSN()].(*sql.Tx)
+	if !ok {
+		return nil, errors.New("cannot use Stmt in XA transaction")
+	}
 	if ctx == nil {
 		return tx.Stmt(stmt), nil
 	}
@@ -218,12 +268,7 @@ func (c *TxConnection) QueryRow(ctx context.Context, conn Connection, query stri
 		return nil, errors.WithStack(err)
 	}
 	tx := c.dsnToTx[conn.DSN()]
-	row := func() *sql.Row {
-		if ctx == nil {
-			return tx.QueryRow(query, args...)
-		}
-		return tx.QueryRowContext(ctx, query, args...)
-	}()
+	row := tx.QueryRowContext(contextOrBackground(ctx), query, args...)
 	c.ReadQueries = append(c.ReadQueries, &QueryLog{
 		Query: query,
 		Args:  args,
@@ -237,12 +282,7 @@ func (c *TxConnection) Query(ctx context.Context, conn Connection, query string,
 		return nil, errors.WithStack(err)
 	}
 	tx := c.dsnToTx[conn.DSN()]
-	rows, err := func() (*sql.Rows, error) {
-		if ctx == nil {
-			return tx.Query(query, args...)
-		}
-		return tx.QueryContext(ctx, query, args...)
-	}()
+	rows, err := tx.QueryContext(contextOrBackground(ctx), query, args...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -259,12 +299,7 @@ func (c *TxConnection) Exec(ctx context.Context, conn Connection, query string,
 		return nil, errors.WithStack(err)
 	}
 	tx := c.dsnToTx[conn.DSN()]
-	result, err := func() (sql.Result, error) {
-		if ctx == nil {
-			return tx.Exec(query, args...)
-		}
-		return tx.ExecContext(ctx, query, args...)
-	}()
+	result, err := tx.ExecContext(contextOrBackground(ctx), query, args...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -276,6 +311,7 @@ func (c *TxConnection) Exec(ctx context.Context, conn Connection, query string,
 		Query:        query,
 		Args:         args,
 		LastInsertID: id,
+		shardName:    shardNameByConnection(conn),
 	}
 	c.txToWriteQueries[tx] = append(c.txToWriteQueries[tx], queryLog)
 	c.WriteQueries = append(c.WriteQueries, queryLog)
@@ -290,6 +326,9 @@ func (c *TxConnection) Commit() (e error) {
 	if len(c.dsnToTx) == 0 {
 		return nil
 	}
+	if c.unusableErr != nil {
+		return errors.WithStack(c.unusableErr)
+	}
 	if err := c.BeforeCommitCallback(); err != nil {
 		return errors.WithStack(err)
 	}
@@ -309,28 +348,94 @@ func (c *TxConnection) Commit() (e error) {
 		}
 	}()
 
+	// coordinator log is written before XA PREPARE, so prepared XA transactions are always recorded with xid
+	txLog, err := c.writeCoordinatorLog()
+	if err != nil {
+		failedWriteQueries = append(failedWriteQueries, c.WriteQueries...)
+		if rollbackErr := c.Rollback(); rollbackErr != nil {
+			return errors.Wrap(err, rollbackErr.Error())
+		}
+		return errors.WithStack(err)
+	}
+	isPrepared, err := c.prepareXA()
+	if err != nil {
+		failedWriteQueries = append(failedWriteQueries, c.WriteQueries...)
+		return c.rollbackPreparedXA(txLog, err)
+	}
+	if isPrepared {
+		// recording commit decision is the commit point of two-phase commit.
+		// if it fails, nothing is committed yet, so rollback all prepared XA transactions
+		if err := c.markCommitDecidedToCoordinatorLog(txLog); err != nil {
+			failedWriteQueries = append(failedWriteQueries, c.WriteQueries...)
+			return c.rollbackPreparedXA(txLog, err)
+		}
+	}
+
 	errs := []string{}
 	for _, dsn := range c.dsnList {
 		tx := c.dsnToTx[dsn]
 		if err := tx.Commit(); err != nil {
 			failedWriteQueries = append(failedWriteQueries, c.txToWriteQueries[tx]...)
-			if committedWriteQueryNum > 0 {
-				// distributed transaction error
+			if committedWriteQueryNum > 0 || isPrepared {
+				// distributed transaction error.
+				// after all XA transactions are prepared, commit is decided and they remain on the server until committed by recovery.
 				isCriticalError = true
-				errs = append(errs, errors.Wrapf(err, "cannot commit to %s", dsn).Error())
+				errs = append(errs, commitError(err, tx, dsn).Error())
 			} else {
+				// no write queries are committed, so transaction is not in-doubt
+				c.deleteCoordinatorLog(txLog)
 				return errors.Wrapf(err, "cannot commit to %s", dsn)
 			}
 		} else {
 			committedWriteQueryNum += len(c.txToWriteQueries[tx])
+			c.markCommittedToCoordinatorLog(txLog, dsn)
 		}
 	}
 	if len(errs) > 0 {
+		// coordinator log remains for recovery
 		return errors.New(strings.Join(errs, ":"))
 	}
+	c.deleteCoordinatorLog(txLog)
+	c.publishChangeEvents()
 	return nil
 }
 
+// prepareXA executes first phase of two-phase commit for all databases, and returns true if all of them are prepared.
+// XA transaction for single database is committed by one-phase commit, so this does nothing.
+func (c *TxConnection) prepareXA() (bool, error) {
+	if !c.isXA || len(c.dsnList) < 2 {
+		return false, nil
+	}
+	for _, dsn := range c.dsnList {
+		tx, ok := c.dsnToTx[dsn].(*xaTx)
+		if !ok {
+			return false, errors.Errorf("cannot find XA transaction for %s", dsn)
+		}
+		if err := tx.prepare(); err != nil {
+			return false, errors.Wrapf(err, "cannot prepare to %s", dsn)
+		}
+	}
+	return true, nil
+}
+
+// rollbackPreparedXA rollbacks all XA transactions before commit is decided, and returns err.
+func (c *TxConnection) rollbackPreparedXA(txLog *TransactionLog, err error) error {
+	if rollbackErr := c.Rollback(); rollbackErr != nil {
+		// prepared XA transactions may remain, so coordinator log remains for recovery
+		return errors.Wrap(err, rollbackErr.Error())
+	}
+	c.deleteCoordinatorLog(txLog)
+	return errors.WithStack(err)
+}
+
+// commitError returns error of commit with xid if tx is XA transaction, because prepared XA transaction must be committed by xid.
+func commitError(err error, tx shardTx, dsn string) error {
+	if xa, ok := tx.(*xaTx); ok {
+		return errors.Wrapf(err, "cannot commit XA transaction %s to %s", xa.xid, dsn)
+	}
+	return errors.Wrapf(err, "cannot commit to %s", dsn)
+}
+
 // Rollback executes `Rollback` with transaction.
 func (c *TxConnection) Rollback() error {
 	if c == nil {
@@ -367,10 +472,19 @@ func (c *DBConnection) Conn() *sql.DB {
 
 // Begin creates TxConnection instance for transaction.
 func (c *DBConnection) Begin(ctx context.Context, opts *sql.TxOptions) *TxConnection {
+	return NewTxConnection(ctx, opts)
+}
+
+// NewTxConnection creates TxConnection instance for transaction.
+// Transaction for each database is started when it is accessed first.
+// If XA transaction is enabled by configuration, transaction for each database is started by `XA START` and opts is ignored.
+func NewTxConnection(ctx context.Context, opts *sql.TxOptions) *TxConnection {
 	return &TxConnection{
+		id:                         newTransactionID(),
+		isXA:                       globalConfig.XATransaction,
 		dsnList:                    []string{},
-		dsnToTx:                    map[string]*sql.Tx{},
-		txToWriteQueries:           map[*sql.Tx][]*QueryLog{},
+		dsnToTx:                    map[string]shardTx{},
+		txToWriteQueries:           map[shardTx][]*QueryLog{},
 		ctx:                        ctx,
 		opts:                       opts,
 		BeforeCommitCallback:       func() error { return nil },
@@ -397,19 +511,44 @@ func (c *DBConnection) IsEqualShardColumnToShardKeyColumn() bool {
 
 // ShardConnectionByID returns connection to shard by unique id.
 func (c *DBConnection) ShardConnectionByID(id int64) (*DBShardConnection, error) {
+	return c.ShardConnectionByKey(algorithm.NewIntKey(id))
+}
+
+// ShardConnectionByKey returns connection to shard by typed shard_key ( integer, string or []byte ).
+func (c *DBConnection) ShardConnectionByKey(key algorithm.ShardKey) (*DBShardConnection, error) {
 	conns := []*sql.DB{}
 	connMap := map[*sql.DB]*DBShardConnection{}
 	for _, shardConn := range c.ShardConnections.AllShard() {
 		connMap[shardConn.Connection] = shardConn
 		conns = append(conns, shardConn.Connection)
 	}
-	dbConn, err := c.Algorithm.Shard(conns, id)
+	dbConn, err := algorithm.ShardByKey(c.Algorithm, conns, key)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return connMap[dbConn], nil
 }
 
+// ShardConnectionsByRange returns connections to shards that may have shard_key included in keyRange.
+// If sharding algorithm doesn't support range of shard_key, all shards are returned.
+func (c *DBConnection) ShardConnectionsByRange(keyRange *algorithm.ShardKeyRange) ([]*DBShardConnection, error) {
+	conns := []*sql.DB{}
+	connMap := map[*sql.DB]*DBShardConnection{}
+	for _, shardConn := range c.ShardConnections.AllShard() {
+		connMap[shardConn.Connection] = shardConn
+		conns = append(conns, shardConn.Connection)
+	}
+	dbConns, err := algorithm.ShardsByRange(c.Algorithm, conns, keyRange)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	shardConns := make([]*DBShardConnection, 0, len(dbConns))
+	for _, dbConn := range dbConns {
+		shardConns = append(shardConns, connMap[dbConn])
+	}
+	return shardConns, nil
+}
+
 // EqualDSN returns whether connection is same DSN connection that executed SQL previously or not.
 func (c *DBConnection) EqualDSN(conn *DBConnection) bool {
 	if c == conn {
@@ -591,6 +730,11 @@ func (cm *DBConnectionManager) Close() error {
 			if err := conn.ShardConnections.Close(); err != nil {
 				errs = append(errs, err.Error())
 			}
+			if closer, ok := conn.Algorithm.(io.Closer); ok {
+				if err := closer.Close(); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
 		} else {
 			if err := closeConn(conn.Connection); err != nil {
 				errs = append(errs, err.Error())
@@ -655,6 +799,28 @@ func (cm *DBConnectionManager) NextSequenceID(tableName string) (int64, error) {
 	return conn.Adapter.NextSequenceID(conn.Sequencer, sequencerTableName(tableName))
 }
 
+// ConnectionByDSN returns *sql.DB for database or shard that has dsn.
+func (cm *DBConnectionManager) ConnectionByDSN(dsn string) (*sql.DB, error) {
+	for tableName := range globalConfig.Tables {
+		conn, err := cm.ConnectionByTableName(tableName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !conn.IsShard {
+			if conn.DSN() == dsn {
+				return conn.Connection, nil
+			}
+			continue
+		}
+		for _, shardConn := range conn.ShardConnections.AllShard() {
+			if shardConn.DSN() == dsn {
+				return shardConn.Connection, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("cannot find database connection for %s", dsn)
+}
+
 // IsShardTable whether sharding table or not.
 func (cm *DBConnectionManager) IsShardTable(tableName string) bool {
 	conn, err := cm.ConnectionByTableName(tableName)
@@ -746,7 +912,7 @@ func (cm *DBConnectionManager) openShardConnection(tableName string, table *conf
 			})
 		}
 	}
-	logic, err := algorithm.LoadShardingAlgorithm(table.Algorithm)
+	logic, err := algorithm.LoadShardingAlgorithmByConfig(table)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -763,6 +929,7 @@ func (cm *DBConnectionManager) openShardConnection(tableName string, table *conf
 		ShardColumnName:    table.ShardColumnName,
 		ShardKeyColumnName: table.ShardKeyColumnName,
 		ShardConnections:   shardConns,
+		MaxParallelism:     globalConfig.MaxParallelismByTableName(tableName),
 	})
 	return nil
 }
@@ -801,7 +968,13 @@ func NewConnectionManager() (*DBConnectionManager, error) {
 // SetConfig set config.Config instance to internal global variable
 func SetConfig(cfg *config.Config) error {
 	globalConfig = cfg
-	return errors.WithStack(setupDBFromConfig(cfg))
+	if err := setupDBFromConfig(cfg); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := setupCoordinatorLog(cfg); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(setupOutbox(cfg))
 }
 
 func setupDBFromConfig(config *config.Config) error {
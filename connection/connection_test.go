@@ -4,10 +4,20 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/algorithm"
 	"github.com/aokabi/octillery/config"
 	"github.com/aokabi/octillery/connection/adapter"
 	"github.com/aokabi/octillery/path"
@@ -307,6 +317,61 @@ func TestShardConnectionByID(t *testing.T) {
 	}
 }
 
+func TestShardConnectionByIDWithRange(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("user_logs")
+	checkErr(t, err)
+	for _, tc := range []struct {
+		id        int64
+		shardName string
+	}{
+		{1, "user_log_shard_1"},
+		{999, "user_log_shard_1"},
+		{1000, "user_log_shard_2"},
+		{100000, "user_log_shard_2"},
+	} {
+		shardConn, err := conn.ShardConnectionByID(tc.id)
+		checkErr(t, err)
+		if shardConn.ShardName != tc.shardName {
+			t.Fatalf("invalid shard connection by id %d", tc.id)
+		}
+	}
+	if _, err := conn.ShardConnectionByID(0); err == nil {
+		t.Fatal("cannot handle error")
+	}
+}
+
+func TestShardConnectionsByRange(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("user_logs")
+	checkErr(t, err)
+	from := int64(500)
+	to := int64(1500)
+	for _, tc := range []struct {
+		keyRange   *algorithm.ShardKeyRange
+		shardNames []string
+	}{
+		{&algorithm.ShardKeyRange{From: &from, To: &to}, []string{"user_log_shard_1", "user_log_shard_2"}},
+		{&algorithm.ShardKeyRange{To: &from}, []string{"user_log_shard_1"}},
+		{&algorithm.ShardKeyRange{From: &to}, []string{"user_log_shard_2"}},
+	} {
+		shardConns, err := conn.ShardConnectionsByRange(tc.keyRange)
+		checkErr(t, err)
+		if len(shardConns) != len(tc.shardNames) {
+			t.Fatalf("invalid shard connections by range %s", tc.keyRange)
+		}
+		for idx, shardConn := range shardConns {
+			if shardConn.ShardName != tc.shardNames[idx] {
+				t.Fatalf("invalid shard connections by range %s", tc.keyRange)
+			}
+		}
+	}
+}
+
 func TestShardColumnName(t *testing.T) {
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
@@ -484,3 +549,810 @@ func TestTransaction(t *testing.T) {
 		checkErr(t, tx.Rollback())
 	})
 }
+
+type XARecordDriver struct {
+	mu          sync.Mutex
+	queries     map[string][]string
+	failQuery   map[string]string
+	preparedXID map[string][]string
+}
+
+func (d *XARecordDriver) Open(name string) (driver.Conn, error) {
+	return &XARecordConn{driver: d, name: name}, nil
+}
+
+func (d *XARecordDriver) reset(failQuery map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = map[string][]string{}
+	d.failQuery = failQuery
+	d.preparedXID = map[string][]string{}
+}
+
+func (d *XARecordDriver) executedQueries(name string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queries[name]
+}
+
+type XARecordConn struct {
+	*TestConn
+	driver *XARecordDriver
+	name   string
+}
+
+func (c *XARecordConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	if failQuery := c.driver.failQuery[c.name]; failQuery != "" && strings.HasPrefix(query, failQuery) {
+		return nil, errors.New("failed to exec " + query)
+	}
+	c.driver.queries[c.name] = append(c.driver.queries[c.name], query)
+	return &TestResult{}, nil
+}
+
+func (c *XARecordConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	c.driver.queries[c.name] = append(c.driver.queries[c.name], query)
+	if query != "XA RECOVER" {
+		return &TestRows{}, nil
+	}
+	return &XARecoverRows{xids: c.driver.preparedXID[c.name]}, nil
+}
+
+type XARecoverRows struct {
+	xids []string
+}
+
+func (r *XARecoverRows) Columns() []string {
+	return []string{"formatID", "gtrid_length", "bqual_length", "data"}
+}
+
+func (r *XARecoverRows) Close() error {
+	return nil
+}
+
+func (r *XARecoverRows) Next(dest []driver.Value) error {
+	if len(r.xids) == 0 {
+		return io.EOF
+	}
+	dest[0] = int64(1)
+	dest[1] = int64(0)
+	dest[2] = int64(0)
+	dest[3] = []byte(r.xids[0])
+	r.xids = r.xids[1:]
+	return nil
+}
+
+func (c *XARecordConn) Begin() (driver.Tx, error) {
+	return &XARecordTx{conn: c}, nil
+}
+
+type XARecordTx struct {
+	conn *XARecordConn
+}
+
+func (t *XARecordTx) Commit() error {
+	_, err := t.conn.Exec("COMMIT", nil)
+	return err
+}
+
+func (t *XARecordTx) Rollback() error {
+	_, err := t.conn.Exec("ROLLBACK", nil)
+	return err
+}
+
+type XARecordConnection struct {
+	dsn string
+	db  *sql.DB
+}
+
+func (c *XARecordConnection) DSN() string {
+	return c.dsn
+}
+
+func (c *XARecordConnection) Conn() *sql.DB {
+	return c.db
+}
+
+func TestXATransaction(t *testing.T) {
+	recorder := &XARecordDriver{}
+	sql.Register("xa_record", recorder)
+	conns := []*XARecordConnection{}
+	for _, name := range []string{"shard1", "shard2"} {
+		db, err := sql.Open("xa_record", name)
+		checkErr(t, err)
+		defer db.Close()
+		conns = append(conns, &XARecordConnection{dsn: name, db: db})
+	}
+	globalConfig.XATransaction = true
+	defer func() { globalConfig.XATransaction = false }()
+
+	isPrefixOf := func(queries []string, expected []string) bool {
+		if len(queries) != len(expected) {
+			return false
+		}
+		for idx, query := range queries {
+			if !strings.HasPrefix(query, expected[idx]) {
+				return false
+			}
+		}
+		return true
+	}
+	t.Run("two-phase commit", func(t *testing.T) {
+		recorder.reset(nil)
+		tx := (&DBConnection{}).Begin(nil, nil)
+		for _, conn := range conns {
+			if _, err := tx.Exec(nil, conn, "delete from user_stages where id = 1"); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+		}
+		checkErr(t, tx.Commit())
+		for _, conn := range conns {
+			queries := recorder.executedQueries(conn.dsn)
+			if !isPrefixOf(queries, []string{"XA START", "delete", "XA END", "XA PREPARE", "XA COMMIT"}) {
+				t.Fatalf("invalid queries %v", queries)
+			}
+			if strings.HasSuffix(queries[4], "ONE PHASE") {
+				t.Fatalf("invalid queries %v", queries)
+			}
+		}
+		if recorder.executedQueries("shard1")[0] == recorder.executedQueries("shard2")[0] {
+			t.Fatal("xid must be unique for each branch")
+		}
+	})
+	t.Run("one-phase commit", func(t *testing.T) {
+		recorder.reset(nil)
+		tx := (&DBConnection{}).Begin(nil, nil)
+		if _, err := tx.Exec(nil, conns[0], "delete from user_stages where id = 1"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		checkErr(t, tx.Commit())
+		queries := recorder.executedQueries("shard1")
+		if !isPrefixOf(queries, []string{"XA START", "delete", "XA END", "XA COMMIT"}) || !strings.HasSuffix(queries[3], "ONE PHASE") {
+			t.Fatalf("invalid queries %v", queries)
+		}
+	})
+	t.Run("rollback all by prepare error", func(t *testing.T) {
+		recorder.reset(map[string]string{"shard2": "XA PREPARE"})
+		tx := (&DBConnection{}).Begin(nil, nil)
+		for _, conn := range conns {
+			if _, err := tx.Exec(nil, conn, "delete from user_stages where id = 1"); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+		}
+		tx.AfterCommitSuccessCallback = func() error {
+			t.Fatal("cannot handle error")
+			return nil
+		}
+		tx.AfterCommitFailureCallback = func(isCriticalError bool, failureQueries []*QueryLog) error {
+			if isCriticalError {
+				t.Fatal("prepare error must not be critical")
+			}
+			if len(failureQueries) != 2 {
+				t.Fatal("cannot capture failure queries")
+			}
+			return nil
+		}
+		if err := tx.Commit(); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if queries := recorder.executedQueries("shard1"); !isPrefixOf(queries, []string{"XA START", "delete", "XA END", "XA PREPARE", "XA ROLLBACK"}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+		if queries := recorder.executedQueries("shard2"); !isPrefixOf(queries, []string{"XA START", "delete", "XA END", "XA ROLLBACK"}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+	})
+	t.Run("commit error after prepare is critical", func(t *testing.T) {
+		recorder.reset(map[string]string{"shard1": "XA COMMIT"})
+		tx := (&DBConnection{}).Begin(nil, nil)
+		for _, conn := range conns {
+			if _, err := tx.Exec(nil, conn, "delete from user_stages where id = 1"); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+		}
+		isCalledFailureCallback := false
+		tx.AfterCommitFailureCallback = func(isCriticalError bool, failureQueries []*QueryLog) error {
+			isCalledFailureCallback = true
+			if !isCriticalError {
+				t.Fatal("commit error after prepare must be critical")
+			}
+			return nil
+		}
+		err := tx.Commit()
+		if err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if !isCalledFailureCallback {
+			t.Fatal("cannot call failure callback")
+		}
+		xid := strings.TrimPrefix(recorder.executedQueries("shard1")[0], "XA START ")
+		if !strings.Contains(err.Error(), xid) {
+			t.Fatalf("error must have xid %s: %s", xid, err)
+		}
+		if queries := recorder.executedQueries("shard1"); !isPrefixOf(queries, []string{"XA START", "delete", "XA END", "XA PREPARE"}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+		if queries := recorder.executedQueries("shard2"); !isPrefixOf(queries, []string{"XA START", "delete", "XA END", "XA PREPARE", "XA COMMIT"}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+	})
+	t.Run("rollback", func(t *testing.T) {
+		recorder.reset(nil)
+		tx := (&DBConnection{}).Begin(nil, nil)
+		stmt, err := tx.Prepare(nil, conns[0], "select * from user_stages where id = ?")
+		checkErr(t, err)
+		if _, err := tx.Stmt(nil, conns[0], stmt); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		checkErr(t, tx.Rollback())
+		if queries := recorder.executedQueries("shard1"); !isPrefixOf(queries, []string{"XA START", "XA END", "XA ROLLBACK"}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+	})
+}
+
+func TestCommitErrorAfterReadOnlyCommit(t *testing.T) {
+	recorder := &XARecordDriver{}
+	sql.Register("read_only_record", recorder)
+	conns := []*XARecordConnection{}
+	for _, name := range []string{"shard1", "shard2"} {
+		db, err := sql.Open("read_only_record", name)
+		checkErr(t, err)
+		defer db.Close()
+		conns = append(conns, &XARecordConnection{dsn: name, db: db})
+	}
+	recorder.reset(map[string]string{"shard2": "COMMIT"})
+	tx := (&DBConnection{}).Begin(nil, nil)
+	if _, err := tx.QueryRow(nil, conns[0], "select * from user_stages where id = 1"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := tx.Exec(nil, conns[1], "delete from user_stages where id = 1"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	isCalledFailureCallback := false
+	tx.AfterCommitFailureCallback = func(isCriticalError bool, failureQueries []*QueryLog) error {
+		isCalledFailureCallback = true
+		if isCriticalError {
+			t.Fatal("commit error after only read only transaction is committed must not be critical")
+		}
+		return nil
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	if !isCalledFailureCallback {
+		t.Fatal("cannot call failure callback")
+	}
+}
+
+func TestFileCoordinatorLogStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octillery_coordinator_log")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewFileCoordinatorLogStore(dir)
+	checkErr(t, err)
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	log := &TransactionLog{
+		ID: newTransactionID(),
+		Shards: []*ShardTransactionLog{
+			{
+				DSN: "user_shard_1",
+				WriteQueries: []*QueryLog{
+					{Query: "insert into users(id, name) values (?, ?)", Args: []interface{}{1, "alice"}, LastInsertID: 1},
+				},
+			},
+			{
+				DSN: "user_item_shard_1",
+				WriteQueries: []*QueryLog{
+					{Query: "insert into user_items(user_id, data, rate, created_at, deleted_at) values (?, ?, ?, ?, ?)", Args: []interface{}{int64(1), []byte{0, 1}, 0.5, createdAt, nil}},
+				},
+			},
+		},
+	}
+	log.CreatedAt = createdAt
+	checkErr(t, store.Write(log))
+	log.Shards[0].Committed = true
+	checkErr(t, store.Write(log))
+	logs, err := store.Logs()
+	checkErr(t, err)
+	if len(logs) != 1 || logs[0].ID != log.ID || !logs[0].Shards[0].Committed || logs[0].Shards[1].Committed {
+		t.Fatalf("cannot read coordinator log %v", logs)
+	}
+	if !logs[0].CreatedAt.Equal(createdAt) {
+		t.Fatalf("cannot decode created time %s", logs[0].CreatedAt)
+	}
+	if args := logs[0].Shards[0].WriteQueries[0].Args; !reflect.DeepEqual(args, []interface{}{1, "alice"}) {
+		t.Fatalf("cannot decode args %v", args)
+	}
+	if args := logs[0].Shards[1].WriteQueries[0].Args; !reflect.DeepEqual(args, []interface{}{1, []byte{0, 1}, 0.5, createdAt, nil}) {
+		t.Fatalf("cannot decode args %v", args)
+	}
+	checkErr(t, store.Delete(log.ID))
+	logs, err = store.Logs()
+	checkErr(t, err)
+	if len(logs) != 0 {
+		t.Fatal("cannot delete coordinator log")
+	}
+}
+
+type failCommitDecisionStore struct {
+	*FileCoordinatorLogStore
+}
+
+func (s *failCommitDecisionStore) Write(log *TransactionLog) error {
+	if log.CommitDecided {
+		return errors.New("failed to write commit decision")
+	}
+	return s.FileCoordinatorLogStore.Write(log)
+}
+
+func TestCoordinatorLogForCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octillery_coordinator_log")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewFileCoordinatorLogStore(dir)
+	checkErr(t, err)
+	SetCoordinatorLogStore(store)
+	defer SetCoordinatorLogStore(nil)
+
+	recorder := &XARecordDriver{}
+	sql.Register("coordinator_log_record", recorder)
+	conns := []*XARecordConnection{}
+	for _, name := range []string{"shard1", "shard2"} {
+		db, err := sql.Open("coordinator_log_record", name)
+		checkErr(t, err)
+		defer db.Close()
+		conns = append(conns, &XARecordConnection{dsn: name, db: db})
+	}
+	commit := func() error {
+		tx := (&DBConnection{}).Begin(nil, nil)
+		for _, conn := range conns {
+			if _, err := tx.Exec(nil, conn, "delete from user_stages where id = 1"); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+		}
+		return tx.Commit()
+	}
+	t.Run("delete log after commit", func(t *testing.T) {
+		recorder.reset(nil)
+		checkErr(t, commit())
+		logs, err := store.Logs()
+		checkErr(t, err)
+		if len(logs) != 0 {
+			t.Fatal("coordinator log must be deleted")
+		}
+	})
+	t.Run("delete log if nothing is committed", func(t *testing.T) {
+		recorder.reset(map[string]string{"shard1": "COMMIT"})
+		if err := commit(); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		logs, err := store.Logs()
+		checkErr(t, err)
+		if len(logs) != 0 {
+			t.Fatal("coordinator log must be deleted")
+		}
+	})
+	t.Run("keep log for partial commit", func(t *testing.T) {
+		recorder.reset(map[string]string{"shard2": "COMMIT"})
+		if err := commit(); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		logs, err := store.Logs()
+		checkErr(t, err)
+		if len(logs) != 1 {
+			t.Fatal("coordinator log must remain")
+		}
+		shards := logs[0].Shards
+		if len(shards) != 2 || shards[0].DSN != "shard1" || !shards[0].Committed || shards[1].Committed {
+			t.Fatalf("invalid coordinator log %v", logs[0])
+		}
+		if shards[1].WriteQueries[0].Query != "delete from user_stages where id = 1" {
+			t.Fatal("cannot record write queries")
+		}
+		checkErr(t, store.Delete(logs[0].ID))
+	})
+	t.Run("keep log with xid for XA commit error", func(t *testing.T) {
+		globalConfig.XATransaction = true
+		defer func() { globalConfig.XATransaction = false }()
+		recorder.reset(map[string]string{"shard1": "XA COMMIT"})
+		if err := commit(); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		logs, err := store.Logs()
+		checkErr(t, err)
+		if len(logs) != 1 || !logs[0].XA || !logs[0].CommitDecided {
+			t.Fatalf("invalid coordinator log %v", logs)
+		}
+		shards := logs[0].Shards
+		if len(shards) != 2 || shards[0].Committed || shards[0].XABranch != 1 || !shards[1].Committed || shards[1].XABranch != 2 {
+			t.Fatalf("invalid coordinator log %v", logs[0])
+		}
+		checkErr(t, store.Delete(logs[0].ID))
+	})
+	t.Run("rollback all by error of writing commit decision", func(t *testing.T) {
+		globalConfig.XATransaction = true
+		defer func() { globalConfig.XATransaction = false }()
+		SetCoordinatorLogStore(&failCommitDecisionStore{FileCoordinatorLogStore: store})
+		defer SetCoordinatorLogStore(store)
+		recorder.reset(nil)
+		if err := commit(); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		for _, conn := range conns {
+			queries := recorder.executedQueries(conn.dsn)
+			if len(queries) != 5 || !strings.HasPrefix(queries[3], "XA PREPARE") || !strings.HasPrefix(queries[4], "XA ROLLBACK") {
+				t.Fatalf("invalid queries %v", queries)
+			}
+		}
+		logs, err := store.Logs()
+		checkErr(t, err)
+		if len(logs) != 0 {
+			t.Fatal("coordinator log must be deleted")
+		}
+	})
+	t.Run("delete log by XA prepare error", func(t *testing.T) {
+		globalConfig.XATransaction = true
+		defer func() { globalConfig.XATransaction = false }()
+		recorder.reset(map[string]string{"shard2": "XA PREPARE"})
+		if err := commit(); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		logs, err := store.Logs()
+		checkErr(t, err)
+		if len(logs) != 0 {
+			t.Fatal("coordinator log must be deleted")
+		}
+	})
+}
+
+func TestRecoverXATransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octillery_coordinator_log")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewFileCoordinatorLogStore(dir)
+	checkErr(t, err)
+
+	recorder := &XARecordDriver{}
+	sql.Register("xa_recover_record", recorder)
+	conns := map[string]*sql.DB{}
+	for _, name := range []string{"shard1", "shard2"} {
+		db, err := sql.Open("xa_recover_record", name)
+		checkErr(t, err)
+		defer db.Close()
+		conns[name] = db
+	}
+	connByDSN := func(dsn string) (*sql.DB, error) {
+		return conns[dsn], nil
+	}
+	newLog := func() *TransactionLog {
+		return &TransactionLog{
+			ID: newTransactionID(),
+			XA: true,
+			Shards: []*ShardTransactionLog{
+				{DSN: "shard1", XABranch: 1, WriteQueries: []*QueryLog{{Query: "delete from user_stages where id = 1"}}},
+				{DSN: "shard2", XABranch: 2, WriteQueries: []*QueryLog{{Query: "delete from user_stages where id = 1"}}},
+			},
+		}
+	}
+	recoverLog := func(log *TransactionLog, preparedShards ...string) bool {
+		recorder.reset(nil)
+		for _, shard := range log.Shards {
+			for _, name := range preparedShards {
+				if shard.DSN == name {
+					recorder.preparedXID[name] = []string{"other", fmt.Sprintf("%s%d", log.ID, shard.XABranch)}
+				}
+			}
+		}
+		checkErr(t, store.Write(log))
+		committed, err := RecoverXATransaction(store, log, connByDSN)
+		checkErr(t, err)
+		logs, err := store.Logs()
+		checkErr(t, err)
+		if len(logs) != 0 {
+			t.Fatal("coordinator log must be deleted")
+		}
+		return committed
+	}
+	t.Run("rollback all prepared transactions without commit decision", func(t *testing.T) {
+		log := newLog()
+		if recoverLog(log, "shard1", "shard2") {
+			t.Fatal("transaction must be rollbacked")
+		}
+		for idx, name := range []string{"shard1", "shard2"} {
+			expected := []string{"XA RECOVER", fmt.Sprintf("XA ROLLBACK '%s','%d'", log.ID, idx+1)}
+			if queries := recorder.executedQueries(name); !reflect.DeepEqual(queries, expected) {
+				t.Fatalf("invalid queries %v", queries)
+			}
+		}
+	})
+	t.Run("commit rest after partial commit", func(t *testing.T) {
+		log := newLog()
+		log.CommitDecided = true
+		log.Shards[0].Committed = true
+		if !recoverLog(log, "shard2") {
+			t.Fatal("transaction must be committed")
+		}
+		if queries := recorder.executedQueries("shard1"); len(queries) != 0 {
+			t.Fatalf("invalid queries %v", queries)
+		}
+		if queries := recorder.executedQueries("shard2"); !reflect.DeepEqual(queries, []string{"XA RECOVER", fmt.Sprintf("XA COMMIT '%s','2'", log.ID)}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+	})
+	t.Run("commit decided", func(t *testing.T) {
+		log := newLog()
+		log.CommitDecided = true
+		if !recoverLog(log, "shard2") {
+			t.Fatal("transaction must be committed")
+		}
+		if queries := recorder.executedQueries("shard1"); !reflect.DeepEqual(queries, []string{"XA RECOVER"}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+		if queries := recorder.executedQueries("shard2"); !reflect.DeepEqual(queries, []string{"XA RECOVER", fmt.Sprintf("XA COMMIT '%s','2'", log.ID)}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+	})
+	t.Run("rollback prepared transactions", func(t *testing.T) {
+		log := newLog()
+		if recoverLog(log, "shard1") {
+			t.Fatal("transaction must be rollbacked")
+		}
+		if queries := recorder.executedQueries("shard1"); !reflect.DeepEqual(queries, []string{"XA RECOVER", fmt.Sprintf("XA ROLLBACK '%s','1'", log.ID)}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+		if queries := recorder.executedQueries("shard2"); !reflect.DeepEqual(queries, []string{"XA RECOVER"}) {
+			t.Fatalf("invalid queries %v", queries)
+		}
+	})
+	t.Run("skip log in grace period", func(t *testing.T) {
+		log := newLog()
+		log.CreatedAt = time.Now()
+		recorder.reset(nil)
+		recorder.preparedXID["shard1"] = []string{log.ID + "1"}
+		checkErr(t, store.Write(log))
+		if _, err := RecoverXATransaction(store, log, connByDSN); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if queries := recorder.executedQueries("shard1"); len(queries) != 0 {
+			t.Fatalf("invalid queries %v", queries)
+		}
+		logs, err := store.Logs()
+		checkErr(t, err)
+		if len(logs) != 1 {
+			t.Fatal("coordinator log must remain")
+		}
+		checkErr(t, store.Delete(log.ID))
+	})
+	t.Run("keep log by error", func(t *testing.T) {
+		log := newLog()
+		log.Shards[1].Committed = true
+		recorder.reset(map[string]string{"shard1": "XA COMMIT"})
+		recorder.preparedXID["shard1"] = []string{log.ID + "1"}
+		checkErr(t, store.Write(log))
+		if _, err := RecoverXATransaction(store, log, connByDSN); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		logs, err := store.Logs()
+		checkErr(t, err)
+		if len(logs) != 1 || !logs[0].CommitDecided {
+			t.Fatalf("commit decision must be recorded %v", logs)
+		}
+		checkErr(t, store.Delete(log.ID))
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	sink := NewChannelSink(3)
+	SetOutboxSink(sink)
+	defer SetOutboxSink(nil)
+
+	recorder := &XARecordDriver{}
+	sql.Register("outbox_record", recorder)
+	conns := []*DBShardConnection{}
+	for _, name := range []string{"user_shard_1", "user_item_shard_1"} {
+		db, err := sql.Open("outbox_record", name)
+		checkErr(t, err)
+		defer db.Close()
+		conns = append(conns, &DBShardConnection{ShardName: name, Connection: db, dsn: name})
+	}
+	commit := func() error {
+		tx := (&DBConnection{}).Begin(nil, nil)
+		if _, err := tx.Exec(nil, conns[0], "insert into users(id, name, age) values (3, ?, 10)", "bob"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Exec(nil, conns[1], "delete from user_items where user_id in (1, 2)"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.QueryRow(nil, conns[1], "select * from user_items where user_id = 1"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		return tx.Commit()
+	}
+	t.Run("publish after commit", func(t *testing.T) {
+		recorder.reset(nil)
+		checkErr(t, commit())
+		insertEvent := <-sink.Events()
+		deleteEvent := <-sink.Events()
+		if insertEvent.TransactionID == "" || insertEvent.TransactionID != deleteEvent.TransactionID {
+			t.Fatal("invalid transaction id")
+		}
+		if insertEvent.Table != "users" || insertEvent.Shard != "user_shard_1" || insertEvent.Operation != "insert" {
+			t.Fatalf("invalid change event %v", insertEvent)
+		}
+		if !reflect.DeepEqual(insertEvent.Keys, []interface{}{int64(3)}) || !reflect.DeepEqual(insertEvent.Args, []interface{}{"bob"}) {
+			t.Fatalf("invalid change event %v", insertEvent)
+		}
+		if deleteEvent.Table != "user_items" || deleteEvent.Shard != "user_item_shard_1" || deleteEvent.Operation != "delete" {
+			t.Fatalf("invalid change event %v", deleteEvent)
+		}
+		if !reflect.DeepEqual(deleteEvent.Keys, []interface{}{int64(1), int64(2)}) {
+			t.Fatalf("invalid change event %v", deleteEvent)
+		}
+	})
+	t.Run("not publish by commit error", func(t *testing.T) {
+		recorder.reset(map[string]string{"user_shard_1": "COMMIT"})
+		if err := commit(); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if len(sink.Events()) != 0 {
+			t.Fatal("change events must not be published")
+		}
+	})
+	t.Run("channel is full", func(t *testing.T) {
+		defaultCallback := globalPublishFailureCallback
+		defer func() { globalPublishFailureCallback = defaultCallback }()
+		var failedEvents []*ChangeEvent
+		SetPublishFailureCallback(func(events []*ChangeEvent, err error) {
+			if err == nil {
+				t.Fatal("cannot pass error")
+			}
+			failedEvents = events
+		})
+		recorder.reset(nil)
+		checkErr(t, commit())
+		// transaction is committed even if failed to publish
+		checkErr(t, commit())
+		if len(failedEvents) != 2 || failedEvents[0].Table != "users" || failedEvents[1].Table != "user_items" {
+			t.Fatalf("cannot pass failed events %v", failedEvents)
+		}
+		if len(sink.Events()) != 2 {
+			t.Fatal("events must not be published partially")
+		}
+		for i := 0; i < 2; i++ {
+			if event := <-sink.Events(); event.TransactionID == failedEvents[0].TransactionID {
+				t.Fatal("events must not be published partially")
+			}
+		}
+	})
+	t.Run("file sink", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "octillery_outbox")
+		checkErr(t, err)
+		file.Close()
+		defer os.Remove(file.Name())
+		SetOutboxSink(NewFileSink(file.Name()))
+		recorder.reset(nil)
+		checkErr(t, commit())
+		checkErr(t, commit())
+		bytes, err := ioutil.ReadFile(file.Name())
+		checkErr(t, err)
+		lines := strings.Split(strings.TrimSpace(string(bytes)), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("cannot append change events %v", lines)
+		}
+		var event ChangeEvent
+		checkErr(t, json.Unmarshal([]byte(lines[3]), &event))
+		if event.Table != "user_items" || event.Operation != "delete" || len(event.Keys) != 2 {
+			t.Fatalf("invalid change event %v", event)
+		}
+	})
+}
+
+func TestSavepoint(t *testing.T) {
+	recorder := &XARecordDriver{}
+	sql.Register("savepoint_record", recorder)
+	conns := []*XARecordConnection{}
+	for _, name := range []string{"shard_1", "shard_2"} {
+		db, err := sql.Open("savepoint_record", name)
+		checkErr(t, err)
+		defer db.Close()
+		conns = append(conns, &XARecordConnection{dsn: name, db: db})
+	}
+	t.Run("apply to shards joined later", func(t *testing.T) {
+		recorder.reset(nil)
+		tx := NewTxConnection(nil, nil)
+		checkErr(t, tx.Savepoint(nil, "sp1"))
+		if _, err := tx.Exec(nil, conns[0], "update users set age = 1"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		checkErr(t, tx.Savepoint(nil, "sp2"))
+		if _, err := tx.Exec(nil, conns[0], "update users set age = 2"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Exec(nil, conns[1], "update users set age = 2"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		checkErr(t, tx.RollbackToSavepoint(nil, "sp2"))
+		if len(tx.WriteQueries) != 1 || len(tx.txToWriteQueries[tx.dsnToTx["shard_2"]]) != 0 {
+			t.Fatal("cannot discard write queries after savepoint")
+		}
+		checkErr(t, tx.ReleaseSavepoint(nil, "sp1"))
+		if err := tx.RollbackToSavepoint(nil, "sp2"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		checkErr(t, tx.Commit())
+		if !reflect.DeepEqual(recorder.executedQueries("shard_1"), []string{
+			"SAVEPOINT sp1",
+			"update users set age = 1",
+			"SAVEPOINT sp2",
+			"update users set age = 2",
+			"ROLLBACK TO SAVEPOINT sp2",
+			"RELEASE SAVEPOINT sp1",
+			"COMMIT",
+		}) {
+			t.Fatalf("invalid queries %v", recorder.executedQueries("shard_1"))
+		}
+		if !reflect.DeepEqual(recorder.executedQueries("shard_2"), []string{
+			"SAVEPOINT sp1",
+			"SAVEPOINT sp2",
+			"update users set age = 2",
+			"ROLLBACK TO SAVEPOINT sp2",
+			"RELEASE SAVEPOINT sp1",
+			"COMMIT",
+		}) {
+			t.Fatalf("invalid queries %v", recorder.executedQueries("shard_2"))
+		}
+	})
+	t.Run("unusable by rollback to savepoint error", func(t *testing.T) {
+		recorder.reset(map[string]string{"shard_2": "ROLLBACK TO SAVEPOINT"})
+		tx := NewTxConnection(nil, nil)
+		checkErr(t, tx.Savepoint(nil, "sp1"))
+		for _, conn := range conns {
+			if _, err := tx.Exec(nil, conn, "update users set age = 1"); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+		}
+		if err := tx.RollbackToSavepoint(nil, "sp1"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if _, err := tx.Exec(nil, conns[0], "update users set age = 2"); err == nil {
+			t.Fatal("transaction must be unusable")
+		}
+		if err := tx.Savepoint(nil, "sp2"); err == nil {
+			t.Fatal("transaction must be unusable")
+		}
+		if err := tx.RollbackToSavepoint(nil, "sp1"); err == nil {
+			t.Fatal("transaction must be unusable")
+		}
+		if err := tx.Commit(); err == nil {
+			t.Fatal("transaction must be unusable")
+		}
+		checkErr(t, tx.Rollback())
+		for _, name := range []string{"shard_1", "shard_2"} {
+			queries := recorder.executedQueries(name)
+			if queries[len(queries)-1] != "ROLLBACK" {
+				t.Fatalf("invalid queries %v", queries)
+			}
+		}
+	})
+	t.Run("invalid savepoint name", func(t *testing.T) {
+		tx := NewTxConnection(nil, nil)
+		if err := tx.Savepoint(nil, "sp; DROP TABLE users"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("cannot join by savepoint error", func(t *testing.T) {
+		recorder.reset(map[string]string{"shard_2": "SAVEPOINT"})
+		tx := NewTxConnection(nil, nil)
+		checkErr(t, tx.Savepoint(nil, "sp1"))
+		if _, err := tx.Exec(nil, conns[1], "update users set age = 1"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if !reflect.DeepEqual(recorder.executedQueries("shard_2"), []string{"ROLLBACK"}) {
+			t.Fatalf("invalid queries %v", recorder.executedQueries("shard_2"))
+		}
+	})
+}
@@ -0,0 +1,519 @@
+package connection
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
+	adap "github.com/aokabi/octillery/connection/adapter"
+	"github.com/aokabi/octillery/debug"
+)
+
+var (
+	coordinatorLogStore       CoordinatorLogStore
+	coordinatorLogGracePeriod = config.DefaultCoordinatorLogGracePeriod
+)
+
+// TransactionLog is a record of distributed transaction written by coordinator before commit.
+// If it remains in the store, the transaction is in-doubt ( committed to some databases partially ).
+type TransactionLog struct {
+	ID     string
+	Shards []*ShardTransactionLog
+
+	// true if transaction is XA transaction. ID is used as gtrid
+	XA bool
+
+	// true after all XA transactions are prepared. the transaction must be committed.
+	// if false, the transaction is never committed to any databases and it must be rollbacked
+	CommitDecided bool
+
+	// time when coordinator started to commit. zero if unknown
+	CreatedAt time.Time
+}
+
+// IsInGracePeriod returns whether the transaction may be still committing by coordinator.
+// Log that has no CreatedAt is not in grace period.
+func (l *TransactionLog) IsInGracePeriod() bool {
+	return time.Since(l.CreatedAt) < coordinatorLogGracePeriod
+}
+
+// ShardTransactionLog is a record of transaction for each database.
+type ShardTransactionLog struct {
+	DSN          string
+	Committed    bool
+	WriteQueries []*QueryLog
+
+	// branch qualifier of XA transaction
+	XABranch int
+}
+
+// CoordinatorLogStore is a durable store for TransactionLog.
+//
+// octillery supports file and sql table store.
+// If use the other store, implement the following interface and call connection.SetCoordinatorLogStore(store).
+type CoordinatorLogStore interface {
+	// write transaction log. if log that has same id already exists, overwrite it
+	Write(log *TransactionLog) error
+
+	// delete transaction log by id
+	Delete(id string) error
+
+	// get all transaction logs remaining in store
+	Logs() ([]*TransactionLog, error)
+}
+
+// SetCoordinatorLogStore set CoordinatorLogStore to internal global variable.
+// If store is nil, coordinator log is disabled.
+func SetCoordinatorLogStore(store CoordinatorLogStore) {
+	coordinatorLogStore = store
+}
+
+// SetCoordinatorLogGracePeriod set time to wait before in-doubt transaction is recovered.
+// Commit of transaction must finish in this period, otherwise recovery may finish it while coordinator is committing.
+func SetCoordinatorLogGracePeriod(period time.Duration) {
+	coordinatorLogGracePeriod = period
+}
+
+// CoordinatorLog returns CoordinatorLogStore set by configuration or SetCoordinatorLogStore.
+func CoordinatorLog() CoordinatorLogStore {
+	return coordinatorLogStore
+}
+
+type queryLogArgRecord struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type queryLogRecord struct {
+	Query        string               `json:"query"`
+	Args         []*queryLogArgRecord `json:"args"`
+	LastInsertID int64                `json:"lastInsertId"`
+}
+
+type shardTransactionLogRecord struct {
+	DSN          string            `json:"dsn"`
+	Committed    bool              `json:"committed"`
+	WriteQueries []*queryLogRecord `json:"writeQueries"`
+	XABranch     int               `json:"xaBranch,omitempty"`
+}
+
+type transactionLogRecord struct {
+	ID            string                       `json:"id"`
+	Shards        []*shardTransactionLogRecord `json:"shards"`
+	XA            bool                         `json:"xa,omitempty"`
+	CommitDecided bool                         `json:"commitDecided,omitempty"`
+	CreatedAt     string                       `json:"createdAt,omitempty"`
+}
+
+// encodeQueryLogArg encodes argument of query with type name, because JSON cannot keep type of value ( e.g. int64 and []byte ).
+func encodeQueryLogArg(arg interface{}) (*queryLogArgRecord, error) {
+	value, err := driver.DefaultParameterConverter.ConvertValue(arg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var typ string
+	switch v := value.(type) {
+	case nil:
+		return &queryLogArgRecord{Type: "null"}, nil
+	case int64:
+		typ = "int"
+	case float64:
+		typ = "float"
+	case bool:
+		typ = "bool"
+	case []byte:
+		typ = "bytes"
+	case string:
+		typ = "string"
+	case time.Time:
+		typ = "time"
+		value = v.Format(time.RFC3339Nano)
+	default:
+		return nil, errors.Errorf("cannot encode argument %v", arg)
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &queryLogArgRecord{Type: typ, Value: bytes}, nil
+}
+
+func decodeQueryLogArg(record *queryLogArgRecord) (interface{}, error) {
+	var (
+		value interface{}
+		err   error
+	)
+	switch record.Type {
+	case "null":
+		return nil, nil
+	case "int":
+		var v int
+		err = json.Unmarshal(record.Value, &v)
+		value = v
+	case "float":
+		var v float64
+		err = json.Unmarshal(record.Value, &v)
+		value = v
+	case "bool":
+		var v bool
+		err = json.Unmarshal(record.Value, &v)
+		value = v
+	case "bytes":
+		var v []byte
+		err = json.Unmarshal(record.Value, &v)
+		value = v
+	case "string":
+		var v string
+		err = json.Unmarshal(record.Value, &v)
+		value = v
+	case "time":
+		var v string
+		if err := json.Unmarshal(record.Value, &v); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		value, err = time.Parse(time.RFC3339Nano, v)
+	default:
+		return nil, errors.Errorf("unknown argument type %s", record.Type)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return value, nil
+}
+
+// MarshalJSON encodes TransactionLog with type of query arguments.
+func (l *TransactionLog) MarshalJSON() ([]byte, error) {
+	record := &transactionLogRecord{ID: l.ID, XA: l.XA, CommitDecided: l.CommitDecided}
+	if !l.CreatedAt.IsZero() {
+		record.CreatedAt = l.CreatedAt.Format(time.RFC3339Nano)
+	}
+	for _, shard := range l.Shards {
+		shardRecord := &shardTransactionLogRecord{DSN: shard.DSN, Committed: shard.Committed, XABranch: shard.XABranch}
+		for _, query := range shard.WriteQueries {
+			queryRecord := &queryLogRecord{Query: query.Query, LastInsertID: query.LastInsertID}
+			for _, arg := range query.Args {
+				argRecord, err := encodeQueryLogArg(arg)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				queryRecord.Args = append(queryRecord.Args, argRecord)
+			}
+			shardRecord.WriteQueries = append(shardRecord.WriteQueries, queryRecord)
+		}
+		record.Shards = append(record.Shards, shardRecord)
+	}
+	return json.Marshal(record)
+}
+
+// UnmarshalJSON decodes TransactionLog encoded by MarshalJSON.
+func (l *TransactionLog) UnmarshalJSON(data []byte) error {
+	var record transactionLogRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return errors.WithStack(err)
+	}
+	l.ID = record.ID
+	l.XA = record.XA
+	l.CommitDecided = record.CommitDecided
+	if record.CreatedAt != "" {
+		createdAt, err := time.Parse(time.RFC3339Nano, record.CreatedAt)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		l.CreatedAt = createdAt
+	}
+	l.Shards = []*ShardTransactionLog{}
+	for _, shardRecord := range record.Shards {
+		shard := &ShardTransactionLog{DSN: shardRecord.DSN, Committed: shardRecord.Committed, XABranch: shardRecord.XABranch}
+		for _, queryRecord := range shardRecord.WriteQueries {
+			query := &QueryLog{Query: queryRecord.Query, LastInsertID: queryRecord.LastInsertID}
+			for _, argRecord := range queryRecord.Args {
+				arg, err := decodeQueryLogArg(argRecord)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				query.Args = append(query.Args, arg)
+			}
+			shard.WriteQueries = append(shard.WriteQueries, query)
+		}
+		l.Shards = append(l.Shards, shard)
+	}
+	return nil
+}
+
+// FileCoordinatorLogStore stores TransactionLog as JSON file per transaction in the directory.
+type FileCoordinatorLogStore struct {
+	dir string
+}
+
+// NewFileCoordinatorLogStore creates instance of FileCoordinatorLogStore. If the directory doesn't exist, create it.
+func NewFileCoordinatorLogStore(dir string) (*FileCoordinatorLogStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &FileCoordinatorLogStore{dir: dir}, nil
+}
+
+func (s *FileCoordinatorLogStore) path(id string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s.json", id))
+}
+
+// Write writes log to temporary file with fsync and rename it, so log file is never broken.
+func (s *FileCoordinatorLogStore) Write(log *TransactionLog) error {
+	bytes, err := json.Marshal(log)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	file, err := ioutil.TempFile(s.dir, fmt.Sprintf("%s.*.tmp", log.ID))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmpPath := file.Name()
+	if err := func() error {
+		defer file.Close()
+		if _, err := file.Write(bytes); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(file.Sync())
+	}(); err != nil {
+		os.Remove(tmpPath)
+		return errors.WithStack(err)
+	}
+	if err := os.Rename(tmpPath, s.path(log.ID)); err != nil {
+		os.Remove(tmpPath)
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Delete removes log file.
+func (s *FileCoordinatorLogStore) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Logs reads all log files in the directory.
+func (s *FileCoordinatorLogStore) Logs() ([]*TransactionLog, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Strings(paths)
+	logs := []*TransactionLog{}
+	for _, path := range paths {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var log TransactionLog
+		if err := json.Unmarshal(bytes, &log); err != nil {
+			return nil, errors.Wrapf(err, "cannot decode %s", path)
+		}
+		logs = append(logs, &log)
+	}
+	return logs, nil
+}
+
+// SQLCoordinatorLogStore stores TransactionLog as row of table.
+type SQLCoordinatorLogStore struct {
+	conn      *sql.DB
+	tableName string
+}
+
+// NewSQLCoordinatorLogStore creates instance of SQLCoordinatorLogStore.
+func NewSQLCoordinatorLogStore(conn *sql.DB, tableName string) *SQLCoordinatorLogStore {
+	return &SQLCoordinatorLogStore{conn: conn, tableName: tableName}
+}
+
+// CreateTableIfNotExists create table for coordinator log if not exists
+func (s *SQLCoordinatorLogStore) CreateTableIfNotExists() error {
+	_, err := s.conn.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    id varchar(255) NOT NULL PRIMARY KEY,
+    log text NOT NULL
+)`, s.tableName))
+	return errors.Wrap(err, "cannot create table for coordinator log")
+}
+
+// Write replaces row by id in a transaction.
+func (s *SQLCoordinatorLogStore) Write(log *TransactionLog) (e error) {
+	bytes, err := json.Marshal(log)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.tableName), log.ID); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s(id, log) VALUES (?, ?)", s.tableName), log.ID, string(bytes)); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+// Delete deletes row by id.
+func (s *SQLCoordinatorLogStore) Delete(id string) error {
+	_, err := s.conn.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.tableName), id)
+	return errors.WithStack(err)
+}
+
+// Logs selects all rows ordered by id.
+func (s *SQLCoordinatorLogStore) Logs() ([]*TransactionLog, error) {
+	rows, err := s.conn.Query(fmt.Sprintf("SELECT log FROM %s ORDER BY id", s.tableName))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	logs := []*TransactionLog{}
+	for rows.Next() {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var log TransactionLog
+		if err := json.Unmarshal([]byte(text), &log); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		logs = append(logs, &log)
+	}
+	return logs, errors.WithStack(rows.Err())
+}
+
+// Close closes connection for the table.
+func (s *SQLCoordinatorLogStore) Close() error {
+	return s.conn.Close()
+}
+
+func setupCoordinatorLog(cfg *config.Config) error {
+	logConfig := cfg.CoordinatorLog
+	if logConfig == nil {
+		coordinatorLogStore = nil
+		return nil
+	}
+	coordinatorLogGracePeriod = logConfig.GracePeriodDuration()
+	switch logConfig.Store {
+	case "file":
+		store, err := NewFileCoordinatorLogStore(logConfig.Path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		coordinatorLogStore = store
+	case "sql":
+		adapter, err := adap.Adapter(logConfig.Adapter)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !cfg.SkipAutoSetup {
+			if err := adapter.ExecDDL(&logConfig.DatabaseConfig); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		conn, err := adapter.OpenConnection(&logConfig.DatabaseConfig, "")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		store := NewSQLCoordinatorLogStore(conn, logConfig.TableName())
+		if !cfg.SkipAutoSetup {
+			if err := store.CreateTableIfNotExists(); err != nil {
+				conn.Close()
+				return errors.WithStack(err)
+			}
+		}
+		coordinatorLogStore = store
+	default:
+		return errors.Errorf("unknown coordinator log store %s", logConfig.Store)
+	}
+	return nil
+}
+
+// newTransactionLog creates log of TxConnection for databases that have write queries.
+// In XA transaction, log has all databases because read only transaction is also prepared.
+// Transaction for single database is committed atomically, so returns nil in this case.
+func (c *TxConnection) newTransactionLog() *TransactionLog {
+	log := &TransactionLog{ID: c.id, XA: c.isXA, CreatedAt: time.Now()}
+	for _, dsn := range c.dsnList {
+		tx := c.dsnToTx[dsn]
+		queries := c.txToWriteQueries[tx]
+		if xa, ok := tx.(*xaTx); ok {
+			log.Shards = append(log.Shards, &ShardTransactionLog{DSN: dsn, WriteQueries: queries, XABranch: xa.branch})
+			continue
+		}
+		if len(queries) == 0 {
+			continue
+		}
+		log.Shards = append(log.Shards, &ShardTransactionLog{DSN: dsn, WriteQueries: queries})
+	}
+	if len(log.Shards) < 2 {
+		return nil
+	}
+	return log
+}
+
+// writeCoordinatorLog records intent to commit before committing to each database.
+func (c *TxConnection) writeCoordinatorLog() (*TransactionLog, error) {
+	if coordinatorLogStore == nil {
+		return nil, nil
+	}
+	log := c.newTransactionLog()
+	if log == nil {
+		return nil, nil
+	}
+	if err := coordinatorLogStore.Write(log); err != nil {
+		return nil, errors.Wrap(err, "cannot write coordinator log")
+	}
+	return log, nil
+}
+
+// markCommitDecidedToCoordinatorLog records that all XA transactions are prepared and commit is decided.
+// Recovery rollbacks prepared XA transactions without this record, so XA COMMIT must not be executed if failed to write.
+func (c *TxConnection) markCommitDecidedToCoordinatorLog(log *TransactionLog) error {
+	if log == nil {
+		return nil
+	}
+	log.CommitDecided = true
+	if err := coordinatorLogStore.Write(log); err != nil {
+		log.CommitDecided = false
+		return errors.Wrap(err, "cannot write commit decision to coordinator log")
+	}
+	return nil
+}
+
+func (c *TxConnection) markCommittedToCoordinatorLog(log *TransactionLog, dsn string) {
+	if log == nil {
+		return
+	}
+	for _, shard := range log.Shards {
+		if shard.DSN == dsn {
+			shard.Committed = true
+		}
+	}
+	if err := coordinatorLogStore.Write(log); err != nil {
+		debug.Printf("[WARN] cannot update coordinator log %s: %s", log.ID, err)
+	}
+}
+
+func (c *TxConnection) deleteCoordinatorLog(log *TransactionLog) {
+	if log == nil {
+		return
+	}
+	if err := coordinatorLogStore.Delete(log.ID); err != nil {
+		debug.Printf("[WARN] cannot delete coordinator log %s: %s", log.ID, err)
+	}
+}
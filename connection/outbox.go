@@ -0,0 +1,371 @@
+package connection
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
+	adap "github.com/aokabi/octillery/connection/adapter"
+	"github.com/aokabi/octillery/debug"
+	"github.com/aokabi/octillery/sqlparser"
+)
+
+var (
+	outboxSink                   OutboxSink
+	publishFailureCallbackMu     sync.RWMutex
+	globalPublishFailureCallback = func(events []*ChangeEvent, err error) {
+		debug.Printf("[WARN] cannot publish %d change events: %s", len(events), err)
+	}
+)
+
+// ChangeEvent is a change by write query committed in transaction.
+type ChangeEvent struct {
+	// id of committed transaction
+	TransactionID string `json:"transactionId"`
+
+	// table name
+	Table string `json:"table"`
+
+	// shard name. empty if table is not sharded
+	Shard string `json:"shard"`
+
+	// 'insert' , 'update' or 'delete'
+	Operation string `json:"operation"`
+
+	// values of shard_key. empty if query is executed for all shards or table is not sharded
+	Keys []interface{} `json:"keys"`
+
+	Query        string        `json:"query"`
+	Args         []interface{} `json:"args"`
+	LastInsertID int64         `json:"lastInsertId"`
+}
+
+// OutboxSink is a destination of ChangeEvent published after commit.
+//
+// octillery supports channel, file and sql table sink.
+// If use the other sink, implement the following interface and call connection.SetOutboxSink(sink).
+type OutboxSink interface {
+	// publish change events of a transaction by order of execution
+	Publish(events []*ChangeEvent) error
+}
+
+// SetOutboxSink set OutboxSink to internal global variable.
+// If sink is nil, change events are not published.
+func SetOutboxSink(sink OutboxSink) {
+	outboxSink = sink
+}
+
+// Outbox returns OutboxSink set by configuration or SetOutboxSink.
+func Outbox() OutboxSink {
+	return outboxSink
+}
+
+// SetPublishFailureCallback set function for it is callbacked when failed to publish change events.
+// Transaction is already committed at that time, so error of publishing is not returned by Commit.
+// By default, error is printed as warning.
+func SetPublishFailureCallback(callback func(events []*ChangeEvent, err error)) {
+	if callback == nil {
+		return
+	}
+	publishFailureCallbackMu.Lock()
+	defer publishFailureCallbackMu.Unlock()
+	globalPublishFailureCallback = callback
+}
+
+// ChannelSink sends ChangeEvent to buffered channel.
+type ChannelSink struct {
+	mu     sync.Mutex
+	events chan *ChangeEvent
+}
+
+// NewChannelSink creates instance of ChannelSink with buffer size of channel.
+func NewChannelSink(size int) *ChannelSink {
+	return &ChannelSink{events: make(chan *ChangeEvent, size)}
+}
+
+// Events returns channel to receive change events.
+func (s *ChannelSink) Events() <-chan *ChangeEvent {
+	return s.events
+}
+
+// Publish sends events to channel without blocking.
+// If buffer of channel doesn't have space for all events, returns error without sending any events.
+func (s *ChannelSink) Publish(events []*ChangeEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cap(s.events)-len(s.events) < len(events) {
+		return errors.Errorf("cannot publish change events of %s. channel is full", events[0].TransactionID)
+	}
+	for _, event := range events {
+		s.events <- event
+	}
+	return nil
+}
+
+// FileSink appends ChangeEvent as JSON line to the file.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSink creates instance of FileSink.
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Publish appends events to the file with fsync.
+func (s *FileSink) Publish(events []*ChangeEvent) error {
+	lines := []string{}
+	for _, event := range events {
+		bytes, err := json.Marshal(event)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		lines = append(lines, string(bytes)+"\n")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(strings.Join(lines, "")); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(file.Sync())
+}
+
+// SQLSink inserts ChangeEvent to outbox table.
+type SQLSink struct {
+	conn      *sql.DB
+	tableName string
+}
+
+// NewSQLSink creates instance of SQLSink.
+func NewSQLSink(conn *sql.DB, tableName string) *SQLSink {
+	return &SQLSink{conn: conn, tableName: tableName}
+}
+
+// CreateTableIfNotExists create outbox table if not exists
+func (s *SQLSink) CreateTableIfNotExists() error {
+	_, err := s.conn.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    transaction_id varchar(255) NOT NULL,
+    seq integer NOT NULL,
+    table_name varchar(255) NOT NULL,
+    shard_name varchar(255) NOT NULL,
+    operation varchar(16) NOT NULL,
+    event text NOT NULL,
+    PRIMARY KEY (transaction_id, seq)
+)`, s.tableName))
+	return errors.Wrap(err, "cannot create table for outbox")
+}
+
+// Publish inserts events by single transaction.
+func (s *SQLSink) Publish(events []*ChangeEvent) (e error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+	query := fmt.Sprintf("INSERT INTO %s(transaction_id, seq, table_name, shard_name, operation, event) VALUES (?, ?, ?, ?, ?, ?)", s.tableName)
+	for idx, event := range events {
+		bytes, err := json.Marshal(event)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err := tx.Exec(query, event.TransactionID, idx, event.Table, event.Shard, event.Operation, string(bytes)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+// Close closes connection for outbox table.
+func (s *SQLSink) Close() error {
+	return s.conn.Close()
+}
+
+func setupOutbox(cfg *config.Config) error {
+	outboxConfig := cfg.Outbox
+	if outboxConfig == nil {
+		outboxSink = nil
+		return nil
+	}
+	switch outboxConfig.Sink {
+	case "file":
+		outboxSink = NewFileSink(outboxConfig.Path)
+	case "sql":
+		adapter, err := adap.Adapter(outboxConfig.Adapter)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !cfg.SkipAutoSetup {
+			if err := adapter.ExecDDL(&outboxConfig.DatabaseConfig); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		conn, err := adapter.OpenConnection(&outboxConfig.DatabaseConfig, "")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		sink := NewSQLSink(conn, outboxConfig.TableName())
+		if !cfg.SkipAutoSetup {
+			if err := sink.CreateTableIfNotExists(); err != nil {
+				conn.Close()
+				return errors.WithStack(err)
+			}
+		}
+		outboxSink = sink
+	default:
+		return errors.Errorf("unknown outbox sink %s", outboxConfig.Sink)
+	}
+	return nil
+}
+
+// shardKeysByQuery returns values of shard_key decided by query.
+func shardKeysByQuery(query sqlparser.Query) []interface{} {
+	keys := []interface{}{}
+	table := globalConfig.Tables[query.Table()]
+	if table == nil || !table.IsShard {
+		return keys
+	}
+	switch q := query.(type) {
+	case *sqlparser.InsertQuery:
+		rowQueries := []*sqlparser.InsertQuery{q}
+		if q.IsMultiRows() {
+			rowQueries = q.RowQueries
+		}
+		isEqualShardColumnToShardKeyColumn := table.ShardKeyColumnName == "" || table.ShardKeyColumnName == table.ShardColumnName
+		for _, rowQuery := range rowQueries {
+			if isEqualShardColumnToShardKeyColumn {
+				if value := insertedShardColumnValue(rowQuery, table.ShardColumnName); value != nil {
+					keys = append(keys, value)
+				}
+			} else if rowQuery.ShardKeyID != sqlparser.UnknownID {
+				keys = append(keys, rowQuery.ShardKeyID.Value())
+			}
+		}
+		return keys
+	case *sqlparser.DeleteQuery:
+		return shardKeysByQueryBase(q.QueryBase)
+	case *sqlparser.QueryBase:
+		return shardKeysByQueryBase(q)
+	}
+	return keys
+}
+
+// insertedShardColumnValue returns value of shard_column written in committed INSERT query.
+// Committed query always has value of shard_column decided by sequencer or specified by upsert query.
+func insertedShardColumnValue(query *sqlparser.InsertQuery, columnName string) interface{} {
+	for idx, column := range query.Stmt.Columns {
+		if column.String() != columnName {
+			continue
+		}
+		value, ok := query.Stmt.Rows.(vtparser.Values)[0][idx].(*vtparser.SQLVal)
+		if !ok {
+			return nil
+		}
+		switch value.Type {
+		case vtparser.IntVal:
+			id, err := strconv.ParseInt(string(value.Val), 10, 64)
+			if err != nil {
+				return nil
+			}
+			return id
+		case vtparser.ValArg:
+			arg, err := query.ArgByValArg(value)
+			if err != nil {
+				return nil
+			}
+			return arg
+		}
+		return nil
+	}
+	return nil
+}
+
+func shardKeysByQueryBase(query *sqlparser.QueryBase) []interface{} {
+	if query.ShardKeyID != sqlparser.UnknownID {
+		return []interface{}{query.ShardKeyID.Value()}
+	}
+	keys := []interface{}{}
+	for _, key := range query.ShardKeyIDs {
+		keys = append(keys, key.Value())
+	}
+	return keys
+}
+
+// changeEvents creates ChangeEvent for each write query by order of execution.
+// If failed to parse query, it returns error with events that have only query and arguments for the query.
+func (c *TxConnection) changeEvents() ([]*ChangeEvent, error) {
+	events := []*ChangeEvent{}
+	errs := []string{}
+	parser, err := sqlparser.New()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, queryLog := range c.WriteQueries {
+		event := &ChangeEvent{
+			TransactionID: c.id,
+			Shard:         queryLog.shardName,
+			Keys:          []interface{}{},
+			Query:         queryLog.Query,
+			Args:          queryLog.Args,
+			LastInsertID:  queryLog.LastInsertID,
+		}
+		events = append(events, event)
+		if parser == nil {
+			continue
+		}
+		query, err := parser.Parse(queryLog.Query, queryLog.Args...)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		event.Table = query.Table()
+		event.Operation = strings.ToLower(query.QueryType().String())
+		event.Keys = shardKeysByQuery(query)
+	}
+	if len(errs) > 0 {
+		return events, errors.New(strings.Join(errs, ":"))
+	}
+	return events, nil
+}
+
+// publishChangeEvents publishes change events of committed transaction to outbox.
+// Error is passed to publish failure callback, because the transaction is already committed.
+func (c *TxConnection) publishChangeEvents() {
+	if outboxSink == nil || len(c.WriteQueries) == 0 {
+		return
+	}
+	events, err := c.changeEvents()
+	if err != nil {
+		c.publishFailed(events, errors.Wrap(err, "cannot create change events"))
+		return
+	}
+	if err := outboxSink.Publish(events); err != nil {
+		c.publishFailed(events, errors.Wrap(err, "cannot publish change events"))
+	}
+}
+
+func (c *TxConnection) publishFailed(events []*ChangeEvent, err error) {
+	publishFailureCallbackMu.RLock()
+	defer publishFailureCallbackMu.RUnlock()
+	globalPublishFailureCallback(events, err)
+}
@@ -0,0 +1,134 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/debug"
+)
+
+var savepointNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// savepoint is a savepoint set to all databases in transaction.
+// It remembers number of write queries at that time to discard queries rollbacked by `ROLLBACK TO SAVEPOINT`.
+type savepoint struct {
+	name             string
+	writeQueryNum    int
+	txWriteQueryNums map[shardTx]int
+}
+
+func validateSavepointName(name string) error {
+	if !savepointNamePattern.MatchString(name) {
+		return errors.Errorf("invalid savepoint name %s", name)
+	}
+	return nil
+}
+
+func (c *TxConnection) savepointIndex(name string) int {
+	for idx, sp := range c.savepoints {
+		if sp.name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// execForAllTx executes query to transactions for all databases by order of beginning.
+func (c *TxConnection) execForAllTx(ctx context.Context, query string) error {
+	debug.Printf("%s", query)
+	errs := []string{}
+	for _, dsn := range c.dsnList {
+		if _, err := c.dsnToTx[dsn].ExecContext(contextOrBackground(ctx), query); err != nil {
+			errs = append(errs, errors.Wrapf(err, "cannot exec %s to %s", query, dsn).Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ":"))
+	}
+	return nil
+}
+
+// replaySavepoints sets current savepoints to transaction for database that joins later.
+// Nothing is executed by the transaction yet, so `ROLLBACK TO SAVEPOINT` for it rollbacks all queries executed after joining.
+func (c *TxConnection) replaySavepoints(ctx context.Context, tx shardTx) error {
+	for _, sp := range c.savepoints {
+		query := fmt.Sprintf("SAVEPOINT %s", sp.name)
+		if _, err := tx.ExecContext(contextOrBackground(ctx), query); err != nil {
+			return errors.Wrapf(err, "cannot exec %s", query)
+		}
+	}
+	return nil
+}
+
+// Savepoint executes `SAVEPOINT` for all databases in transaction.
+// Savepoint is also set to databases that join the transaction later.
+// If the same name of savepoint already exists, it is replaced by new one.
+func (c *TxConnection) Savepoint(ctx context.Context, name string) error {
+	if c.unusableErr != nil {
+		return errors.WithStack(c.unusableErr)
+	}
+	if err := validateSavepointName(name); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := c.execForAllTx(ctx, fmt.Sprintf("SAVEPOINT %s", name)); err != nil {
+		return errors.WithStack(err)
+	}
+	if idx := c.savepointIndex(name); idx >= 0 {
+		c.savepoints = append(c.savepoints[:idx], c.savepoints[idx+1:]...)
+	}
+	txWriteQueryNums := map[shardTx]int{}
+	for tx, queries := range c.txToWriteQueries {
+		txWriteQueryNums[tx] = len(queries)
+	}
+	c.savepoints = append(c.savepoints, &savepoint{
+		name:             name,
+		writeQueryNum:    len(c.WriteQueries),
+		txWriteQueryNums: txWriteQueryNums,
+	})
+	return nil
+}
+
+// RollbackToSavepoint executes `ROLLBACK TO SAVEPOINT` for all databases in transaction.
+// Write queries executed after the savepoint are removed from WriteQueries, and savepoints set after it are released.
+// If it fails for some databases, the transaction becomes unusable because databases are rollbacked partially, so only Rollback is allowed.
+func (c *TxConnection) RollbackToSavepoint(ctx context.Context, name string) error {
+	if c.unusableErr != nil {
+		return errors.WithStack(c.unusableErr)
+	}
+	idx := c.savepointIndex(name)
+	if idx < 0 {
+		return errors.Errorf("cannot find savepoint %s", name)
+	}
+	if err := c.execForAllTx(ctx, fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", name)); err != nil {
+		c.unusableErr = errors.Errorf("transaction is unusable by failure of ROLLBACK TO SAVEPOINT %s. it must be rollbacked", name)
+		return errors.WithStack(err)
+	}
+	sp := c.savepoints[idx]
+	c.WriteQueries = c.WriteQueries[:sp.writeQueryNum]
+	for tx, queries := range c.txToWriteQueries {
+		// transaction that joined after the savepoint has no write queries at that time
+		c.txToWriteQueries[tx] = queries[:sp.txWriteQueryNums[tx]]
+	}
+	c.savepoints = c.savepoints[:idx+1]
+	return nil
+}
+
+// ReleaseSavepoint executes `RELEASE SAVEPOINT` for all databases in transaction.
+// Savepoints set after it are also released.
+func (c *TxConnection) ReleaseSavepoint(ctx context.Context, name string) error {
+	if c.unusableErr != nil {
+		return errors.WithStack(c.unusableErr)
+	}
+	idx := c.savepointIndex(name)
+	if idx < 0 {
+		return errors.Errorf("cannot find savepoint %s", name)
+	}
+	if err := c.execForAllTx(ctx, fmt.Sprintf("RELEASE SAVEPOINT %s", name)); err != nil {
+		return errors.WithStack(err)
+	}
+	c.savepoints = c.savepoints[:idx]
+	return nil
+}
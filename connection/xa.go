@@ -0,0 +1,231 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/debug"
+)
+
+type xaState int
+
+const (
+	xaActive xaState = iota
+	xaIdle
+	xaPrepared
+	xaFinished
+)
+
+// xaTx is a branch of XA transaction on dedicated connection to a shard.
+// It implements shardTx interface like *sql.Tx.
+type xaTx struct {
+	conn   *sql.Conn
+	branch int
+	xid    string
+	state  xaState
+}
+
+// formatXID returns xid of XA transaction branch for `XA` statements.
+func formatXID(gtrid string, branch int) string {
+	return fmt.Sprintf("'%s','%d'", gtrid, branch)
+}
+
+func beginXA(ctx context.Context, db *sql.DB, gtrid string, branch int) (*xaTx, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tx := &xaTx{
+		conn:   conn,
+		branch: branch,
+		xid:    formatXID(gtrid, branch),
+	}
+	if err := tx.execXA(ctx, "XA START %s"); err != nil {
+		conn.Close()
+		return nil, errors.WithStack(err)
+	}
+	return tx, nil
+}
+
+func (tx *xaTx) execXA(ctx context.Context, format string) error {
+	query := fmt.Sprintf(format, tx.xid)
+	debug.Printf("%s", query)
+	if _, err := tx.conn.ExecContext(ctx, query); err != nil {
+		return errors.Wrapf(err, "cannot exec %s", query)
+	}
+	return nil
+}
+
+func (tx *xaTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.conn.ExecContext(ctx, query, args...)
+}
+
+func (tx *xaTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.conn.QueryContext(ctx, query, args...)
+}
+
+func (tx *xaTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.conn.QueryRowContext(ctx, query, args...)
+}
+
+func (tx *xaTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return tx.conn.PrepareContext(ctx, query)
+}
+
+// prepare executes `XA END` and `XA PREPARE` ( first phase of two-phase commit ).
+func (tx *xaTx) prepare() error {
+	ctx := context.Background()
+	if tx.state == xaActive {
+		if err := tx.execXA(ctx, "XA END %s"); err != nil {
+			return errors.WithStack(err)
+		}
+		tx.state = xaIdle
+	}
+	if tx.state != xaIdle {
+		return errors.Errorf("cannot prepare XA transaction %s", tx.xid)
+	}
+	if err := tx.execXA(ctx, "XA PREPARE %s"); err != nil {
+		return errors.WithStack(err)
+	}
+	tx.state = xaPrepared
+	return nil
+}
+
+// Commit executes `XA COMMIT` for prepared transaction.
+// If transaction is not prepared yet, commit it by one-phase commit.
+// Connection is always released, so prepared transaction that failed to commit remains on the server as in-doubt transaction.
+func (tx *xaTx) Commit() (e error) {
+	if tx.state == xaFinished {
+		return sql.ErrTxDone
+	}
+	defer func() {
+		if err := tx.finish(); err != nil && e == nil {
+			e = errors.WithStack(err)
+		}
+	}()
+	ctx := context.Background()
+	if tx.state == xaPrepared {
+		return errors.WithStack(tx.execXA(ctx, "XA COMMIT %s"))
+	}
+	if tx.state == xaActive {
+		if err := tx.execXA(ctx, "XA END %s"); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(tx.execXA(ctx, "XA COMMIT %s ONE PHASE"))
+}
+
+// Rollback executes `XA ROLLBACK`.
+func (tx *xaTx) Rollback() (e error) {
+	if tx.state == xaFinished {
+		return sql.ErrTxDone
+	}
+	defer func() {
+		if err := tx.finish(); err != nil && e == nil {
+			e = errors.WithStack(err)
+		}
+	}()
+	ctx := context.Background()
+	if tx.state == xaActive {
+		if err := tx.execXA(ctx, "XA END %s"); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(tx.execXA(ctx, "XA ROLLBACK %s"))
+}
+
+// finish releases dedicated connection.
+// MySQL rollbacks XA transaction that is not prepared yet when connection is closed.
+func (tx *xaTx) finish() error {
+	tx.state = xaFinished
+	return tx.conn.Close()
+}
+
+// preparedXIDs returns data ( gtrid followed by bqual ) of XA transactions prepared on the server by `XA RECOVER`.
+func preparedXIDs(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query("XA RECOVER")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot exec XA RECOVER")
+	}
+	defer rows.Close()
+	xids := map[string]bool{}
+	for rows.Next() {
+		var (
+			formatID    int64
+			gtridLength int64
+			bqualLength int64
+			data        []byte
+		)
+		if err := rows.Scan(&formatID, &gtridLength, &bqualLength, &data); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		xids[string(data)] = true
+	}
+	return xids, errors.WithStack(rows.Err())
+}
+
+// RecoverXATransaction finishes XA transactions remaining as prepared on the servers for in-doubt transaction recorded in log.
+//
+// If commit is decided ( commit decision is recorded or some of them are committed ), prepared XA transactions are committed by `XA COMMIT`.
+// Otherwise, they are rollbacked by `XA ROLLBACK` even if all of them are prepared, because coordinator never commits before recording the decision.
+// Write queries recorded in log are never executed again.
+// connByDSN returns connection to the database by DSN recorded in log. It returns whether the transaction is committed.
+// Log in grace period is not recovered, because coordinator may be still preparing XA transactions.
+func RecoverXATransaction(store CoordinatorLogStore, log *TransactionLog, connByDSN func(dsn string) (*sql.DB, error)) (bool, error) {
+	if !log.XA {
+		return false, errors.Errorf("transaction %s is not XA transaction", log.ID)
+	}
+	if log.IsInGracePeriod() {
+		return false, errors.Errorf("transaction %s may be still committing. it is recovered after grace period", log.ID)
+	}
+	conns := map[string]*sql.DB{}
+	isPrepared := map[string]bool{}
+	isCommitDecided := log.CommitDecided
+	for _, shard := range log.Shards {
+		if shard.Committed {
+			isCommitDecided = true
+			continue
+		}
+		conn, err := connByDSN(shard.DSN)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		xids, err := preparedXIDs(conn)
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot get prepared XA transactions from %s", shard.DSN)
+		}
+		conns[shard.DSN] = conn
+		isPrepared[shard.DSN] = xids[fmt.Sprintf("%s%d", log.ID, shard.XABranch)]
+	}
+	if isCommitDecided && !log.CommitDecided {
+		// record decision before commit, so recovery never rollbacks the rest after some are committed
+		log.CommitDecided = true
+		if err := store.Write(log); err != nil {
+			return false, errors.WithStack(err)
+		}
+	}
+	for _, shard := range log.Shards {
+		if shard.Committed || !isPrepared[shard.DSN] {
+			// XA transaction that is not prepared is already committed or rollbacked by the server
+			continue
+		}
+		format := "XA ROLLBACK %s"
+		if isCommitDecided {
+			format = "XA COMMIT %s"
+		}
+		query := fmt.Sprintf(format, formatXID(log.ID, shard.XABranch))
+		debug.Printf("%s", query)
+		if _, err := conns[shard.DSN].Exec(query); err != nil {
+			return false, errors.Wrapf(err, "cannot exec %s to %s", query, shard.DSN)
+		}
+		if isCommitDecided {
+			shard.Committed = true
+			if err := store.Write(log); err != nil {
+				return false, errors.WithStack(err)
+			}
+		}
+	}
+	return isCommitDecided, errors.WithStack(store.Delete(log.ID))
+}
@@ -96,7 +96,7 @@ type RawBytes []byte
 var ErrTxDone = errors.New("sql: Transaction has already been committed or rolled back")
 
 // ErrNoRows the compatible value of ErrNoRows in 'database/sql' package.
-var ErrNoRows = errors.New("sql: no rows in result set")
+var ErrNoRows = core.ErrNoRows
 
 type driverProxy struct {
 	driver driver.Driver
@@ -535,7 +535,14 @@ func (r *Row) Scan(dest ...interface{}) error {
 	if r.core == nil {
 		return errors.New("sql.Row pointer is nil")
 	}
-	return errors.WithStack(r.core.Scan(dest...))
+	if err := r.core.Scan(dest...); err != nil {
+		if err == ErrNoRows {
+			// returns as it is to be able to compare with ErrNoRows
+			return err
+		}
+		return errors.WithStack(err)
+	}
+	return nil
 }
 
 // IsolationLevel the compatible type of IsolationLevel in 'database/sql' package.
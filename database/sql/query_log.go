@@ -81,7 +81,7 @@ func (t *Tx) IsAlreadyCommittedQueryLog(log *QueryLog) (bool, error) {
 	if err != nil {
 		return false, errors.WithStack(err)
 	}
-	t.begin(conn)
+	t.begin()
 	if conn.IsShard {
 		row, err := exec.NewQueryExecutor(nil, conn, t.tx, countQuery).QueryRow()
 		if err != nil {
@@ -125,7 +125,7 @@ func (t *Tx) ExecWithQueryLog(log *QueryLog) (Result, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	t.begin(conn)
+	t.begin()
 	if conn.IsShard {
 		result, err := exec.NewQueryExecutor(t.ctx, conn, t.tx, query).Exec()
 		if err != nil {
@@ -0,0 +1,151 @@
+package sql
+
+import (
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/connection"
+	"github.com/aokabi/octillery/debug"
+)
+
+// RecoveryResult is a result of recovery for in-doubt transaction remaining in coordinator log.
+type RecoveryResult struct {
+	// id of transaction
+	ID string `json:"id"`
+
+	// write queries executed again to roll forward the transaction
+	RolledForwardQueries []*QueryLog `json:"rolledForwardQueries"`
+
+	// true if the transaction was not committed to any databases and discarded without executing queries
+	Discarded bool `json:"discarded"`
+
+	// true if the transaction is XA transaction finished by `XA COMMIT` or `XA ROLLBACK` for prepared transactions
+	XA bool `json:"xa"`
+}
+
+// Recover finishes in-doubt transactions remaining in coordinator log.
+//
+// For each transaction, it checks whether write queries are committed to each database by IsAlreadyCommittedQueryLog.
+// If some databases are committed, it rolls forward the transaction by executing write queries to the rest databases.
+// If no databases are committed, it discards the transaction.
+// XA transaction is finished by `XA COMMIT` or `XA ROLLBACK` for prepared transactions on the servers instead of executing write queries again.
+// Transaction whose log is written in grace period of coordinator log is skipped, because it may be still committing by the other process.
+func (db *DB) Recover() ([]*RecoveryResult, error) {
+	store := connection.CoordinatorLog()
+	if store == nil {
+		return nil, errors.New("cannot recover transactions. coordinator log is not configured")
+	}
+	logs, err := store.Logs()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	results := []*RecoveryResult{}
+	for _, log := range logs {
+		if log.IsInGracePeriod() {
+			debug.Printf("skip transaction %s in grace period", log.ID)
+			continue
+		}
+		result, err := db.recoverTransaction(store, log)
+		if err != nil {
+			return results, errors.Wrapf(err, "cannot recover transaction %s", log.ID)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (db *DB) recoverTransaction(store connection.CoordinatorLogStore, log *connection.TransactionLog) (*RecoveryResult, error) {
+	if log.XA {
+		return db.recoverXATransaction(store, log)
+	}
+	committedShardNum := 0
+	uncommittedShards := []*connection.ShardTransactionLog{}
+	for _, shard := range log.Shards {
+		if !shard.Committed {
+			committed, err := db.isCommittedShardTransaction(shard)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			shard.Committed = committed
+		}
+		if shard.Committed {
+			committedShardNum++
+		} else {
+			uncommittedShards = append(uncommittedShards, shard)
+		}
+	}
+	result := &RecoveryResult{ID: log.ID, RolledForwardQueries: []*QueryLog{}}
+	if committedShardNum == 0 {
+		debug.Printf("discard transaction %s", log.ID)
+		result.Discarded = true
+		return result, errors.WithStack(store.Delete(log.ID))
+	}
+	for _, shard := range uncommittedShards {
+		debug.Printf("roll forward transaction %s for %s", log.ID, shard.DSN)
+		queries, err := db.execQueryLogs(shard.WriteQueries)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot roll forward to %s", shard.DSN)
+		}
+		shard.Committed = true
+		if err := store.Write(log); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		result.RolledForwardQueries = append(result.RolledForwardQueries, queries...)
+	}
+	return result, errors.WithStack(store.Delete(log.ID))
+}
+
+func (db *DB) recoverXATransaction(store connection.CoordinatorLogStore, log *connection.TransactionLog) (*RecoveryResult, error) {
+	debug.Printf("recover XA transaction %s", log.ID)
+	committed, err := connection.RecoverXATransaction(store, log, db.connMgr.ConnectionByDSN)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &RecoveryResult{
+		ID:                   log.ID,
+		RolledForwardQueries: []*QueryLog{},
+		Discarded:            !committed,
+		XA:                   true,
+	}, nil
+}
+
+// isCommittedShardTransaction returns whether all write queries for the database are committed.
+// Transaction for single database is committed atomically, so it returns error if only some of queries seem to be committed.
+func (db *DB) isCommittedShardTransaction(shard *connection.ShardTransactionLog) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer tx.Rollback()
+	committedQueryNum := 0
+	for _, query := range tx.convertQueryLogs(shard.WriteQueries) {
+		committed, err := tx.IsAlreadyCommittedQueryLog(query)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		if committed {
+			committedQueryNum++
+		}
+	}
+	if committedQueryNum > 0 && committedQueryNum < len(shard.WriteQueries) {
+		return false, errors.Errorf("cannot decide whether transaction for %s is committed. it must be recovered manually", shard.DSN)
+	}
+	return committedQueryNum > 0, nil
+}
+
+// execQueryLogs executes write queries by single transaction.
+func (db *DB) execQueryLogs(logs []*connection.QueryLog) ([]*QueryLog, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	queries := tx.convertQueryLogs(logs)
+	for _, query := range queries {
+		if _, err := tx.ExecWithQueryLog(query); err != nil {
+			tx.Rollback()
+			return nil, errors.WithStack(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return queries, nil
+}
@@ -0,0 +1,118 @@
+package sql
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/connection/adapter"
+	"github.com/aokabi/octillery/debug"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = 10 * time.Millisecond
+	defaultMaxBackoff     = time.Second
+)
+
+// RunInTxOptions is options for RunInTx.
+type RunInTxOptions struct {
+	// options to begin transaction
+	TxOptions *TxOptions
+
+	// max number of retries. default is 3. if negative, transaction is not retried
+	MaxRetries int
+
+	// wait time before first retry. it is doubled for each retry. default is 10ms
+	InitialBackoff time.Duration
+
+	// max wait time before retry. default is 1s
+	MaxBackoff time.Duration
+}
+
+func (opts *RunInTxOptions) maxRetries() int {
+	if opts == nil || opts.MaxRetries == 0 {
+		return defaultMaxRetries
+	}
+	if opts.MaxRetries < 0 {
+		return 0
+	}
+	return opts.MaxRetries
+}
+
+func (opts *RunInTxOptions) txOptions() *TxOptions {
+	if opts == nil {
+		return nil
+	}
+	return opts.TxOptions
+}
+
+// backoff returns wait time before retry with jitter.
+func (opts *RunInTxOptions) backoff(retry int) time.Duration {
+	initialBackoff := defaultInitialBackoff
+	maxBackoff := defaultMaxBackoff
+	if opts != nil && opts.InitialBackoff > 0 {
+		initialBackoff = opts.InitialBackoff
+	}
+	if opts != nil && opts.MaxBackoff > 0 {
+		maxBackoff = opts.MaxBackoff
+	}
+	backoff := initialBackoff
+	for i := 0; i < retry && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// RunInTx executes fn in transaction and commits it.
+// If fn or commit fails, transactions for all databases are rollbacked.
+// If the error is classified as retryable ( e.g. deadlock ) by adapter of any database, fn is executed again by new transaction with backoff.
+// Transaction that failed to commit after some databases are committed is never retried.
+func RunInTx(ctx context.Context, db *DB, opts *RunInTxOptions, fn func(*Tx) error) error {
+	maxRetries := opts.maxRetries()
+	for retry := 0; ; retry++ {
+		err := runInTx(ctx, db, opts.txOptions(), fn)
+		if err == nil {
+			return nil
+		}
+		if retry >= maxRetries || !adapter.IsRetryableError(err) {
+			return err
+		}
+		backoff := opts.backoff(retry)
+		debug.Printf("retry transaction after %s by %s", backoff, err)
+		if ctx == nil {
+			time.Sleep(backoff)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), err.Error())
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func runInTx(ctx context.Context, db *DB, opts *TxOptions, fn func(*Tx) error) error {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return errors.Wrap(err, rollbackErr.Error())
+		}
+		return errors.WithStack(err)
+	}
+	if err := tx.Commit(); err != nil {
+		// transactions for the rest databases remain if commit failed for first database
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			debug.Printf("failed to rollback after commit error: %s", rollbackErr)
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
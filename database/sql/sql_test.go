@@ -52,6 +52,12 @@ func (t *TestAdapter) InsertRowToSequencerIfNotExists(conn *core.DB, tableName s
 	return t.insertRowToSequencerIfNotExistsErr
 }
 
+var errRetryable = errors.New("retryable error")
+
+func (t *TestAdapter) IsRetryableError(err error) bool {
+	return err == errRetryable
+}
+
 type TestDriver struct {
 	openErr error
 }
@@ -977,3 +983,61 @@ func TestError(t *testing.T) {
 	testQueryRowContextTransactionError(t, tx)
 	checkErr(t, tx.Commit())
 }
+
+func TestRunInTx(t *testing.T) {
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	opts := &RunInTxOptions{InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	t.Run("retry by retryable error", func(t *testing.T) {
+		callNum := 0
+		checkErr(t, RunInTx(context.Background(), db, opts, func(tx *Tx) error {
+			callNum++
+			if callNum < 3 {
+				return errors.WithStack(errRetryable)
+			}
+			return nil
+		}))
+		if callNum != 3 {
+			t.Fatalf("invalid call number %d", callNum)
+		}
+	})
+	t.Run("not retry by other error", func(t *testing.T) {
+		callNum := 0
+		if err := RunInTx(nil, db, opts, func(tx *Tx) error {
+			callNum++
+			return errors.New("error")
+		}); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if callNum != 1 {
+			t.Fatalf("invalid call number %d", callNum)
+		}
+	})
+	t.Run("exceed max retries", func(t *testing.T) {
+		callNum := 0
+		if err := RunInTx(nil, db, &RunInTxOptions{MaxRetries: 2, InitialBackoff: time.Millisecond}, func(tx *Tx) error {
+			callNum++
+			return errRetryable
+		}); errors.Cause(err) != errRetryable {
+			t.Fatalf("invalid error %+v", err)
+		}
+		if callNum != 3 {
+			t.Fatalf("invalid call number %d", callNum)
+		}
+	})
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		callNum := 0
+		if err := RunInTx(ctx, db, opts, func(tx *Tx) error {
+			callNum++
+			cancel()
+			return errRetryable
+		}); errors.Cause(err) != context.Canceled {
+			t.Fatalf("invalid error %+v", err)
+		}
+		if callNum != 1 {
+			t.Fatalf("invalid call number %d", callNum)
+		}
+	})
+}
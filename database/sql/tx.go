@@ -117,11 +117,11 @@ func (proxy *Tx) convertQueryLogs(connQueries []*connection.QueryLog) []*QueryLo
 	return queries
 }
 
-func (proxy *Tx) begin(conn *connection.DBConnection) {
+func (proxy *Tx) begin() {
 	if proxy.tx != nil {
 		return
 	}
-	tx := conn.Begin(proxy.ctx, proxy.opts)
+	tx := connection.NewTxConnection(proxy.ctx, proxy.opts)
 	if proxy.beforeCommitCallback == nil {
 		proxy.BeforeCommitCallback(func(writeQueries []*QueryLog) error {
 			return errors.WithStack(globalBeforeCommitCallback(proxy, writeQueries))
@@ -142,7 +142,7 @@ func (proxy *Tx) execProxy(ctx context.Context, queryText string, args ...interf
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	proxy.begin(conn)
+	proxy.begin()
 	if conn.IsShard {
 		result, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).Exec()
 		if err != nil {
@@ -162,7 +162,7 @@ func (proxy *Tx) prepareProxy(ctx context.Context, queryText string) (*core.Stmt
 	if err != nil {
 		return nil, nil, errors.WithStack(err)
 	}
-	proxy.begin(conn)
+	proxy.begin()
 	if conn.IsShard {
 		stmt, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).Prepare()
 		if err != nil {
@@ -185,7 +185,7 @@ func (proxy *Tx) stmtProxy(ctx context.Context, stmt *Stmt) (*core.Stmt, connect
 	if err != nil {
 		return nil, nil, errors.WithStack(err)
 	}
-	proxy.begin(conn)
+	proxy.begin()
 	if conn.IsShard {
 		stmt, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).Stmt()
 		if err != nil {
@@ -205,7 +205,7 @@ func (proxy *Tx) queryProxy(ctx context.Context, queryText string, args ...inter
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	proxy.begin(conn)
+	proxy.begin()
 	if conn.IsShard {
 		rows, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).Query()
 		if err != nil {
@@ -226,7 +226,7 @@ func (proxy *Tx) queryRowProxy(ctx context.Context, queryText string, args ...in
 	if err != nil {
 		return &Row{err: err}
 	}
-	proxy.begin(conn)
+	proxy.begin()
 	if conn.IsShard {
 		row, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).QueryRow()
 		if err != nil {
@@ -273,6 +273,56 @@ func (proxy *Tx) Rollback() error {
 	return nil
 }
 
+// SavepointContext executes `SAVEPOINT` for all databases in transaction.
+// Savepoint is also set to databases accessed after it, so it can be used across shards.
+func (proxy *Tx) SavepointContext(ctx context.Context, name string) error {
+	debug.Printf("Tx.SavepointContext: %s", name)
+	proxy.begin()
+	if err := proxy.tx.Savepoint(ctx, name); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Savepoint executes `SAVEPOINT` for all databases in transaction.
+func (proxy *Tx) Savepoint(name string) error {
+	debug.Printf("Tx.Savepoint: %s", name)
+	return errors.WithStack(proxy.SavepointContext(nil, name))
+}
+
+// RollbackToSavepointContext executes `ROLLBACK TO SAVEPOINT` for all databases in transaction.
+// Write queries executed after the savepoint are not committed and not passed to commit callbacks.
+func (proxy *Tx) RollbackToSavepointContext(ctx context.Context, name string) error {
+	debug.Printf("Tx.RollbackToSavepointContext: %s", name)
+	proxy.begin()
+	if err := proxy.tx.RollbackToSavepoint(ctx, name); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// RollbackToSavepoint executes `ROLLBACK TO SAVEPOINT` for all databases in transaction.
+func (proxy *Tx) RollbackToSavepoint(name string) error {
+	debug.Printf("Tx.RollbackToSavepoint: %s", name)
+	return errors.WithStack(proxy.RollbackToSavepointContext(nil, name))
+}
+
+// ReleaseSavepointContext executes `RELEASE SAVEPOINT` for all databases in transaction.
+func (proxy *Tx) ReleaseSavepointContext(ctx context.Context, name string) error {
+	debug.Printf("Tx.ReleaseSavepointContext: %s", name)
+	proxy.begin()
+	if err := proxy.tx.ReleaseSavepoint(ctx, name); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// ReleaseSavepoint executes `RELEASE SAVEPOINT` for all databases in transaction.
+func (proxy *Tx) ReleaseSavepoint(name string) error {
+	debug.Printf("Tx.ReleaseSavepoint: %s", name)
+	return errors.WithStack(proxy.ReleaseSavepointContext(nil, name))
+}
+
 // PrepareContext the compatible method of PrepareContext in 'database/sql' package.
 func (proxy *Tx) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
 	debug.Printf("Tx.PrepareContext: %s", query)
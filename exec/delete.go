@@ -2,7 +2,6 @@ package exec
 
 import (
 	"database/sql"
-	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/aokabi/octillery/debug"
@@ -31,38 +30,34 @@ func (e *DeleteQueryExecutor) QueryRow() (*sql.Row, error) {
 
 func (e *DeleteQueryExecutor) deleteShardTable(query *sqlparser.DeleteQuery) (sql.Result, error) {
 	debug.Printf("delete shard table")
+	return e.execForAllShardWithResult(query.Text, query.Args...)
+}
 
-	var totalAffectedRows int64
-	errs := []string{}
-	for _, shardConn := range e.conn.ShardConnections.AllShard() {
-		debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
-		result, err := e.exec(shardConn, query.Text, query.Args...)
-		if err != nil {
-			errs = append(errs, err.Error())
-			continue
-		}
-		affectedRows, err := result.(sql.Result).RowsAffected()
-		if err != nil {
-			errs = append(errs, err.Error())
-		}
-		totalAffectedRows = totalAffectedRows + affectedRows
+func (e *DeleteQueryExecutor) deleteForAllShard(query *sqlparser.DeleteQuery) (sql.Result, error) {
+	if !e.conn.Config.AllowScatterDelete {
+		return nil, errors.New("cannot delete for all shards. if you want to do it, set allow_scatter_delete to true")
 	}
-
-	if len(errs) > 0 {
-		return nil, errors.New(strings.Join(errs, ":"))
+	if query.Stmt.OrderBy != nil || query.Stmt.Limit != nil {
+		return nil, errors.New("cannot delete for all shards with ORDER BY or LIMIT")
 	}
-
-	debug.Printf("totalAffectedRows = %d", totalAffectedRows)
-	return &mergedResult{affectedRows: totalAffectedRows, err: nil}, nil
+	debug.Printf("[WARN] delete query for all shards. too slow")
+	shardQueries, err := e.scatterShardQueries(query.QueryBase, query.Text, query.Args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return e.execShardQueriesWithResult(shardQueries)
 }
 
-func (e *DeleteQueryExecutor) deleteForAllShard(query *sqlparser.DeleteQuery) (sql.Result, error) {
-	debug.Printf("[WARN] delete query for all shards. too slow")
-	// 1. select for all shards to get delete targets
-	// 2. exec delete query to every shard
-	// 3. if succeeded delete query, merge selected rows from every shard
-	// 4. exec delete query for sequencer table
-	return nil, errors.New("still not support to delete for all shards")
+// deleteForShardKeys executes DELETE query for shards that have ShardKeyIDs.
+func (e *DeleteQueryExecutor) deleteForShardKeys(query *sqlparser.DeleteQuery) (sql.Result, error) {
+	shardQueries, err := e.shardQueriesByKeys(query.QueryBase, query.Stmt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(shardQueries) > 1 && (query.Stmt.OrderBy != nil || query.Stmt.Limit != nil) {
+		return nil, errors.New("cannot delete for multiple shards with ORDER BY or LIMIT")
+	}
+	return e.execShardQueriesWithResult(shardQueries)
 }
 
 // Exec executes DELETE query for shards.
@@ -80,9 +75,11 @@ func (e *DeleteQueryExecutor) Exec() (sql.Result, error) {
 		return e.deleteShardTable(query)
 	} else if query.IsAllShardQuery {
 		return e.deleteForAllShard(query)
+	} else if query.IsMultipleShardKeyIDs() {
+		return e.deleteForShardKeys(query)
 	}
 
-	shardConn, err := e.conn.ShardConnectionByID(int64(query.ShardKeyID))
+	shardConn, err := e.conn.ShardConnectionByKey(query.ShardKeyID)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -1,10 +1,11 @@
 package exec
 
 import (
+	"context"
 	"database/sql"
-	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/connection"
 	"github.com/aokabi/octillery/debug"
 	"github.com/aokabi/octillery/sqlparser"
 )
@@ -36,24 +37,28 @@ func (e *DropQueryExecutor) Exec() (sql.Result, error) {
 	if !ok {
 		return nil, errors.New("cannot convert sqlparser.Query to *sqlparser.QueryBase")
 	}
-	var totalAffectedRows int64
-	errs := []string{}
-	for _, shardConn := range e.conn.ShardConnections.AllShard() {
-		result, err := shardConn.Connection.Exec(query.Text, query.Args...)
+	ctx, cancel := context.WithCancel(e.context())
+	defer cancel()
+	affectedRows := make([]int64, e.conn.ShardConnections.ShardNum())
+	if err := e.execForAllShard(ctx, cancel, func(ctx context.Context, idx int, shardConn *connection.DBShardConnection) error {
+		result, err := shardConn.Connection.ExecContext(ctx, query.Text, query.Args...)
 		if err != nil {
-			errs = append(errs, err.Error())
-			continue
+			return errors.WithStack(err)
 		}
 		if result != nil {
-			affectedRows, err := result.(sql.Result).RowsAffected()
+			rowsAffected, err := result.(sql.Result).RowsAffected()
 			if err != nil {
-				errs = append(errs, err.Error())
+				return errors.WithStack(err)
 			}
-			totalAffectedRows = totalAffectedRows + affectedRows
+			affectedRows[idx] = rowsAffected
 		}
+		return nil
+	}); err != nil {
+		return nil, errors.WithStack(err)
 	}
-	if len(errs) > 0 {
-		return nil, errors.New(strings.Join(errs, ":"))
+	var totalAffectedRows int64
+	for _, rowsAffected := range affectedRows {
+		totalAffectedRows = totalAffectedRows + rowsAffected
 	}
 	debug.Printf("totalAffectedRows = %d", totalAffectedRows)
 	return &mergedResult{affectedRows: totalAffectedRows}, nil
@@ -53,29 +53,37 @@ func (e *QueryExecutorBase) Stmt() (*sql.Stmt, error) {
 }
 
 func (e *QueryExecutorBase) exec(conn connection.Connection, query string, args ...interface{}) (sql.Result, error) {
+	return e.execContext(e.ctx, conn, query, args...)
+}
+
+func (e *QueryExecutorBase) execContext(ctx context.Context, conn connection.Connection, query string, args ...interface{}) (sql.Result, error) {
 	if e.tx != nil {
-		result, err := e.tx.Exec(e.ctx, conn, query, args...)
+		result, err := e.tx.Exec(ctx, conn, query, args...)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 		return result, nil
 	}
 
-	if e.ctx == nil {
+	if ctx == nil {
 		return conn.Conn().Exec(query, args...)
 	}
-	return conn.Conn().ExecContext(e.ctx, query, args...)
+	return conn.Conn().ExecContext(ctx, query, args...)
 }
 
 func (e *QueryExecutorBase) execQuery(conn connection.Connection, query string, args ...interface{}) (*sql.Rows, error) {
+	return e.execQueryContext(e.ctx, conn, query, args...)
+}
+
+func (e *QueryExecutorBase) execQueryContext(ctx context.Context, conn connection.Connection, query string, args ...interface{}) (*sql.Rows, error) {
 	if e.tx != nil {
-		return e.tx.Query(e.ctx, conn, query, args...)
+		return e.tx.Query(ctx, conn, query, args...)
 	}
 
-	if e.ctx == nil {
+	if ctx == nil {
 		return conn.Conn().Query(query, args...)
 	}
-	return conn.Conn().QueryContext(e.ctx, query, args...)
+	return conn.Conn().QueryContext(ctx, query, args...)
 }
 
 func (e *QueryExecutorBase) execQueryRow(conn connection.Connection, query string, args ...interface{}) (*sql.Row, error) {
@@ -93,6 +101,13 @@ func (e *QueryExecutorBase) execQueryRow(conn connection.Connection, query strin
 	return conn.Conn().QueryRowContext(e.ctx, query, args...), nil
 }
 
+func (e *QueryExecutorBase) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
 // NewQueryExecutor creates instance of QueryExecutor interface.
 // If specify unknown query type, returns nil
 func NewQueryExecutor(ctx context.Context, conn *connection.DBConnection, tx *connection.TxConnection, query sqlparser.Query) QueryExecutor {
@@ -0,0 +1,213 @@
+package exec
+
+import (
+	"database/sql"
+	"reflect"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/sqlparser"
+)
+
+// condition evaluates HAVING clause for merged rows.
+// HAVING clause cannot be pushed down to each shard because it is applied to partial aggregate values.
+type condition interface {
+	resolve(columns []string, columnTypes []*sql.ColumnType, hiddenColumnNum int) error
+	evaluate(values []interface{}) (bool, error)
+}
+
+type andCondition struct {
+	left, right condition
+}
+
+func (c *andCondition) resolve(columns []string, columnTypes []*sql.ColumnType, hiddenColumnNum int) error {
+	if err := c.left.resolve(columns, columnTypes, hiddenColumnNum); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(c.right.resolve(columns, columnTypes, hiddenColumnNum))
+}
+
+func (c *andCondition) evaluate(values []interface{}) (bool, error) {
+	left, err := c.left.evaluate(values)
+	if err != nil || !left {
+		return false, errors.WithStack(err)
+	}
+	return c.right.evaluate(values)
+}
+
+type orCondition struct {
+	left, right condition
+}
+
+func (c *orCondition) resolve(columns []string, columnTypes []*sql.ColumnType, hiddenColumnNum int) error {
+	if err := c.left.resolve(columns, columnTypes, hiddenColumnNum); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(c.right.resolve(columns, columnTypes, hiddenColumnNum))
+}
+
+func (c *orCondition) evaluate(values []interface{}) (bool, error) {
+	left, err := c.left.evaluate(values)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if left {
+		return true, nil
+	}
+	return c.right.evaluate(values)
+}
+
+type notCondition struct {
+	cond condition
+}
+
+func (c *notCondition) resolve(columns []string, columnTypes []*sql.ColumnType, hiddenColumnNum int) error {
+	return errors.WithStack(c.cond.resolve(columns, columnTypes, hiddenColumnNum))
+}
+
+func (c *notCondition) evaluate(values []interface{}) (bool, error) {
+	result, err := c.cond.evaluate(values)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return !result, nil
+}
+
+type comparisonCondition struct {
+	operator    string
+	left, right *operand
+}
+
+func (c *comparisonCondition) resolve(columns []string, columnTypes []*sql.ColumnType, hiddenColumnNum int) error {
+	if err := c.left.resolve(columns, columnTypes, hiddenColumnNum); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(c.right.resolve(columns, columnTypes, hiddenColumnNum))
+}
+
+func (c *comparisonCondition) evaluate(values []interface{}) (bool, error) {
+	left := c.left.value(values)
+	right := c.right.value(values)
+	// like MySQL, text is compared with number as number
+	isNumeric := c.left.isNumeric || c.right.isNumeric
+	if c.operator == vtparser.NullSafeEqualStr {
+		return compareValues(left, right, isNumeric) == 0, nil
+	}
+	if left == nil || right == nil {
+		return false, nil
+	}
+	result := compareValues(left, right, isNumeric)
+	switch c.operator {
+	case vtparser.EqualStr:
+		return result == 0, nil
+	case vtparser.NotEqualStr:
+		return result != 0, nil
+	case vtparser.LessThanStr:
+		return result < 0, nil
+	case vtparser.LessEqualStr:
+		return result <= 0, nil
+	case vtparser.GreaterThanStr:
+		return result > 0, nil
+	case vtparser.GreaterEqualStr:
+		return result >= 0, nil
+	}
+	return false, errors.Errorf("unsupported operator '%s' in having clause", c.operator)
+}
+
+// operand is the column of merged rows or constant value.
+type operand struct {
+	column    *columnRef
+	index     int
+	val       interface{}
+	isNumeric bool
+}
+
+func (o *operand) resolve(columns []string, columnTypes []*sql.ColumnType, hiddenColumnNum int) error {
+	if o.column == nil {
+		return nil
+	}
+	index, err := o.column.resolve(columns, hiddenColumnNum)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	o.index = index
+	o.isNumeric = isNumericColumnType(columnTypes[index])
+	return nil
+}
+
+func (o *operand) value(values []interface{}) interface{} {
+	if o.column == nil {
+		return o.val
+	}
+	return values[o.index]
+}
+
+func (p *mergePlan) conditionByExpr(query *sqlparser.QueryBase, stmt *vtparser.Select, shardStmt *vtparser.Select, expr vtparser.Expr) (condition, error) {
+	switch e := expr.(type) {
+	case *vtparser.AndExpr:
+		left, err := p.conditionByExpr(query, stmt, shardStmt, e.Left)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		right, err := p.conditionByExpr(query, stmt, shardStmt, e.Right)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &andCondition{left: left, right: right}, nil
+	case *vtparser.OrExpr:
+		left, err := p.conditionByExpr(query, stmt, shardStmt, e.Left)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		right, err := p.conditionByExpr(query, stmt, shardStmt, e.Right)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &orCondition{left: left, right: right}, nil
+	case *vtparser.NotExpr:
+		cond, err := p.conditionByExpr(query, stmt, shardStmt, e.Expr)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &notCondition{cond: cond}, nil
+	case *vtparser.ParenExpr:
+		return p.conditionByExpr(query, stmt, shardStmt, e.Expr)
+	case *vtparser.ComparisonExpr:
+		left, err := p.operandByExpr(query, stmt, shardStmt, e.Left)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		right, err := p.operandByExpr(query, stmt, shardStmt, e.Right)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &comparisonCondition{operator: e.Operator, left: left, right: right}, nil
+	}
+	return nil, errors.Errorf("unsupported expr type '%s' in having clause for all shards", reflect.TypeOf(expr))
+}
+
+func (p *mergePlan) operandByExpr(query *sqlparser.QueryBase, stmt *vtparser.Select, shardStmt *vtparser.Select, expr vtparser.Expr) (*operand, error) {
+	switch e := expr.(type) {
+	case *vtparser.NullVal:
+		return &operand{}, nil
+	case *vtparser.SQLVal:
+		switch e.Type {
+		case vtparser.ValArg:
+			arg, err := query.ArgByValArg(e)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return &operand{val: arg, isNumeric: !isTextValue(arg)}, nil
+		case vtparser.IntVal, vtparser.FloatVal:
+			return &operand{val: e.Val, isNumeric: true}, nil
+		case vtparser.StrVal:
+			return &operand{val: e.Val}, nil
+		}
+		return nil, errors.Errorf("unsupported value '%s' in having clause", string(e.Val))
+	}
+	column, err := p.columnRefByExpr(stmt, shardStmt, expr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &operand{column: column}, nil
+}
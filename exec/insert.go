@@ -4,6 +4,8 @@ import (
 	"database/sql"
 
 	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
+	"github.com/aokabi/octillery/connection"
 	"github.com/aokabi/octillery/debug"
 	"github.com/aokabi/octillery/sqlparser"
 )
@@ -40,6 +42,129 @@ func (e *InsertQueryExecutor) nextSequenceID(query *sqlparser.InsertQuery) (int6
 	return nextSequenceID, nil
 }
 
+// shardRowQueries has rows inserted to same shard.
+type shardRowQueries struct {
+	shardConn  *connection.DBShardConnection
+	rowQueries []*sqlparser.InsertQuery
+}
+
+// prepareRow publishes sequence id for row and decides shard to insert it.
+// If upsert query specifies shard_column value, it is used instead of publishing new id.
+func (e *InsertQueryExecutor) prepareRow(query *sqlparser.InsertQuery) (*connection.DBShardConnection, int64, error) {
+	var nextSequenceID int64
+	if shardColumnValue, isSpecified := query.ShardColumnValue(); isSpecified && e.conn.IsUsedSequencer {
+		id, ok := shardColumnValue.Int64()
+		if !ok {
+			return nil, 0, errors.Errorf("invalid %s value %s", e.conn.ShardColumnName, shardColumnValue)
+		}
+		nextSequenceID = id
+	} else {
+		id, err := e.nextSequenceID(query)
+		if err != nil {
+			return nil, 0, errors.WithStack(err)
+		}
+		nextSequenceID = id
+	}
+	query.SetNextSequenceID(nextSequenceID)
+	shardKeyID := query.ShardKeyID
+	if e.conn.IsEqualShardColumnToShardKeyColumn() {
+		shardKeyID = query.NextSequenceID()
+	}
+	if shardKeyID == sqlparser.UnknownID {
+		return nil, 0, errors.New("shard_key id is not found")
+	}
+	shardConn, err := e.conn.ShardConnectionByKey(shardKeyID)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	return shardConn, nextSequenceID, nil
+}
+
+// execMultiRows splits rows by shard and executes single INSERT query including multiple rows for each shard.
+// LastInsertId returns id of first row like MySQL.
+//
+// If executor doesn't have transaction and rows are inserted to multiple shards, they are executed by new transaction committed at the end,
+// so rows are not inserted partially when INSERT fails for some shards.
+// If distributed transaction is disabled, INSERT for each shard is committed one by one, so rows inserted to the other shards remain by error.
+func (e *InsertQueryExecutor) execMultiRows(query *sqlparser.InsertQuery) (_ sql.Result, insertErr error) {
+	allShardRows := []*shardRowQueries{}
+	shardNameToRows := map[string]*shardRowQueries{}
+	var firstSequenceID int64
+	for idx, rowQuery := range query.RowQueries {
+		shardConn, nextSequenceID, err := e.prepareRow(rowQuery)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if idx == 0 {
+			firstSequenceID = nextSequenceID
+		}
+		shardRows, exists := shardNameToRows[shardConn.ShardName]
+		if !exists {
+			shardRows = &shardRowQueries{shardConn: shardConn}
+			shardNameToRows[shardConn.ShardName] = shardRows
+			allShardRows = append(allShardRows, shardRows)
+		}
+		shardRows.rowQueries = append(shardRows.rowQueries, rowQuery)
+	}
+
+	tx := e.tx
+	if tx == nil && len(allShardRows) > 1 && e.isDistributedTransactionEnabled() {
+		tx = e.conn.Begin(e.ctx, nil)
+		defer func() {
+			if insertErr != nil {
+				if err := tx.Rollback(); err != nil {
+					insertErr = errors.Wrap(insertErr, err.Error())
+				}
+				return
+			}
+			insertErr = errors.WithStack(tx.Commit())
+		}()
+	}
+
+	var totalAffectedRows, lastInsertedID int64
+	for idx, shardRows := range allShardRows {
+		text, args, err := query.TextAndArgsWithRowQueries(shardRows.rowQueries)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		debug.Printf("(DB:%s):%s", shardRows.shardConn.ShardName, text)
+		var result sql.Result
+		if tx != nil {
+			result, err = tx.Exec(e.ctx, shardRows.shardConn, text, args...)
+		} else {
+			result, err = e.exec(shardRows.shardConn, text, args...)
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		totalAffectedRows += affectedRows
+		if idx == 0 && !e.conn.IsUsedSequencer {
+			// first shard always includes first row
+			lastInsertedID, err = result.LastInsertId()
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+	if e.conn.IsUsedSequencer {
+		lastInsertedID = firstSequenceID
+	}
+	debug.Printf("totalAffectedRows = %d", totalAffectedRows)
+	return &mergedResult{affectedRows: totalAffectedRows, lastInsertedID: lastInsertedID}, nil
+}
+
+func (e *InsertQueryExecutor) isDistributedTransactionEnabled() bool {
+	cfg, err := config.Get()
+	if err != nil {
+		return false
+	}
+	return cfg.DistributedTransaction
+}
+
 // Exec executes INSERT query for shards.
 func (e *InsertQueryExecutor) Exec() (sql.Result, error) {
 	query, ok := e.query.(*sqlparser.InsertQuery)
@@ -53,30 +178,47 @@ func (e *InsertQueryExecutor) Exec() (sql.Result, error) {
 	if e.conn.ShardConnections.ShardNum() == 0 {
 		return nil, errors.New("cannot insert row. shard connections is nil")
 	}
+	if query.IsMultiRows() {
+		return e.execMultiRows(query)
+	}
 
-	nextSequenceID, err := e.nextSequenceID(query)
+	shardConn, nextSequenceID, err := e.prepareRow(query)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	query.SetNextSequenceID(nextSequenceID)
-	shardKeyID := query.ShardKeyID
-	if e.conn.IsEqualShardColumnToShardKeyColumn() {
-		shardKeyID = sqlparser.Identifier(nextSequenceID)
-	}
-	if shardKeyID == sqlparser.UnknownID {
-		return nil, errors.New("shard_key id is not found")
-	}
-	shardConn, err := e.conn.ShardConnectionByID(int64(shardKeyID))
+	text, args, err := query.TextAndArgs()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.String())
-	result, err := e.exec(shardConn, query.String())
+	debug.Printf("(DB:%s):%s", shardConn.ShardName, text)
+	result, err := e.exec(shardConn, text, args...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	if e.conn.IsUsedSequencer {
+	if !e.conn.IsUsedSequencer {
+		return result.(sql.Result), nil
+	}
+	if !query.IsUpsert() {
 		return &mergedResult{affectedRows: 1, lastInsertedID: nextSequenceID}, nil
 	}
-	return result.(sql.Result), nil
+	return e.upsertResult(query, result.(sql.Result), nextSequenceID)
+}
+
+// upsertResult returns result of REPLACE or INSERT ... ON DUPLICATE KEY UPDATE for table using sequencer.
+// If ON DUPLICATE KEY UPDATE updates existing row, published id is not used,
+// so LastInsertId returns the value returned by database.
+func (e *InsertQueryExecutor) upsertResult(query *sqlparser.InsertQuery, result sql.Result, nextSequenceID int64) (sql.Result, error) {
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	_, isSpecified := query.ShardColumnValue()
+	if query.IsReplace() || isSpecified || affectedRows == 1 {
+		return &mergedResult{affectedRows: affectedRows, lastInsertedID: nextSequenceID}, nil
+	}
+	lastInsertedID, err := result.LastInsertId()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &mergedResult{affectedRows: affectedRows, lastInsertedID: lastInsertedID}, nil
 }
@@ -0,0 +1,382 @@
+package exec
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/sqlparser"
+)
+
+const (
+	noLimit = -1
+)
+
+// columnRef refers to the column of rows fetched from shards.
+type columnRef struct {
+	// position of column in select list.
+	// if isHidden is true, this is the position from first hidden column.
+	// if -1, column is found by name.
+	index    int
+	name     string
+	isHidden bool
+}
+
+func (r *columnRef) resolve(columns []string, hiddenColumnNum int) (int, error) {
+	visibleColumnNum := len(columns) - hiddenColumnNum
+	if r.isHidden {
+		return visibleColumnNum + r.index, nil
+	}
+	if r.index >= 0 {
+		if r.index >= visibleColumnNum {
+			return -1, errors.Errorf("column position %d is out of range", r.index+1)
+		}
+		return r.index, nil
+	}
+	for idx, column := range columns[:visibleColumnNum] {
+		if strings.EqualFold(column, r.name) {
+			return idx, nil
+		}
+	}
+	return -1, errors.Errorf("cannot find column '%s' in result set", r.name)
+}
+
+type aggregateFunc int
+
+const (
+	aggregateFuncNone aggregateFunc = iota
+	aggregateFuncCount
+	aggregateFuncSum
+	aggregateFuncMin
+	aggregateFuncMax
+	aggregateFuncAvg
+)
+
+var aggregateFuncNames = map[string]aggregateFunc{
+	"count": aggregateFuncCount,
+	"sum":   aggregateFuncSum,
+	"min":   aggregateFuncMin,
+	"max":   aggregateFuncMax,
+	"avg":   aggregateFuncAvg,
+}
+
+// aggregateColumn is the column of aggregate function.
+// AVG is rewritten into SUM and hidden COUNT column for each shard.
+type aggregateColumn struct {
+	column      *columnRef
+	function    aggregateFunc
+	countColumn *columnRef
+}
+
+type orderKey struct {
+	column *columnRef
+	isDesc bool
+}
+
+// mergePlan has informations to merge rows fetched from all shards.
+// text and args are the query for each shard that may be rewritten from original query.
+// stmt is the rewritten statement to format query for each shard again.
+type mergePlan struct {
+	text             string
+	args             []interface{}
+	stmt             *vtparser.Select
+	orderKeys        []*orderKey
+	aggregateColumns []*aggregateColumn
+	groupColumns     []*columnRef
+	having           condition
+	isDistinct       bool
+	offset           int64
+	limit            int64
+	hiddenColumnNum  int
+	isRewritten      bool
+}
+
+func newMergePlan(query *sqlparser.QueryBase) (*mergePlan, error) {
+	stmt, ok := query.Stmt.(*vtparser.Select)
+	if !ok {
+		return nil, errors.New("cannot convert vtparser.Statement to *vtparser.Select")
+	}
+	plan := &mergePlan{
+		text:  query.Text,
+		args:  query.Args,
+		limit: noLimit,
+	}
+	shardStmt := *stmt
+	shardStmt.SelectExprs = append(vtparser.SelectExprs{}, stmt.SelectExprs...)
+	plan.stmt = &shardStmt
+	if err := plan.setAggregateColumns(&shardStmt); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, expr := range stmt.GroupBy {
+		column, err := plan.columnRefByExpr(stmt, &shardStmt, expr)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		plan.groupColumns = append(plan.groupColumns, column)
+	}
+	if stmt.Having != nil {
+		having, err := plan.conditionByExpr(query, stmt, &shardStmt, stmt.Having.Expr)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		plan.having = having
+		shardStmt.Having = nil
+		plan.isRewritten = true
+	}
+	plan.isDistinct = stmt.Distinct == vtparser.DistinctStr
+	for _, order := range stmt.OrderBy {
+		column, err := plan.columnRefByExpr(stmt, &shardStmt, order.Expr)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		plan.orderKeys = append(plan.orderKeys, &orderKey{
+			column: column,
+			isDesc: order.Direction == vtparser.DescScr,
+		})
+	}
+	if stmt.Limit != nil {
+		if err := plan.setLimit(query, &shardStmt); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if !plan.isRewritten && stmt.Limit == nil {
+		return plan, nil
+	}
+	text, args, err := query.StringWithArgs(&shardStmt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	plan.text = text
+	plan.args = args
+	return plan, nil
+}
+
+func (p *mergePlan) isMergeRequired() bool {
+	return len(p.orderKeys) > 0 || p.limit != noLimit || p.offset > 0 || p.isAggregated() || p.isDistinct
+}
+
+// isAggregated returns whether rows of shards must be re-grouped in memory.
+func (p *mergePlan) isAggregated() bool {
+	return len(p.aggregateColumns) > 0 || len(p.groupColumns) > 0 || p.having != nil
+}
+
+func (p *mergePlan) addHiddenColumn(stmt *vtparser.Select, expr vtparser.Expr) *columnRef {
+	stmt.SelectExprs = append(stmt.SelectExprs, &vtparser.AliasedExpr{Expr: expr})
+	ref := &columnRef{index: p.hiddenColumnNum, isHidden: true}
+	p.hiddenColumnNum++
+	p.isRewritten = true
+	return ref
+}
+
+// setAggregateColumns finds aggregate functions from select list.
+// AVG(x) is rewritten into SUM(x) with hidden COUNT(x) column.
+func (p *mergePlan) setAggregateColumns(stmt *vtparser.Select) error {
+	existsStarExpr := false
+	for idx, selectExpr := range stmt.SelectExprs {
+		switch e := selectExpr.(type) {
+		case *vtparser.StarExpr:
+			existsStarExpr = true
+		case *vtparser.AliasedExpr:
+			column, err := p.aggregateColumnByExpr(stmt, e.Expr, &columnRef{index: idx})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if column == nil {
+				continue
+			}
+			if column.function == aggregateFuncAvg {
+				stmt.SelectExprs[idx] = p.avgToSumExpr(e)
+			}
+			p.aggregateColumns = append(p.aggregateColumns, column)
+		}
+	}
+	if existsStarExpr && p.isAggregated() {
+		return errors.New("cannot merge aggregate function with '*' for all shards")
+	}
+	return nil
+}
+
+func (p *mergePlan) aggregateColumnByExpr(stmt *vtparser.Select, expr vtparser.Expr, column *columnRef) (*aggregateColumn, error) {
+	funcExpr, ok := expr.(*vtparser.FuncExpr)
+	if !ok {
+		return nil, validateNestedAggregateFunc(expr)
+	}
+	function, exists := aggregateFuncNames[funcExpr.Name.Lowered()]
+	if !exists {
+		return nil, validateNestedAggregateFunc(expr)
+	}
+	if funcExpr.Distinct {
+		return nil, errors.Errorf("cannot merge '%s' for all shards", vtparser.String(funcExpr))
+	}
+	aggregateColumn := &aggregateColumn{
+		column:   column,
+		function: function,
+	}
+	if function == aggregateFuncAvg {
+		aggregateColumn.countColumn = p.addHiddenColumn(stmt, &vtparser.FuncExpr{
+			Name:  vtparser.NewColIdent("count"),
+			Exprs: funcExpr.Exprs,
+		})
+	}
+	return aggregateColumn, nil
+}
+
+// validateNestedAggregateFunc returns error if aggregate function is used in expression like 'SUM(x) + 1' or 'COALESCE(MAX(x), 0)'.
+// Only aggregate function at top level of expression can be merged for all shards.
+func validateNestedAggregateFunc(expr vtparser.Expr) error {
+	return vtparser.Walk(func(node vtparser.SQLNode) (bool, error) {
+		switch n := node.(type) {
+		case *vtparser.Subquery:
+			// subquery is executed in each shard
+			return false, nil
+		case *vtparser.FuncExpr:
+			if _, exists := aggregateFuncNames[n.Name.Lowered()]; exists {
+				return false, errors.New("unsupported aggregate expression for scatter query")
+			}
+		}
+		return true, nil
+	}, expr)
+}
+
+func (p *mergePlan) avgToSumExpr(expr *vtparser.AliasedExpr) *vtparser.AliasedExpr {
+	funcExpr := expr.Expr.(*vtparser.FuncExpr)
+	alias := expr.As
+	if alias.IsEmpty() {
+		// keep column name of AVG
+		alias = vtparser.NewColIdent(vtparser.String(funcExpr))
+	}
+	p.isRewritten = true
+	return &vtparser.AliasedExpr{
+		Expr: &vtparser.FuncExpr{
+			Qualifier: funcExpr.Qualifier,
+			Name:      vtparser.NewColIdent("sum"),
+			Exprs:     funcExpr.Exprs,
+		},
+		As: alias,
+	}
+}
+
+// setLimit pushes down 'LIMIT offset + limit' to each shard,
+// and global offset and limit are applied to merged rows.
+// Grouped query is not pushed down because each shard returns partial groups.
+func (p *mergePlan) setLimit(query *sqlparser.QueryBase, stmt *vtparser.Select) error {
+	if stmt.Limit.Offset != nil {
+		offset, err := p.limitValue(query, stmt.Limit.Offset)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		p.offset = offset
+	}
+	limit, err := p.limitValue(query, stmt.Limit.Rowcount)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	p.limit = limit
+	if len(p.groupColumns) > 0 || p.having != nil {
+		stmt.Limit = nil
+		return nil
+	}
+	stmt.Limit = &vtparser.Limit{
+		Rowcount: vtparser.NewIntVal([]byte(fmt.Sprint(p.offset + p.limit))),
+	}
+	return nil
+}
+
+func (p *mergePlan) limitValue(query *sqlparser.QueryBase, expr vtparser.Expr) (int64, error) {
+	val, ok := expr.(*vtparser.SQLVal)
+	if !ok {
+		return 0, errors.Errorf("unsupported limit expr type '%s'", reflect.TypeOf(expr))
+	}
+	switch val.Type {
+	case vtparser.IntVal:
+		value, err := strconv.ParseInt(string(val.Val), 10, 64)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return value, nil
+	case vtparser.ValArg:
+		arg, err := query.ArgByValArg(val)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		value, ok := toInt64(arg)
+		if !ok {
+			return 0, errors.Errorf("unsupported limit value type '%s'", reflect.TypeOf(arg))
+		}
+		return value, nil
+	}
+	return 0, errors.Errorf("unsupported limit value '%s'", string(val.Val))
+}
+
+// columnRefByExpr find column from select list by expr.
+// If not found, add expr to select list of shard query as hidden column.
+func (p *mergePlan) columnRefByExpr(stmt *vtparser.Select, shardStmt *vtparser.Select, expr vtparser.Expr) (*columnRef, error) {
+	if val, ok := expr.(*vtparser.SQLVal); ok && val.Type == vtparser.IntVal {
+		position, err := strconv.Atoi(string(val.Val))
+		if err == nil && position > 0 {
+			return &columnRef{index: position - 1}, nil
+		}
+	}
+	existsStarExpr := false
+	for idx, selectExpr := range stmt.SelectExprs {
+		switch e := selectExpr.(type) {
+		case *vtparser.StarExpr:
+			existsStarExpr = true
+		case *vtparser.AliasedExpr:
+			if !isSameColumnExpr(e, expr) {
+				continue
+			}
+			if !existsStarExpr {
+				return &columnRef{index: idx}, nil
+			}
+			if !e.As.IsEmpty() {
+				return &columnRef{index: -1, name: e.As.String()}, nil
+			}
+			if colName, ok := e.Expr.(*vtparser.ColName); ok {
+				return &columnRef{index: -1, name: colName.Name.String()}, nil
+			}
+		}
+	}
+	if colName, ok := expr.(*vtparser.ColName); ok && existsStarExpr {
+		return &columnRef{index: -1, name: colName.Name.String()}, nil
+	}
+	ref := p.addHiddenColumn(shardStmt, expr)
+	aggregateColumn, err := p.aggregateColumnByExpr(shardStmt, expr, ref)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if aggregateColumn != nil {
+		if aggregateColumn.function == aggregateFuncAvg {
+			lastIndex := len(shardStmt.SelectExprs) - 2
+			shardStmt.SelectExprs[lastIndex] = p.avgToSumExpr(shardStmt.SelectExprs[lastIndex].(*vtparser.AliasedExpr))
+		}
+		p.aggregateColumns = append(p.aggregateColumns, aggregateColumn)
+	}
+	return ref, nil
+}
+
+func isSameColumnExpr(selectExpr *vtparser.AliasedExpr, expr vtparser.Expr) bool {
+	if colName, ok := expr.(*vtparser.ColName); ok {
+		if selectExpr.As.EqualString(colName.Name.String()) {
+			return true
+		}
+		if selectColName, ok := selectExpr.Expr.(*vtparser.ColName); ok {
+			return selectColName.Name.Equal(colName.Name)
+		}
+	}
+	return strings.EqualFold(vtparser.String(selectExpr.Expr), vtparser.String(expr))
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,810 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	mergedRowsDBOnce sync.Once
+	mergedRowsDB     *sql.DB
+)
+
+// mergedRowsConnector is the internal connector that isn't registered as driver.
+// Its connection returns driver.Rows passed by query argument as it is,
+// so that merged rows are able to be handled as *sql.Rows or *sql.Row.
+type mergedRowsConnector struct{}
+
+func (c *mergedRowsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &mergedRowsConn{}, nil
+}
+
+func (c *mergedRowsConnector) Driver() driver.Driver {
+	return &mergedRowsDriver{}
+}
+
+type mergedRowsDriver struct{}
+
+func (d *mergedRowsDriver) Open(name string) (driver.Conn, error) {
+	return &mergedRowsConn{}, nil
+}
+
+type mergedRowsConn struct{}
+
+func (c *mergedRowsConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("merged rows connection cannot invoke Prepare()")
+}
+
+func (c *mergedRowsConn) Close() error {
+	return nil
+}
+
+func (c *mergedRowsConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("merged rows connection cannot invoke Begin()")
+}
+
+// CheckNamedValue accepts any value to pass driver.Rows through query argument.
+func (c *mergedRowsConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c *mergedRowsConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) != 1 {
+		return nil, errors.New("merged rows connection requires single driver.Rows argument")
+	}
+	rows, ok := args[0].Value.(driver.Rows)
+	if !ok {
+		return nil, errors.Errorf("cannot convert %s to driver.Rows", reflect.TypeOf(args[0].Value))
+	}
+	return rows, nil
+}
+
+func mergedRowsConnection() (*sql.DB, error) {
+	mergedRowsDBOnce.Do(func() {
+		mergedRowsDB = sql.OpenDB(&mergedRowsConnector{})
+	})
+	return mergedRowsDB, nil
+}
+
+// rowIterator iterates rows fetched from shards. next returns io.EOF if no more rows.
+type rowIterator interface {
+	next() ([]interface{}, error)
+}
+
+// shardRows iterates rows fetched from single shard.
+type shardRows struct {
+	rows *sql.Rows
+}
+
+func (r *shardRows) next() ([]interface{}, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return nil, io.EOF
+	}
+	columns, err := r.rows.Columns()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for idx := range values {
+		dest[idx] = &values[idx]
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return values, nil
+}
+
+// concatRows iterates rows of all shards in order of shards.
+type concatRows struct {
+	iterators []rowIterator
+	index     int
+}
+
+func (r *concatRows) next() ([]interface{}, error) {
+	for r.index < len(r.iterators) {
+		values, err := r.iterators[r.index].next()
+		if err == io.EOF {
+			r.index++
+			continue
+		}
+		return values, err
+	}
+	return nil, io.EOF
+}
+
+// sortedRows merges rows already sorted in each shard by k-way merge.
+type sortedRows struct {
+	iterators []rowIterator
+	heads     [][]interface{}
+	isEOF     []bool
+	keys      []*sortKey
+}
+
+func newSortedRows(iterators []rowIterator, keys []*sortKey) *sortedRows {
+	return &sortedRows{
+		iterators: iterators,
+		heads:     make([][]interface{}, len(iterators)),
+		isEOF:     make([]bool, len(iterators)),
+		keys:      keys,
+	}
+}
+
+func (r *sortedRows) next() ([]interface{}, error) {
+	selectedIndex := -1
+	for idx, iter := range r.iterators {
+		if r.isEOF[idx] {
+			continue
+		}
+		if r.heads[idx] == nil {
+			values, err := iter.next()
+			if err == io.EOF {
+				r.isEOF[idx] = true
+				continue
+			}
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			r.heads[idx] = values
+		}
+		if selectedIndex < 0 || compareRows(r.heads[idx], r.heads[selectedIndex], r.keys) < 0 {
+			selectedIndex = idx
+		}
+	}
+	if selectedIndex < 0 {
+		return nil, io.EOF
+	}
+	values := r.heads[selectedIndex]
+	r.heads[selectedIndex] = nil
+	return values, nil
+}
+
+// aggregatedRows re-groups rows fetched from each shard in memory.
+// It merges partial results of aggregate functions per group, then applies HAVING, DISTINCT and ORDER BY.
+type aggregatedRows struct {
+	source           rowIterator
+	columns          []*resolvedAggregateColumn
+	groupKeys        []int
+	having           condition
+	isDistinct       bool
+	visibleColumnNum int
+	keys             []*sortKey
+	rows             [][]interface{}
+	index            int
+	isDone           bool
+}
+
+type resolvedAggregateColumn struct {
+	index      int
+	countIndex int
+	function   aggregateFunc
+	isNumeric  bool
+}
+
+func newAggregatedRows(source rowIterator, plan *mergePlan, columns []string, columnTypes []*sql.ColumnType, keys []*sortKey) (*aggregatedRows, error) {
+	resolvedColumns := []*resolvedAggregateColumn{}
+	for _, column := range plan.aggregateColumns {
+		index, err := column.column.resolve(columns, plan.hiddenColumnNum)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		resolvedColumn := &resolvedAggregateColumn{
+			index:      index,
+			countIndex: -1,
+			function:   column.function,
+			isNumeric:  isNumericColumnType(columnTypes[index]),
+		}
+		if column.countColumn != nil {
+			countIndex, err := column.countColumn.resolve(columns, plan.hiddenColumnNum)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			resolvedColumn.countIndex = countIndex
+		}
+		resolvedColumns = append(resolvedColumns, resolvedColumn)
+	}
+	groupKeys := make([]int, len(plan.groupColumns))
+	for idx, column := range plan.groupColumns {
+		key, err := column.resolve(columns, plan.hiddenColumnNum)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		groupKeys[idx] = key
+	}
+	if plan.having != nil {
+		if err := plan.having.resolve(columns, columnTypes, plan.hiddenColumnNum); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return &aggregatedRows{
+		source:           source,
+		columns:          resolvedColumns,
+		groupKeys:        groupKeys,
+		having:           plan.having,
+		isDistinct:       plan.isDistinct,
+		visibleColumnNum: len(columns) - plan.hiddenColumnNum,
+		keys:             keys,
+	}, nil
+}
+
+func (r *aggregatedRows) next() ([]interface{}, error) {
+	if !r.isDone {
+		if err := r.aggregate(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		r.isDone = true
+	}
+	if r.index >= len(r.rows) {
+		return nil, io.EOF
+	}
+	values := r.rows[r.index]
+	r.index++
+	return values, nil
+}
+
+func (r *aggregatedRows) isGrouped() bool {
+	return len(r.columns) > 0 || len(r.groupKeys) > 0
+}
+
+func (r *aggregatedRows) aggregate() error {
+	groups := map[string][]interface{}{}
+	rows := [][]interface{}{}
+	for {
+		values, err := r.source.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !r.isGrouped() {
+			rows = append(rows, values)
+			continue
+		}
+		key := rowKey(values, r.groupKeys)
+		group, exists := groups[key]
+		if !exists {
+			groups[key] = values
+			rows = append(rows, values)
+			continue
+		}
+		if err := r.merge(group, values); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	seen := map[string]struct{}{}
+	for _, values := range rows {
+		if err := r.finalize(values); err != nil {
+			return errors.WithStack(err)
+		}
+		if r.having != nil {
+			matched, err := r.having.evaluate(values)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if !matched {
+				continue
+			}
+		}
+		if r.isDistinct {
+			key := rowKey(values[:r.visibleColumnNum], nil)
+			if _, exists := seen[key]; exists {
+				continue
+			}
+			seen[key] = struct{}{}
+		}
+		r.rows = append(r.rows, values)
+	}
+	if len(r.keys) > 0 {
+		sort.SliceStable(r.rows, func(i, j int) bool {
+			return compareRows(r.rows[i], r.rows[j], r.keys) < 0
+		})
+	}
+	return nil
+}
+
+func (r *aggregatedRows) merge(result []interface{}, values []interface{}) error {
+	for _, column := range r.columns {
+		merged, err := mergeAggregateValue(column.function, result[column.index], values[column.index], column.isNumeric)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		result[column.index] = merged
+		if column.countIndex < 0 {
+			continue
+		}
+		count, err := mergeAggregateValue(aggregateFuncCount, result[column.countIndex], values[column.countIndex], true)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		result[column.countIndex] = count
+	}
+	return nil
+}
+
+// finalize calculates AVG value by merged SUM and COUNT.
+func (r *aggregatedRows) finalize(result []interface{}) error {
+	for _, column := range r.columns {
+		if column.function != aggregateFuncAvg {
+			continue
+		}
+		sum := result[column.index]
+		count, _ := valueToFloat64(result[column.countIndex])
+		if sum == nil || count == 0 {
+			result[column.index] = nil
+			continue
+		}
+		if isTextValue(sum) {
+			// DECIMAL is averaged exactly with 4 more digits of scale like MySQL
+			value, err := divideDecimal(sum, result[column.countIndex], decimalScale(sum)+4)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			result[column.index] = value
+			continue
+		}
+		value, ok := valueToFloat64(sum)
+		if !ok {
+			return errors.Errorf("cannot calculate average from %v", sum)
+		}
+		result[column.index] = value / count
+	}
+	return nil
+}
+
+// distinctRows removes duplicated rows fetched from different shards.
+type distinctRows struct {
+	source           rowIterator
+	visibleColumnNum int
+	seen             map[string]struct{}
+}
+
+func (r *distinctRows) next() ([]interface{}, error) {
+	for {
+		values, err := r.source.next()
+		if err != nil {
+			return nil, err
+		}
+		key := rowKey(values[:r.visibleColumnNum], nil)
+		if _, exists := r.seen[key]; exists {
+			continue
+		}
+		r.seen[key] = struct{}{}
+		return values, nil
+	}
+}
+
+// rowKey creates key to identify row by values of specified columns.
+// If indices is nil, all values are used.
+func rowKey(values []interface{}, indices []int) string {
+	if indices == nil {
+		indices = make([]int, len(values))
+		for idx := range values {
+			indices[idx] = idx
+		}
+	}
+	keys := make([]string, len(indices))
+	for idx, index := range indices {
+		if values[index] == nil {
+			keys[idx] = "NULL"
+			continue
+		}
+		keys[idx] = strconv.Quote(string(valueToBytes(values[index])))
+	}
+	return strings.Join(keys, ",")
+}
+
+func mergeAggregateValue(function aggregateFunc, a, b interface{}, isNumeric bool) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	}
+	if b == nil {
+		return a, nil
+	}
+	switch function {
+	case aggregateFuncCount, aggregateFuncSum, aggregateFuncAvg:
+		return addValues(a, b)
+	case aggregateFuncMin:
+		if compareValues(b, a, isNumeric) < 0 {
+			return b, nil
+		}
+		return a, nil
+	case aggregateFuncMax:
+		if compareValues(b, a, isNumeric) > 0 {
+			return b, nil
+		}
+		return a, nil
+	}
+	return nil, errors.Errorf("unknown aggregate function %d", function)
+}
+
+// addValues adds partial results of SUM or COUNT.
+// Text value ( e.g. DECIMAL fetched by MySQL ) is added exactly as decimal, and overflow of integer value is error.
+// Integer fetched as text is also treated as DECIMAL, so it is not overflowed.
+func addValues(a, b interface{}) (interface{}, error) {
+	isDecimal := isTextValue(a) || isTextValue(b)
+	if ai, ok := valueToInt64(a); ok {
+		if bi, ok := valueToInt64(b); ok {
+			sum := ai + bi
+			isOverflow := (bi > 0 && sum < ai) || (bi < 0 && sum > ai)
+			if !isOverflow {
+				return sum, nil
+			}
+			if !isDecimal {
+				return nil, errors.Errorf("cannot add value %d to %d. integer overflow", bi, ai)
+			}
+		}
+	}
+	if isDecimal {
+		if sum, err := addDecimal(a, b); err == nil {
+			return sum, nil
+		}
+	}
+	af, ok := valueToFloat64(a)
+	if !ok {
+		return nil, errors.Errorf("cannot add value %v", a)
+	}
+	bf, ok := valueToFloat64(b)
+	if !ok {
+		return nil, errors.Errorf("cannot add value %v", b)
+	}
+	return af + bf, nil
+}
+
+// addDecimal adds values by big.Rat, and returns text of decimal that has larger scale of them.
+func addDecimal(a, b interface{}) ([]byte, error) {
+	ar, err := valueToRat(a)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	br, err := valueToRat(b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	scale := decimalScale(a)
+	if bs := decimalScale(b); bs > scale {
+		scale = bs
+	}
+	return []byte(new(big.Rat).Add(ar, br).FloatString(scale)), nil
+}
+
+func divideDecimal(a, b interface{}, scale int) ([]byte, error) {
+	ar, err := valueToRat(a)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	br, err := valueToRat(b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if br.Sign() == 0 {
+		return nil, errors.New("cannot divide by zero")
+	}
+	return []byte(new(big.Rat).Quo(ar, br).FloatString(scale)), nil
+}
+
+func valueToRat(v interface{}) (*big.Rat, error) {
+	if i, ok := v.(int64); ok {
+		return new(big.Rat).SetInt64(i), nil
+	}
+	if !isTextValue(v) {
+		return nil, errors.Errorf("cannot convert %v to decimal", v)
+	}
+	r, ok := new(big.Rat).SetString(string(valueToBytes(v)))
+	if !ok {
+		return nil, errors.Errorf("cannot convert %v to decimal", v)
+	}
+	return r, nil
+}
+
+// decimalScale returns number of digits after decimal point of text value.
+func decimalScale(v interface{}) int {
+	if !isTextValue(v) {
+		return 0
+	}
+	text := string(valueToBytes(v))
+	if idx := strings.Index(text, "."); idx >= 0 {
+		return len(text) - idx - 1
+	}
+	return 0
+}
+
+// mergedRows implements driver.Rows for rows merged from all shards.
+type mergedRows struct {
+	cores       []*sql.Rows
+	columns     []string
+	columnTypes []*sql.ColumnType
+	iter        rowIterator
+	offset      int64
+	limit       int64
+	returnedNum int64
+	cancel      context.CancelFunc
+}
+
+func newMergedRows(plan *mergePlan, cores []*sql.Rows) (*mergedRows, error) {
+	if len(cores) == 0 {
+		return nil, errors.New("cannot merge rows. shard rows are empty")
+	}
+	columns, err := cores[0].Columns()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	columnTypes, err := cores[0].ColumnTypes()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	iterators := make([]rowIterator, len(cores))
+	for idx, core := range cores {
+		iterators[idx] = &shardRows{rows: core}
+	}
+	keys := make([]*sortKey, len(plan.orderKeys))
+	for idx, orderKey := range plan.orderKeys {
+		index, err := orderKey.column.resolve(columns, plan.hiddenColumnNum)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		keys[idx] = &sortKey{
+			index:     index,
+			isDesc:    orderKey.isDesc,
+			isNumeric: isNumericColumnType(columnTypes[index]),
+		}
+	}
+	var iter rowIterator
+	if plan.isAggregated() {
+		iter, err = newAggregatedRows(&concatRows{iterators: iterators}, plan, columns, columnTypes, keys)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else {
+		if len(plan.orderKeys) > 0 {
+			iter = newSortedRows(iterators, keys)
+		} else {
+			iter = &concatRows{iterators: iterators}
+		}
+		if plan.isDistinct {
+			iter = &distinctRows{
+				source:           iter,
+				visibleColumnNum: len(columns) - plan.hiddenColumnNum,
+				seen:             map[string]struct{}{},
+			}
+		}
+	}
+	visibleColumnNum := len(columns) - plan.hiddenColumnNum
+	return &mergedRows{
+		cores:       cores,
+		columns:     columns[:visibleColumnNum],
+		columnTypes: columnTypes[:visibleColumnNum],
+		iter:        iter,
+		offset:      plan.offset,
+		limit:       plan.limit,
+	}, nil
+}
+
+func (r *mergedRows) Columns() []string {
+	return r.columns
+}
+
+func (r *mergedRows) Close() error {
+	errs := []string{}
+	for _, core := range r.cores {
+		if err := core.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if r.cancel != nil {
+		r.cancel()
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ":"))
+	}
+	return nil
+}
+
+func (r *mergedRows) Next(dest []driver.Value) error {
+	for ; r.offset > 0; r.offset-- {
+		if _, err := r.iter.next(); err != nil {
+			return err
+		}
+	}
+	if r.limit != noLimit && r.returnedNum >= r.limit {
+		return io.EOF
+	}
+	values, err := r.iter.next()
+	if err != nil {
+		return err
+	}
+	for idx := range dest {
+		dest[idx] = values[idx]
+	}
+	r.returnedNum++
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns database type name of first shard's column.
+func (r *mergedRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.columnTypes[index].DatabaseTypeName()
+}
+
+// ColumnTypeScanType returns scan type of first shard's column.
+func (r *mergedRows) ColumnTypeScanType(index int) reflect.Type {
+	return r.columnTypes[index].ScanType()
+}
+
+// ColumnTypeNullable returns nullable of first shard's column.
+func (r *mergedRows) ColumnTypeNullable(index int) (bool, bool) {
+	return r.columnTypes[index].Nullable()
+}
+
+// ColumnTypeLength returns length of first shard's column.
+func (r *mergedRows) ColumnTypeLength(index int) (int64, bool) {
+	return r.columnTypes[index].Length()
+}
+
+// ColumnTypePrecisionScale returns decimal size of first shard's column.
+func (r *mergedRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	return r.columnTypes[index].DecimalSize()
+}
+
+// sortKey is the column of ORDER BY resolved for fetched rows.
+type sortKey struct {
+	index     int
+	isDesc    bool
+	isNumeric bool
+}
+
+func compareRows(a, b []interface{}, keys []*sortKey) int {
+	for _, key := range keys {
+		result := compareValues(a[key.index], b[key.index], key.isNumeric)
+		if result == 0 {
+			continue
+		}
+		if key.isDesc {
+			return -result
+		}
+		return result
+	}
+	return 0
+}
+
+// isNumericColumnType returns whether values of the column are compared as number.
+func isNumericColumnType(columnType *sql.ColumnType) bool {
+	name := strings.ToUpper(columnType.DatabaseTypeName())
+	if idx := strings.Index(name, "("); idx >= 0 {
+		name = name[:idx]
+	}
+	for _, field := range strings.Fields(name) {
+		switch field {
+		case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "DECIMAL", "NUMERIC", "FLOAT", "DOUBLE", "REAL", "YEAR":
+			return true
+		}
+	}
+	return false
+}
+
+func isTextValue(v interface{}) bool {
+	switch v.(type) {
+	case []byte, string:
+		return true
+	}
+	return false
+}
+
+// compareValues compares values fetched from database.
+// NULL is treated as smallest value like MySQL and SQLite.
+// Text values are compared as number only if isNumeric is true ( e.g. numeric column fetched as text by MySQL ),
+// otherwise they are compared as bytes even if they look like number, because each shard sorts them as string.
+// Collation of the column is not considered.
+func compareValues(a, b interface{}, isNumeric bool) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			switch {
+			case at.Before(bt):
+				return -1
+			case at.After(bt):
+				return 1
+			}
+			return 0
+		}
+	}
+	if !isNumeric && (isTextValue(a) || isTextValue(b)) {
+		return bytes.Compare(valueToBytes(a), valueToBytes(b))
+	}
+	if ai, ok := valueToInt64(a); ok {
+		if bi, ok := valueToInt64(b); ok {
+			switch {
+			case ai < bi:
+				return -1
+			case ai > bi:
+				return 1
+			}
+			return 0
+		}
+	}
+	if af, ok := valueToFloat64(a); ok {
+		if bf, ok := valueToFloat64(b); ok {
+			switch {
+			case af < bf:
+				return -1
+			case af > bf:
+				return 1
+			}
+			return 0
+		}
+	}
+	return bytes.Compare(valueToBytes(a), valueToBytes(b))
+}
+
+func valueToInt64(v interface{}) (int64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return value, true
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	case []byte:
+		i, err := strconv.ParseInt(string(value), 10, 64)
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(value, 10, 64)
+		return i, err == nil
+	}
+	return toInt64(v)
+}
+
+func valueToFloat64(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case []byte:
+		f, err := strconv.ParseFloat(string(value), 64)
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+		return f, err == nil
+	}
+	if i, ok := valueToInt64(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+func valueToBytes(v interface{}) []byte {
+	switch value := v.(type) {
+	case []byte:
+		return value
+	case string:
+		return []byte(value)
+	case time.Time:
+		return []byte(value.Format(time.RFC3339Nano))
+	}
+	return []byte(fmt.Sprint(v))
+}
@@ -0,0 +1,71 @@
+package exec
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAddValues(t *testing.T) {
+	t.Run("decimal", func(t *testing.T) {
+		sum, err := addValues([]byte("1.10"), []byte("2.20"))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if string(sum.([]byte)) != "3.30" {
+			t.Fatalf("decimal must be added exactly. %s", sum)
+		}
+		sum, err = addValues(sum, []byte("0.005"))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if string(sum.([]byte)) != "3.305" {
+			t.Fatalf("decimal must be added exactly. %s", sum)
+		}
+	})
+	t.Run("integer", func(t *testing.T) {
+		sum, err := addValues(int64(1), []byte("2"))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if sum != int64(3) {
+			t.Fatalf("cannot add integer. %v", sum)
+		}
+	})
+	t.Run("integer overflow", func(t *testing.T) {
+		if _, err := addValues(int64(math.MaxInt64), int64(1)); err == nil {
+			t.Fatal("cannot handle overflow")
+		}
+		if _, err := addValues(int64(math.MinInt64), int64(-1)); err == nil {
+			t.Fatal("cannot handle overflow")
+		}
+		sum, err := addValues([]byte("9223372036854775807"), []byte("1"))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if string(sum.([]byte)) != "9223372036854775808" {
+			t.Fatalf("decimal must not be overflowed. %s", sum)
+		}
+	})
+	t.Run("float", func(t *testing.T) {
+		sum, err := addValues(1.5, int64(2))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if sum != 3.5 {
+			t.Fatalf("cannot add float. %v", sum)
+		}
+	})
+}
+
+func TestFinalizeAverageOfDecimal(t *testing.T) {
+	rows := &aggregatedRows{
+		columns: []*resolvedAggregateColumn{{index: 0, countIndex: 1, function: aggregateFuncAvg}},
+	}
+	result := []interface{}{[]byte("10.00"), []byte("3")}
+	if err := rows.finalize(result); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if string(result[0].([]byte)) != "3.333333" {
+		t.Fatalf("decimal must be averaged exactly. %s", result[0])
+	}
+}
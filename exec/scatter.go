@@ -0,0 +1,214 @@
+package exec
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/connection"
+	"github.com/aokabi/octillery/debug"
+	"github.com/aokabi/octillery/sqlparser"
+)
+
+// shardFunc executes query for single shard.
+// idx is the index of shard in all shards, so that caller is able to store result by order of shards.
+type shardFunc func(ctx context.Context, idx int, shardConn *connection.DBShardConnection) error
+
+func (e *QueryExecutorBase) maxParallelism() int {
+	shardNum := e.conn.ShardConnections.ShardNum()
+	if e.tx != nil {
+		// TxConnection cannot be used by multiple goroutines
+		return 1
+	}
+	if e.conn.MaxParallelism <= 0 || e.conn.MaxParallelism > shardNum {
+		return shardNum
+	}
+	return e.conn.MaxParallelism
+}
+
+// shardQuery is the query executed for a shard.
+// keys are sharding keys that belong to the shard, and empty if query is executed for all shards.
+type shardQuery struct {
+	shardConn *connection.DBShardConnection
+	keys      []sqlparser.Identifier
+	text      string
+	args      []interface{}
+}
+
+func (e *QueryExecutorBase) allShardQueries(text string, args []interface{}) []*shardQuery {
+	shardQueries := []*shardQuery{}
+	for _, shardConn := range e.conn.ShardConnections.AllShard() {
+		shardQueries = append(shardQueries, &shardQuery{
+			shardConn: shardConn,
+			text:      text,
+			args:      args,
+		})
+	}
+	return shardQueries
+}
+
+// scatterShardQueries returns queries for shards that may have rows matched by query without sharding key.
+// If query has range of shard_key and sharding algorithm supports it, shards are pruned by the range.
+func (e *QueryExecutorBase) scatterShardQueries(query *sqlparser.QueryBase, text string, args []interface{}) ([]*shardQuery, error) {
+	if query.ShardKeyRange == nil {
+		return e.allShardQueries(text, args), nil
+	}
+	shardConns, err := e.conn.ShardConnectionsByRange(query.ShardKeyRange)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(shardConns) == 0 {
+		// no shard has the range. it is left to all shards
+		return e.allShardQueries(text, args), nil
+	}
+	debug.Printf("%d/%d shards are selected by range %s", len(shardConns), e.conn.ShardConnections.ShardNum(), query.ShardKeyRange)
+	shardQueries := []*shardQuery{}
+	for _, shardConn := range shardConns {
+		shardQueries = append(shardQueries, &shardQuery{
+			shardConn: shardConn,
+			text:      text,
+			args:      args,
+		})
+	}
+	return shardQueries, nil
+}
+
+// shardQueriesByKeys groups ShardKeyIDs of query by shard, and formats stmt for each shard.
+// Values of IN condition for shard_key column are pruned to keys that belong to the shard.
+// Returned queries are sorted by order of shards.
+func (e *QueryExecutorBase) shardQueriesByKeys(query *sqlparser.QueryBase, stmt vtparser.SQLNode) ([]*shardQuery, error) {
+	shardQueryMap := map[string]*shardQuery{}
+	for _, key := range query.ShardKeyIDs {
+		shardConn, err := e.conn.ShardConnectionByKey(key)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, exists := shardQueryMap[shardConn.ShardName]; !exists {
+			shardQueryMap[shardConn.ShardName] = &shardQuery{shardConn: shardConn}
+		}
+		shardQueryMap[shardConn.ShardName].keys = append(shardQueryMap[shardConn.ShardName].keys, key)
+	}
+	shardQueries := []*shardQuery{}
+	for _, shardConn := range e.conn.ShardConnections.AllShard() {
+		shardQuery, exists := shardQueryMap[shardConn.ShardName]
+		if !exists {
+			continue
+		}
+		text, args, err := query.StringWithArgsForShardKeys(stmt, shardQuery.keys)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		shardQuery.text = text
+		shardQuery.args = args
+		shardQueries = append(shardQueries, shardQuery)
+	}
+	return shardQueries, nil
+}
+
+func shardConnsByQueries(shardQueries []*shardQuery) []*connection.DBShardConnection {
+	shardConns := make([]*connection.DBShardConnection, len(shardQueries))
+	for idx, shardQuery := range shardQueries {
+		shardConns[idx] = shardQuery.shardConn
+	}
+	return shardConns
+}
+
+// execForAllShard executes f for all shards concurrently.
+func (e *QueryExecutorBase) execForAllShard(ctx context.Context, cancel context.CancelFunc, f shardFunc) error {
+	return e.execForShards(ctx, cancel, e.conn.ShardConnections.AllShard(), f)
+}
+
+// execForShards executes f for specified shards concurrently.
+// The number of concurrent executions is limited by max_parallelism in config.
+// If f returns error for any shard, cancel is called to stop remaining shards.
+// Errors are joined by order of shards regardless of the order of completion.
+func (e *QueryExecutorBase) execForShards(ctx context.Context, cancel context.CancelFunc, shardConns []*connection.DBShardConnection, f shardFunc) error {
+	errs := make([]error, len(shardConns))
+	isSkipped := false
+	sem := make(chan struct{}, e.maxParallelism())
+	var wg sync.WaitGroup
+	for idx, shardConn := range shardConns {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			isSkipped = true
+			break
+		}
+		wg.Add(1)
+		go func(idx int, shardConn *connection.DBShardConnection) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := f(ctx, idx, shardConn); err != nil {
+				errs[idx] = err
+				cancel()
+			}
+		}(idx, shardConn)
+	}
+	wg.Wait()
+
+	canceledErrs := []string{}
+	failedErrs := []string{}
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if errors.Cause(err) == context.Canceled {
+			canceledErrs = append(canceledErrs, err.Error())
+			continue
+		}
+		failedErrs = append(failedErrs, err.Error())
+	}
+	if len(failedErrs) > 0 {
+		// shards canceled by other shard's error are not reported
+		return errors.New(strings.Join(failedErrs, ":"))
+	}
+	if len(canceledErrs) > 0 {
+		return errors.New(strings.Join(canceledErrs, ":"))
+	}
+	if isSkipped {
+		return errors.WithStack(ctx.Err())
+	}
+	return nil
+}
+
+// execForAllShardWithResult executes write query for all shards and returns sum of affected rows.
+// If executor has transaction, query is executed by it.
+func (e *QueryExecutorBase) execForAllShardWithResult(query string, args ...interface{}) (sql.Result, error) {
+	return e.execShardQueriesWithResult(e.allShardQueries(query, args))
+}
+
+// execShardQueriesWithResult executes write query for each shard and returns sum of affected rows.
+// If executor has transaction, query is executed by it.
+func (e *QueryExecutorBase) execShardQueriesWithResult(shardQueries []*shardQuery) (sql.Result, error) {
+	ctx, cancel := context.WithCancel(e.context())
+	defer cancel()
+	affectedRows := make([]int64, len(shardQueries))
+	if err := e.execForShards(ctx, cancel, shardConnsByQueries(shardQueries), func(ctx context.Context, idx int, shardConn *connection.DBShardConnection) error {
+		query := shardQueries[idx]
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, query.text)
+		result, err := e.execContext(ctx, shardConn, query.text, query.args...)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rowsAffected, err := result.(sql.Result).RowsAffected()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		affectedRows[idx] = rowsAffected
+		return nil
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var totalAffectedRows int64
+	for _, rowsAffected := range affectedRows {
+		totalAffectedRows = totalAffectedRows + rowsAffected
+	}
+	debug.Printf("totalAffectedRows = %d", totalAffectedRows)
+	return &mergedResult{affectedRows: totalAffectedRows, err: nil}, nil
+}
@@ -1,10 +1,11 @@
 package exec
 
 import (
+	"context"
 	"database/sql"
-	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/connection"
 	"github.com/aokabi/octillery/debug"
 	"github.com/aokabi/octillery/sqlparser"
 )
@@ -29,28 +30,15 @@ func (e *SelectQueryExecutor) Query() ([]*sql.Rows, error) {
 	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil {
 		return nil, errors.New("cannot execute query. sequencer's connection is nil")
 	}
-	allRows := make([]*sql.Rows, 0)
 	if query.IsNotFoundShardKeyID() {
-		debug.Printf("[WARN] query for all shards. current support only simple merge. doesn't support 'count' or 'order by' or 'limit'")
-		errs := []string{}
-		e.tx = nil // transaction is ignored at this query
-		for _, shardConn := range e.conn.ShardConnections.AllShard() {
-			debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
-			rows, err := e.execQuery(shardConn, query.Text, query.Args...)
-			if err != nil {
-				errs = append(errs, err.Error())
-				continue
-			}
-			allRows = append(allRows, rows)
-		}
-		if len(errs) > 0 {
-			err := strings.Join(errs, ":")
-			return allRows, errors.New(err)
-		}
-		return allRows, nil
+		return e.queryForAllShard(query)
+	}
+	if query.IsMultipleShardKeyIDs() {
+		return e.queryForShardKeys(query)
 	}
 
-	shardConn, err := e.conn.ShardConnectionByID(int64(query.ShardKeyID))
+	allRows := make([]*sql.Rows, 0)
+	shardConn, err := e.conn.ShardConnectionByKey(query.ShardKeyID)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -63,7 +51,108 @@ func (e *SelectQueryExecutor) Query() ([]*sql.Rows, error) {
 	return allRows, nil
 }
 
+func (e *SelectQueryExecutor) queryForAllShard(query *sqlparser.QueryBase) ([]*sql.Rows, error) {
+	plan, err := newMergePlan(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if plan.isMergeRequired() {
+		debug.Printf("[WARN] query for all shards. merge rows in memory")
+	} else {
+		debug.Printf("[WARN] query for all shards")
+	}
+	shardQueries, err := e.scatterShardQueries(query, plan.text, plan.args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return e.queryMergedRows(plan, shardQueries)
+}
+
+// queryForShardKeys executes query for shards that have ShardKeyIDs, and merges rows like queryForAllShard.
+func (e *SelectQueryExecutor) queryForShardKeys(query *sqlparser.QueryBase) ([]*sql.Rows, error) {
+	plan, err := newMergePlan(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	shardQueries, err := e.shardQueriesByKeys(query, plan.stmt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	debug.Printf("query for %d shards by %d shard keys", len(shardQueries), len(query.ShardKeyIDs))
+	return e.queryMergedRows(plan, shardQueries)
+}
+
+func (e *SelectQueryExecutor) queryMergedRows(plan *mergePlan, shardQueries []*shardQuery) ([]*sql.Rows, error) {
+	merged, err := e.queryShards(plan, shardQueries)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conn, err := mergedRowsConnection()
+	if err != nil {
+		merged.Close()
+		return nil, errors.WithStack(err)
+	}
+	rows, err := conn.QueryContext(e.context(), "", merged)
+	if err != nil {
+		merged.Close()
+		return nil, errors.WithStack(err)
+	}
+	return []*sql.Rows{rows}, nil
+}
+
+func (e *SelectQueryExecutor) queryRowMergedRows(plan *mergePlan, shardQueries []*shardQuery) (*sql.Row, error) {
+	merged, err := e.queryShards(plan, shardQueries)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conn, err := mergedRowsConnection()
+	if err != nil {
+		merged.Close()
+		return nil, errors.WithStack(err)
+	}
+	return conn.QueryRowContext(e.context(), "", merged), nil
+}
+
+// queryShards executes query for each shard concurrently and merges rows by order of shards.
+// Context for shards is canceled when merged rows are closed.
+func (e *SelectQueryExecutor) queryShards(plan *mergePlan, shardQueries []*shardQuery) (*mergedRows, error) {
+	e.tx = nil // transaction is ignored at this query
+	ctx, cancel := context.WithCancel(e.context())
+	allRows := make([]*sql.Rows, len(shardQueries))
+	if err := e.execForShards(ctx, cancel, shardConnsByQueries(shardQueries), func(ctx context.Context, idx int, shardConn *connection.DBShardConnection) error {
+		query := shardQueries[idx]
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, query.text)
+		rows, err := e.execQueryContext(ctx, shardConn, query.text, query.args...)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		allRows[idx] = rows
+		return nil
+	}); err != nil {
+		closeRows(allRows)
+		cancel()
+		return nil, errors.WithStack(err)
+	}
+	merged, err := newMergedRows(plan, allRows)
+	if err != nil {
+		closeRows(allRows)
+		cancel()
+		return nil, errors.WithStack(err)
+	}
+	merged.cancel = cancel
+	return merged, nil
+}
+
+func closeRows(allRows []*sql.Rows) {
+	for _, rows := range allRows {
+		if rows != nil {
+			rows.Close()
+		}
+	}
+}
+
 // QueryRow select row from single shard.
+// If query doesn't have shard key, it selects first row of merged rows from all shards.
 func (e *SelectQueryExecutor) QueryRow() (*sql.Row, error) {
 	query, ok := e.query.(*sqlparser.QueryBase)
 	if !ok {
@@ -75,11 +164,31 @@ func (e *SelectQueryExecutor) QueryRow() (*sql.Row, error) {
 	}
 
 	if query.IsNotFoundShardKeyID() {
-		debug.Printf("[WARN] cannot call queryRow for all shards")
-		return nil, nil
+		plan, err := newMergePlan(query)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		debug.Printf("[WARN] query row for all shards")
+		shardQueries, err := e.scatterShardQueries(query, plan.text, plan.args)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return e.queryRowMergedRows(plan, shardQueries)
+	}
+	if query.IsMultipleShardKeyIDs() {
+		plan, err := newMergePlan(query)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		shardQueries, err := e.shardQueriesByKeys(query, plan.stmt)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		debug.Printf("query row for %d shards by %d shard keys", len(shardQueries), len(query.ShardKeyIDs))
+		return e.queryRowMergedRows(plan, shardQueries)
 	}
 
-	shardConn, err := e.conn.ShardConnectionByID(int64(query.ShardKeyID))
+	shardConn, err := e.conn.ShardConnectionByKey(query.ShardKeyID)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -1,9 +1,11 @@
 package exec
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/connection"
 	"github.com/aokabi/octillery/debug"
 	"github.com/aokabi/octillery/sqlparser"
 )
@@ -35,10 +37,15 @@ func (e *TruncateQueryExecutor) Exec() (sql.Result, error) {
 	if !ok {
 		return nil, errors.New("cannot convert sqlparser.Query to *sqlparser.QueryBase")
 	}
-	for _, shardConn := range e.conn.ShardConnections.AllShard() {
-		if _, err := shardConn.Connection.Exec(query.Text); err != nil {
-			return nil, errors.WithStack(err)
+	ctx, cancel := context.WithCancel(e.context())
+	defer cancel()
+	if err := e.execForAllShard(ctx, cancel, func(ctx context.Context, idx int, shardConn *connection.DBShardConnection) error {
+		if _, err := shardConn.Connection.ExecContext(ctx, query.Text); err != nil {
+			return errors.WithStack(err)
 		}
+		return nil
+	}); err != nil {
+		return nil, errors.WithStack(err)
 	}
 	return nil, nil
 }
@@ -2,8 +2,12 @@ package exec
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/connection"
 	"github.com/aokabi/octillery/debug"
 	"github.com/aokabi/octillery/sqlparser"
 )
@@ -28,6 +32,41 @@ func (e *UpdateQueryExecutor) QueryRow() (*sql.Row, error) {
 	return nil, errors.New("UpdateQueryExecutor cannot invoke QueryRow()")
 }
 
+func (e *UpdateQueryExecutor) updateForAllShard(query *sqlparser.QueryBase) (sql.Result, error) {
+	if !e.conn.Config.AllowScatterWrite {
+		return nil, errors.New("cannot update row. not found shard_key column in this query. if you want to update for all shards, set allow_scatter_write to true")
+	}
+	stmt, ok := query.Stmt.(*vtparser.Update)
+	if !ok {
+		return nil, errors.New("cannot convert sqlparser.Query to *vtparser.Update")
+	}
+	if stmt.OrderBy != nil || stmt.Limit != nil {
+		return nil, errors.New("cannot update for all shards with ORDER BY or LIMIT")
+	}
+	debug.Printf("[WARN] update query for all shards. too slow")
+	shardQueries, err := e.scatterShardQueries(query, query.Text, query.Args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return e.execShardQueriesWithResult(shardQueries)
+}
+
+// updateForShardKeys executes UPDATE query for shards that have ShardKeyIDs.
+func (e *UpdateQueryExecutor) updateForShardKeys(query *sqlparser.QueryBase) (sql.Result, error) {
+	stmt, ok := query.Stmt.(*vtparser.Update)
+	if !ok {
+		return nil, errors.New("cannot convert sqlparser.Query to *vtparser.Update")
+	}
+	shardQueries, err := e.shardQueriesByKeys(query, stmt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(shardQueries) > 1 && (stmt.OrderBy != nil || stmt.Limit != nil) {
+		return nil, errors.New("cannot update for multiple shards with ORDER BY or LIMIT")
+	}
+	return e.execShardQueriesWithResult(shardQueries)
+}
+
 // Exec executes UPDATE query for shards.
 func (e *UpdateQueryExecutor) Exec() (sql.Result, error) {
 	query, ok := e.query.(*sqlparser.QueryBase)
@@ -38,12 +77,30 @@ func (e *UpdateQueryExecutor) Exec() (sql.Result, error) {
 		return nil, errors.New("cannot update row. sequencer's connection is nil")
 	}
 	if query.IsNotFoundShardKeyID() {
-		return nil, errors.New("cannot update row. not found shard_key column in this query")
+		if query.IsShardKeyUpdated() {
+			return nil, errors.New("cannot update shard_key column. not found shard_key column in WHERE clause")
+		}
+		return e.updateForAllShard(query)
+	}
+	if query.IsMultipleShardKeyIDs() {
+		if query.IsShardKeyUpdated() {
+			return nil, errors.New("cannot update shard_key column for multiple shard_key values")
+		}
+		return e.updateForShardKeys(query)
 	}
-	shardConn, err := e.conn.ShardConnectionByID(int64(query.ShardKeyID))
+	shardConn, err := e.conn.ShardConnectionByKey(query.ShardKeyID)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if query.IsShardKeyUpdated() {
+		newShardConn, err := e.conn.ShardConnectionByKey(query.NewShardKeyID)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if newShardConn.DSN() != shardConn.DSN() {
+			return e.relocate(query, shardConn, newShardConn)
+		}
+	}
 	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
 	result, err := e.exec(shardConn, query.Text, query.Args...)
 	if err != nil {
@@ -51,3 +108,100 @@ func (e *UpdateQueryExecutor) Exec() (sql.Result, error) {
 	}
 	return result.(sql.Result), nil
 }
+
+// relocate moves updated rows to the shard decided by new shard_key value.
+//
+// 1. exec update query to current shard
+// 2. select updated rows by new shard_key value from current shard
+// 3. insert selected rows to new shard
+// 4. delete selected rows from current shard
+//
+// These are executed by single transaction for both shards.
+// Rows are copied with all columns, so unique columns must be unique in all shards ( e.g. shard_column published by sequencer ).
+// If executor doesn't have transaction, new transaction is committed at the end.
+func (e *UpdateQueryExecutor) relocate(query *sqlparser.QueryBase, shardConn *connection.DBShardConnection, newShardConn *connection.DBShardConnection) (_ sql.Result, relocateErr error) {
+	debug.Printf("[WARN] update shard_key. relocate rows from %s to %s", shardConn.ShardName, newShardConn.ShardName)
+	tx := e.tx
+	if tx == nil {
+		tx = e.conn.Begin(e.ctx, nil)
+		defer func() {
+			if relocateErr != nil {
+				if err := tx.Rollback(); err != nil {
+					relocateErr = errors.Wrap(relocateErr, err.Error())
+				}
+				return
+			}
+			relocateErr = errors.WithStack(tx.Commit())
+		}()
+	}
+
+	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
+	updateResult, err := tx.Exec(e.ctx, shardConn, query.Text, query.Args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	affectedRows, err := updateResult.RowsAffected()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	tableName := query.Table()
+	shardKeyColumnName := e.conn.ShardKeyColumnName
+	if shardKeyColumnName == "" {
+		shardKeyColumnName = e.conn.ShardColumnName
+	}
+	newShardKeyID := query.NewShardKeyID.Value()
+	selectQuery := fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", tableName, shardKeyColumnName)
+	debug.Printf("(DB:%s):%s", shardConn.ShardName, selectQuery)
+	rows, err := tx.Query(e.ctx, shardConn, selectQuery, newShardKeyID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	columns, allValues, err := fetchAllValues(rows)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	placeholders := make([]string, len(columns))
+	for idx := range columns {
+		placeholders[idx] = "?"
+	}
+	insertQuery := fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s)", tableName, strings.Join(columns, ","), strings.Join(placeholders, ","))
+	for _, values := range allValues {
+		debug.Printf("(DB:%s):%s", newShardConn.ShardName, insertQuery)
+		if _, err := tx.Exec(e.ctx, newShardConn, insertQuery, values...); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", tableName, shardKeyColumnName)
+	debug.Printf("(DB:%s):%s", shardConn.ShardName, deleteQuery)
+	if _, err := tx.Exec(e.ctx, shardConn, deleteQuery, newShardKeyID); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &mergedResult{affectedRows: affectedRows}, nil
+}
+
+func fetchAllValues(rows *sql.Rows) ([]string, [][]interface{}, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	allValues := [][]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for idx := range values {
+			dest[idx] = &values[idx]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		allValues = append(allValues, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return columns, allValues, nil
+}
@@ -88,6 +88,57 @@ func TestInsertWithSequencerAndWithoutShardKey(t *testing.T) {
 	}
 }
 
+func TestInsertMultipleRowsWithSequencer(t *testing.T) {
+	_, result, err := Exec(db, "insert into users(id, name) values (null, 'alice'), (null, 'carol'), (null, 'dave')")
+	checkErr(t, err)
+	affectedRows, err := result.RowsAffected()
+	checkErr(t, err)
+	if affectedRows != 3 {
+		t.Fatal(errors.Errorf("invalid affected rows %d", affectedRows))
+	}
+	id, err := result.LastInsertId()
+	checkErr(t, err)
+	for idx, expected := range []string{"alice", "carol", "dave"} {
+		multiRows, _, err := Exec(db, fmt.Sprintf("select name from users where id = %d", id+int64(idx)))
+		checkErr(t, err)
+		if name := fetchUserName(multiRows); name != expected {
+			t.Fatal(errors.Errorf("cannot select from id = %d", id+int64(idx)))
+		}
+	}
+}
+
+func TestReplaceWithSequencer(t *testing.T) {
+	_, result, err := Exec(db, "insert into users(id, name) values (null, 'bob')")
+	checkErr(t, err)
+	id, err := result.LastInsertId()
+	checkErr(t, err)
+	currentID, err := db.ConnectionManager().CurrentSequenceID("users")
+	checkErr(t, err)
+	tx, err := db.Begin()
+	checkErr(t, err)
+	result, err = tx.Exec("replace into users(id, name) values (?, 'robert')", id)
+	checkErr(t, err)
+	if len(tx.WriteQueries()) != 1 {
+		t.Fatal(errors.New("cannot add replace query to write queries"))
+	}
+	checkErr(t, tx.Commit())
+	replacedID, err := result.LastInsertId()
+	checkErr(t, err)
+	if replacedID != id {
+		t.Fatal(errors.Errorf("invalid last insert id %d", replacedID))
+	}
+	nextID, err := db.ConnectionManager().CurrentSequenceID("users")
+	checkErr(t, err)
+	if nextID != currentID {
+		t.Fatal(errors.New("sequence id is published by replace query with id"))
+	}
+	multiRows, _, err := Exec(db, fmt.Sprintf("select name from users where id = %d", id))
+	checkErr(t, err)
+	if name := fetchUserName(multiRows); name != "robert" {
+		t.Fatal(errors.Errorf("cannot replace row. name = %s", name))
+	}
+}
+
 func TestDropTableWithoutSequencer(t *testing.T) {
 	_, _, err := Exec(db, "drop table if exists user_items")
 	checkErr(t, err)
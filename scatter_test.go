@@ -0,0 +1,698 @@
+package octillery
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aokabi/octillery/database/sql"
+	"github.com/aokabi/octillery/path"
+)
+
+func initializeScatterTable(t *testing.T) *sql.DB {
+	if err := LoadConfig(filepath.Join(path.ThisDirPath(), "test_databases.yml")); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := db.Exec("DROP TABLE IF EXISTS user_items"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS user_items(
+    id integer NOT NULL PRIMARY KEY autoincrement,
+    user_id integer NOT NULL,
+    score integer NOT NULL
+)`); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for userID := 1; userID <= 20; userID++ {
+		query := fmt.Sprintf("INSERT INTO user_items(id, user_id, score) VALUES (null, %d, %d)", userID, (userID*7)%20)
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	return db
+}
+
+func fetchUserIDs(t *testing.T, db *sql.DB, query string, args ...interface{}) []int64 {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer rows.Close()
+	userIDs := []int64{}
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	return userIDs
+}
+
+func TestScatterSelectWithOrderByAndLimit(t *testing.T) {
+	db := initializeScatterTable(t)
+	t.Run("order by", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_items ORDER BY user_id DESC")
+		if len(userIDs) != 20 {
+			t.Fatalf("invalid row num %d", len(userIDs))
+		}
+		for idx, userID := range userIDs {
+			if userID != int64(20-idx) {
+				t.Fatalf("cannot merge rows by order. %v", userIDs)
+			}
+		}
+	})
+	t.Run("order by column not in select list", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_items ORDER BY score, user_id LIMIT 3")
+		expected := []int64{20, 3, 6}
+		if !reflect.DeepEqual(userIDs, expected) {
+			t.Fatalf("expected %v but got %v", expected, userIDs)
+		}
+	})
+	t.Run("limit and offset with placeholder", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_items WHERE score > ? ORDER BY user_id LIMIT ?, ?", int64(0), 2, 3)
+		expected := []int64{3, 4, 5}
+		if !reflect.DeepEqual(userIDs, expected) {
+			t.Fatalf("expected %v but got %v", expected, userIDs)
+		}
+	})
+	t.Run("limit without order by", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_items LIMIT 5 OFFSET 1")
+		if len(userIDs) != 5 {
+			t.Fatalf("invalid row num %d", len(userIDs))
+		}
+	})
+	t.Run("select all columns", func(t *testing.T) {
+		rows, err := db.Query("SELECT * FROM user_items ORDER BY score DESC LIMIT 1")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer rows.Close()
+		columns, err := rows.Columns()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if len(columns) != 3 {
+			t.Fatalf("invalid columns %v", columns)
+		}
+		if !rows.Next() {
+			t.Fatal("cannot fetch row")
+		}
+		var id, userID, score int64
+		if err := rows.Scan(&id, &userID, &score); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if score != 19 || userID != 17 {
+			t.Fatalf("cannot fetch row by order. user_id = %d score = %d", userID, score)
+		}
+	})
+}
+
+func TestScatterSelectOrderByText(t *testing.T) {
+	db := initializeScatterTable(t)
+	if _, err := db.Exec("DROP TABLE IF EXISTS user_items"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS user_items(
+    id integer NOT NULL PRIMARY KEY autoincrement,
+    user_id integer NOT NULL,
+    name varchar(255) NOT NULL
+)`); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for idx, name := range []string{"9", "10", "9a", "100", "a", "2", "99", "1a"} {
+		if _, err := db.Exec("INSERT INTO user_items(id, user_id, name) VALUES (null, ?, ?)", int64(idx+1), name); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	fetchNames := func(query string) []string {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer rows.Close()
+		names := []string{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+	t.Run("order by text column", func(t *testing.T) {
+		expected := []string{"10", "100", "1a", "2", "9", "99", "9a", "a"}
+		if names := fetchNames("SELECT name FROM user_items ORDER BY name"); !reflect.DeepEqual(names, expected) {
+			t.Fatalf("text must be merged by byte order. expected %v but got %v", expected, names)
+		}
+	})
+	t.Run("min and max of text column", func(t *testing.T) {
+		var min, max string
+		if err := db.QueryRow("SELECT MIN(name), MAX(name) FROM user_items").Scan(&min, &max); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if min != "10" || max != "a" {
+			t.Fatalf("cannot merge min and max of text. %s %s", min, max)
+		}
+	})
+}
+
+func TestScatterSelectWithAggregateFunctions(t *testing.T) {
+	db := initializeScatterTable(t)
+	t.Run("query row", func(t *testing.T) {
+		var count int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM user_items WHERE score >= ?", int64(10)).Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count != 10 {
+			t.Fatalf("invalid count %d", count)
+		}
+	})
+	t.Run("all aggregate functions", func(t *testing.T) {
+		rows, err := db.Query("SELECT COUNT(*), SUM(score), MIN(score), MAX(score), AVG(score) FROM user_items")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer rows.Close()
+		columns, err := rows.Columns()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if len(columns) != 5 {
+			t.Fatalf("invalid columns %v", columns)
+		}
+		var rowNum int
+		for rows.Next() {
+			var count, sum, min, max int64
+			var avg float64
+			if err := rows.Scan(&count, &sum, &min, &max, &avg); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if count != 20 || sum != 190 || min != 0 || max != 19 || avg != 9.5 {
+				t.Fatalf("cannot merge aggregate values. %d %d %d %d %f", count, sum, min, max, avg)
+			}
+			rowNum++
+		}
+		if rowNum != 1 {
+			t.Fatalf("invalid row num %d", rowNum)
+		}
+	})
+	t.Run("aggregate for empty result", func(t *testing.T) {
+		var count int64
+		var sum sql.NullInt64
+		if err := db.QueryRow("SELECT COUNT(*), SUM(score) FROM user_items WHERE score > 100").Scan(&count, &sum); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count != 0 || sum.Valid {
+			t.Fatalf("invalid aggregate values for empty result. %d %v", count, sum)
+		}
+	})
+	t.Run("aggregate function in expression", func(t *testing.T) {
+		for _, query := range []string{
+			"SELECT SUM(score) + 1 FROM user_items",
+			"SELECT COALESCE(MAX(score), 0) FROM user_items",
+			"SELECT score % 2 AS parity FROM user_items GROUP BY parity ORDER BY SUM(user_id) * 2",
+		} {
+			_, err := db.Query(query)
+			if err == nil {
+				t.Fatalf("cannot handle error by '%s'", query)
+			}
+			if !strings.Contains(err.Error(), "unsupported aggregate expression for scatter query") {
+				t.Fatalf("invalid error by '%s'. %+v", query, err)
+			}
+		}
+	})
+}
+
+func TestScatterSelectWithGroupBy(t *testing.T) {
+	db := initializeScatterTable(t)
+	t.Run("group by with aggregate functions", func(t *testing.T) {
+		rows, err := db.Query("SELECT score % 2 AS parity, COUNT(*), SUM(user_id) FROM user_items GROUP BY parity ORDER BY parity")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer rows.Close()
+		results := [][]int64{}
+		for rows.Next() {
+			var parity, count, sum int64
+			if err := rows.Scan(&parity, &count, &sum); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			results = append(results, []int64{parity, count, sum})
+		}
+		expected := [][]int64{{0, 10, 110}, {1, 10, 100}}
+		if !reflect.DeepEqual(results, expected) {
+			t.Fatalf("expected %v but got %v", expected, results)
+		}
+	})
+	t.Run("having", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT score % 2 AS parity FROM user_items GROUP BY parity HAVING SUM(user_id) > ?", int64(100))
+		expected := []int64{0}
+		if !reflect.DeepEqual(userIDs, expected) {
+			t.Fatalf("expected %v but got %v", expected, userIDs)
+		}
+	})
+	t.Run("order by aggregate value with limit", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT score % 2 AS parity FROM user_items GROUP BY parity ORDER BY SUM(user_id) LIMIT 1")
+		expected := []int64{1}
+		if !reflect.DeepEqual(userIDs, expected) {
+			t.Fatalf("expected %v but got %v", expected, userIDs)
+		}
+	})
+	t.Run("distinct", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT DISTINCT score % 2 FROM user_items ORDER BY score % 2 DESC")
+		expected := []int64{1, 0}
+		if !reflect.DeepEqual(userIDs, expected) {
+			t.Fatalf("expected %v but got %v", expected, userIDs)
+		}
+	})
+}
+
+func TestScatterQueryRow(t *testing.T) {
+	db := initializeScatterTable(t)
+	t.Run("first row", func(t *testing.T) {
+		var score int64
+		if err := db.QueryRow("SELECT score FROM user_items WHERE score >= ?", int64(19)).Scan(&score); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if score != 19 {
+			t.Fatalf("invalid score %d", score)
+		}
+	})
+	t.Run("merged row by order", func(t *testing.T) {
+		var userID int64
+		if err := db.QueryRow("SELECT user_id FROM user_items ORDER BY score DESC").Scan(&userID); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if userID != 17 {
+			t.Fatalf("invalid user_id %d", userID)
+		}
+	})
+	t.Run("no rows", func(t *testing.T) {
+		var userID int64
+		err := db.QueryRow("SELECT user_id FROM user_items WHERE score > 100").Scan(&userID)
+		if err != sql.ErrNoRows {
+			t.Fatalf("expected ErrNoRows but got %v", err)
+		}
+	})
+}
+
+func TestScatterQueryInParallel(t *testing.T) {
+	db := initializeScatterTable(t)
+	t.Run("deterministic order", func(t *testing.T) {
+		expected := fetchUserIDs(t, db, "SELECT user_id FROM user_items")
+		if len(expected) != 20 {
+			t.Fatalf("invalid row num %d", len(expected))
+		}
+		for i := 0; i < 10; i++ {
+			userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_items")
+			if !reflect.DeepEqual(userIDs, expected) {
+				t.Fatalf("expected %v but got %v", expected, userIDs)
+			}
+		}
+	})
+	t.Run("error for all shards", func(t *testing.T) {
+		if _, err := db.Query("SELECT unknown_column FROM user_items"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := db.QueryContext(ctx, "SELECT user_id FROM user_items"); err == nil {
+			t.Fatal("cannot handle canceled context")
+		}
+	})
+	t.Run("delete for all shards", func(t *testing.T) {
+		result, err := db.Exec("DELETE FROM user_items")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 20 {
+			t.Fatalf("invalid affected rows %d", affectedRows)
+		}
+	})
+}
+
+func TestScatterDeleteWithWhere(t *testing.T) {
+	db := initializeScatterTable(t)
+	countRows := func(t *testing.T) int64 {
+		var count int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM user_items").Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		return count
+	}
+	t.Run("delete", func(t *testing.T) {
+		result, err := db.Exec("DELETE FROM user_items WHERE score < ?", int64(5))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 5 {
+			t.Fatalf("invalid affected rows %d", affectedRows)
+		}
+		if count := countRows(t); count != 15 {
+			t.Fatalf("invalid row num %d", count)
+		}
+	})
+	t.Run("delete in transaction", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		result, err := tx.Exec("DELETE FROM user_items WHERE score >= ?", int64(15))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 5 {
+			t.Fatalf("invalid affected rows %d", affectedRows)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count := countRows(t); count != 15 {
+			t.Fatalf("invalid row num %d", count)
+		}
+	})
+	t.Run("delete with limit", func(t *testing.T) {
+		if _, err := db.Exec("DELETE FROM user_items WHERE score > 10 LIMIT 1"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("not allowed table", func(t *testing.T) {
+		if _, err := db.Exec("DELETE FROM user_decks WHERE deck_id = 1"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestScatterUpdate(t *testing.T) {
+	db := initializeScatterTable(t)
+	sumScore := func(t *testing.T) int64 {
+		var sum int64
+		if err := db.QueryRow("SELECT SUM(score) FROM user_items").Scan(&sum); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		return sum
+	}
+	t.Run("update", func(t *testing.T) {
+		result, err := db.Exec("UPDATE user_items SET score = score + 100 WHERE score < ?", int64(5))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 5 {
+			t.Fatalf("invalid affected rows %d", affectedRows)
+		}
+		if sum := sumScore(t); sum != 690 {
+			t.Fatalf("invalid sum of score %d", sum)
+		}
+	})
+	t.Run("update in transaction", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		result, err := tx.Exec("UPDATE user_items SET score = 0")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 20 {
+			t.Fatalf("invalid affected rows %d", affectedRows)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if sum := sumScore(t); sum != 690 {
+			t.Fatalf("invalid sum of score %d", sum)
+		}
+	})
+	t.Run("update with limit", func(t *testing.T) {
+		if _, err := db.Exec("UPDATE user_items SET score = 0 LIMIT 1"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("not allowed table", func(t *testing.T) {
+		if _, err := db.Exec("UPDATE user_decks SET deck_id = 1"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestUpdateShardKey(t *testing.T) {
+	db := initializeScatterTable(t)
+	// relocated row keeps id, so it must be unique for all shards
+	if _, err := db.Exec("UPDATE user_items SET id = user_id + 1000"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	conn, err := db.ConnectionManager().ConnectionByTableName("user_items")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	countRowsInShard := func(t *testing.T, userID int64) int64 {
+		shardConn, err := conn.ShardConnectionByID(userID)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		var count int64
+		if err := shardConn.Connection.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id = ?", userID).Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		return count
+	}
+	findOtherShardUserID := func(t *testing.T, userID int64, startUserID int64) int64 {
+		shardConn, err := conn.ShardConnectionByID(userID)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		for newUserID := startUserID; newUserID < startUserID+100; newUserID++ {
+			newShardConn, err := conn.ShardConnectionByID(newUserID)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if newShardConn.ShardName != shardConn.ShardName {
+				return newUserID
+			}
+		}
+		t.Fatal("cannot find user_id for other shard")
+		return 0
+	}
+	t.Run("relocate row", func(t *testing.T) {
+		newUserID := findOtherShardUserID(t, 1, 100)
+		result, err := db.Exec("UPDATE user_items SET user_id = ?, score = score + 1 WHERE user_id = ?", newUserID, int64(1))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 1 {
+			t.Fatalf("invalid affected rows %d", affectedRows)
+		}
+		if count := countRowsInShard(t, 1); count != 0 {
+			t.Fatalf("row is remained in old shard. count = %d", count)
+		}
+		if count := countRowsInShard(t, newUserID); count != 1 {
+			t.Fatalf("row is not found in new shard. count = %d", count)
+		}
+		var score int64
+		if err := db.QueryRow("SELECT score FROM user_items WHERE user_id = ?", newUserID).Scan(&score); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if score != 8 {
+			t.Fatalf("invalid score %d", score)
+		}
+	})
+	t.Run("relocate row in transaction", func(t *testing.T) {
+		newUserID := findOtherShardUserID(t, 2, 200)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Exec("UPDATE user_items SET user_id = ? WHERE user_id = ?", newUserID, int64(2)); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count := countRowsInShard(t, 2); count != 1 {
+			t.Fatalf("row is not found in old shard. count = %d", count)
+		}
+		if count := countRowsInShard(t, newUserID); count != 0 {
+			t.Fatalf("row is remained in new shard. count = %d", count)
+		}
+	})
+	t.Run("update shard key without shard key in where clause", func(t *testing.T) {
+		if _, err := db.Exec("UPDATE user_items SET user_id = 1 WHERE score = 0"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestInsertMultipleRows(t *testing.T) {
+	db := initializeScatterTable(t)
+	conn, err := db.ConnectionManager().ConnectionByTableName("user_items")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	result, err := db.Exec("INSERT INTO user_items(id, user_id, score) VALUES (null, 101, 1), (null, ?, 2), (null, 103, 3), (null, 104, 4)", int64(102))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if affectedRows != 4 {
+		t.Fatalf("invalid affected rows %d", affectedRows)
+	}
+	for userID := int64(101); userID <= 104; userID++ {
+		shardConn, err := conn.ShardConnectionByID(userID)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		var score int64
+		if err := shardConn.Connection.QueryRow("SELECT score FROM user_items WHERE user_id = ?", userID).Scan(&score); err != nil {
+			t.Fatalf("cannot find row in shard %s. %+v\n", shardConn.ShardName, err)
+		}
+		if score != userID-100 {
+			t.Fatalf("invalid score %d", score)
+		}
+	}
+	t.Run("rollback rows inserted to other shards by error", func(t *testing.T) {
+		firstShardConn, err := conn.ShardConnectionByID(201)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		userID := int64(202)
+		for ; ; userID++ {
+			shardConn, err := conn.ShardConnectionByID(userID)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if shardConn.ShardName == firstShardConn.ShardName {
+				continue
+			}
+			// duplicate primary key in the second shard
+			var id int64
+			if err := shardConn.Connection.QueryRow("SELECT MAX(id) FROM user_items").Scan(&id); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if _, err := db.Exec("INSERT INTO user_items(id, user_id, score) VALUES (null, 201, 1), (?, ?, 2)", id, userID); err == nil {
+				t.Fatal("cannot handle error")
+			}
+			break
+		}
+		var count int64
+		if err := firstShardConn.Connection.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id = 201").Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count != 0 {
+			t.Fatal("row inserted to other shard must be rollbacked")
+		}
+	})
+}
+
+func TestQueryByMultipleShardKeys(t *testing.T) {
+	db := initializeScatterTable(t)
+	t.Run("select with in", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_items WHERE user_id IN (?, ?, ?, ?) ORDER BY user_id", int64(3), int64(1), int64(4), int64(2))
+		if !reflect.DeepEqual(userIDs, []int64{1, 2, 3, 4}) {
+			t.Fatalf("invalid user_ids %v", userIDs)
+		}
+	})
+	t.Run("select with or", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_items WHERE (user_id = 5 OR user_id = ?) AND score > ? ORDER BY user_id DESC", int64(6), int64(0))
+		if !reflect.DeepEqual(userIDs, []int64{6, 5}) {
+			t.Fatalf("invalid user_ids %v", userIDs)
+		}
+	})
+	t.Run("query row with in", func(t *testing.T) {
+		var count int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id IN (1, 2, 3, 30)").Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count != 3 {
+			t.Fatalf("invalid count %d", count)
+		}
+	})
+	t.Run("update with in", func(t *testing.T) {
+		result, err := db.Exec("UPDATE user_items SET score = 100 WHERE user_id IN (?, ?, ?)", int64(7), int64(8), int64(9))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 3 {
+			t.Fatalf("invalid affected rows %d", affectedRows)
+		}
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_items WHERE score = 100 ORDER BY user_id")
+		if !reflect.DeepEqual(userIDs, []int64{7, 8, 9}) {
+			t.Fatalf("invalid user_ids %v", userIDs)
+		}
+	})
+	t.Run("update shard_key with in", func(t *testing.T) {
+		if _, err := db.Exec("UPDATE user_items SET user_id = 30 WHERE user_id IN (7, 8)"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("delete with in", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		result, err := tx.Exec("DELETE FROM user_items WHERE user_id IN (10, 11) OR user_id = 12")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 3 {
+			t.Fatalf("invalid affected rows %d", affectedRows)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_items WHERE user_id IN (9, 10, 11, 12, 13) ORDER BY user_id")
+		if !reflect.DeepEqual(userIDs, []int64{9, 13}) {
+			t.Fatalf("invalid user_ids %v", userIDs)
+		}
+	})
+}
@@ -0,0 +1,280 @@
+package octillery
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aokabi/octillery/algorithm"
+	"github.com/aokabi/octillery/database/sql"
+	"github.com/aokabi/octillery/path"
+)
+
+func TestLookupSharding(t *testing.T) {
+	if err := LoadConfig(filepath.Join(path.ThisDirPath(), "test_databases.yml")); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer db.Close()
+	for _, query := range []string{
+		"DROP TABLE IF EXISTS user_shard_lookups",
+		"CREATE TABLE IF NOT EXISTS user_shard_lookups(user_id integer NOT NULL PRIMARY KEY, shard_name varchar(255) NOT NULL)",
+		"INSERT INTO user_shard_lookups(user_id, shard_name) VALUES (2, 'user_profile_shard_2')",
+		"DROP TABLE IF EXISTS user_profiles",
+		"CREATE TABLE IF NOT EXISTS user_profiles(id integer NOT NULL PRIMARY KEY autoincrement, user_id integer NOT NULL)",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	conn, err := db.ConnectionManager().ConnectionByTableName("user_profiles")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for _, tc := range []struct {
+		userID    int64
+		shardName string
+	}{
+		{2, "user_profile_shard_2"}, // mapped by lookup table
+		{4, "user_profile_shard_1"}, // fallback to modulo
+		{3, "user_profile_shard_2"}, // fallback to modulo
+	} {
+		shardConn, err := conn.ShardConnectionByID(tc.userID)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if shardConn.ShardName != tc.shardName {
+			t.Fatalf("invalid shard %s for user_id %d", shardConn.ShardName, tc.userID)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO user_shard_lookups(user_id, shard_name) VALUES (4, 'user_profile_shard_2')"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	shardConn, err := conn.ShardConnectionByID(4)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if shardConn.ShardName != "user_profile_shard_2" {
+		t.Fatalf("missing key is cached. shard = %s", shardConn.ShardName)
+	}
+	if _, err := db.Exec("INSERT INTO user_profiles(id, user_id) VALUES (null, ?)", int64(2)); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var count int64
+	if err := db.QueryRow("SELECT count(*) FROM user_profiles WHERE user_id = ?", int64(2)).Scan(&count); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if count != 1 {
+		t.Fatalf("cannot find inserted row. count = %d", count)
+	}
+}
+
+func TestStringShardKey(t *testing.T) {
+	if err := LoadConfig(filepath.Join(path.ThisDirPath(), "test_databases.yml")); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer db.Close()
+	for _, query := range []string{
+		"DROP TABLE IF EXISTS user_accounts",
+		"CREATE TABLE IF NOT EXISTS user_accounts(id integer NOT NULL PRIMARY KEY autoincrement, email varchar(255) NOT NULL, name varchar(255) NOT NULL)",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	emails := []string{"alice@example.com", "bob@example.com", "carol@example.com", "dave@example.com"}
+	for _, email := range emails {
+		if _, err := db.Exec("INSERT INTO user_accounts(id, email, name) VALUES (null, ?, 'user')", email); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	if _, err := db.Exec("UPDATE user_accounts SET name = 'bob' WHERE email = ?", "bob@example.com"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM user_accounts WHERE email = 'bob@example.com'").Scan(&name); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if name != "bob" {
+		t.Fatalf("cannot update row by string shard_key. name = %s", name)
+	}
+	conn, err := db.ConnectionManager().ConnectionByTableName("user_accounts")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for _, email := range emails {
+		shardConn, err := conn.ShardConnectionByKey(algorithm.NewStringKey(email))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		var count int
+		if err := shardConn.Connection.QueryRow("SELECT count(*) FROM user_accounts WHERE email = ?", email).Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count != 1 {
+			t.Fatalf("%s is not inserted to %s", email, shardConn.ShardName)
+		}
+	}
+	result, err := db.Exec("DELETE FROM user_accounts WHERE email = ?", "alice@example.com")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		t.Fatalf("cannot delete row by string shard_key. affected = %d", affected)
+	}
+}
+
+func TestCompositeShardKey(t *testing.T) {
+	if err := LoadConfig(filepath.Join(path.ThisDirPath(), "test_databases.yml")); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer db.Close()
+	for _, query := range []string{
+		"DROP TABLE IF EXISTS user_region_items",
+		"CREATE TABLE IF NOT EXISTS user_region_items(id integer NOT NULL PRIMARY KEY autoincrement, region_id integer NOT NULL, user_id integer NOT NULL)",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	for regionID := int64(1); regionID <= 3; regionID++ {
+		for userID := int64(1); userID <= 3; userID++ {
+			if _, err := db.Exec("INSERT INTO user_region_items(id, region_id, user_id) VALUES (null, ?, ?)", regionID, userID); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+		}
+	}
+	conn, err := db.ConnectionManager().ConnectionByTableName("user_region_items")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	key := algorithm.NewCompositeKey(algorithm.NewIntKey(2), algorithm.NewIntKey(3))
+	shardConn, err := conn.ShardConnectionByKey(key)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var count int
+	if err := shardConn.Connection.QueryRow("SELECT count(*) FROM user_region_items WHERE region_id = 2 AND user_id = 3").Scan(&count); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if count != 1 {
+		t.Fatalf("row is not inserted to %s", shardConn.ShardName)
+	}
+	t.Run("all columns", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_region_items WHERE region_id = ? AND user_id = ?", int64(2), int64(3))
+		if len(userIDs) != 1 || userIDs[0] != 3 {
+			t.Fatalf("invalid rows %v", userIDs)
+		}
+	})
+	t.Run("part of columns", func(t *testing.T) {
+		userIDs := fetchUserIDs(t, db, "SELECT user_id FROM user_region_items WHERE user_id = ? ORDER BY region_id", int64(3))
+		if len(userIDs) != 3 {
+			t.Fatalf("cannot query for all shards. %v", userIDs)
+		}
+	})
+}
+
+func TestRangeShardPruning(t *testing.T) {
+	if err := LoadConfig(filepath.Join(path.ThisDirPath(), "test_databases.yml")); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer db.Close()
+	for _, query := range []string{
+		"DROP TABLE IF EXISTS user_logs",
+		"CREATE TABLE IF NOT EXISTS user_logs(id integer NOT NULL PRIMARY KEY, message varchar(255) NOT NULL)",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	for _, id := range []int64{1, 999, 1000, 1500} {
+		if _, err := db.Exec("INSERT INTO user_logs(id, message) VALUES (?, 'log')", id); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	for _, tc := range []struct {
+		query string
+		args  []interface{}
+		ids   []int64
+	}{
+		{"SELECT id FROM user_logs WHERE id BETWEEN ? AND ? ORDER BY id", []interface{}{int64(990), int64(1200)}, []int64{999, 1000}},
+		{"SELECT id FROM user_logs WHERE id >= ? AND id < ? ORDER BY id", []interface{}{int64(1), int64(1000)}, []int64{1, 999}},
+		{"SELECT id FROM user_logs WHERE id > 1000 ORDER BY id", nil, []int64{1500}},
+	} {
+		if ids := fetchUserIDs(t, db, tc.query, tc.args...); !reflect.DeepEqual(ids, tc.ids) {
+			t.Fatalf("invalid ids %v by '%s'", ids, tc.query)
+		}
+	}
+}
+
+func TestTimeBucketSharding(t *testing.T) {
+	if err := LoadConfig(filepath.Join(path.ThisDirPath(), "test_databases.yml")); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer db.Close()
+	for _, query := range []string{
+		"DROP TABLE IF EXISTS user_events",
+		"CREATE TABLE IF NOT EXISTS user_events(id integer NOT NULL PRIMARY KEY autoincrement, created_at datetime NOT NULL, name varchar(255) NOT NULL)",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	conn, err := db.ConnectionManager().ConnectionByTableName("user_events")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for _, tc := range []struct {
+		createdAt time.Time
+		shardName string
+	}{
+		{time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC), "user_event_shard_1"},
+		{time.Date(2024, 2, 10, 10, 0, 0, 0, time.UTC), "user_event_shard_2"},
+		{time.Date(2024, 4, 10, 10, 0, 0, 0, time.UTC), "user_event_shard_1"},
+	} {
+		if _, err := db.Exec("INSERT INTO user_events(id, created_at, name) VALUES (null, ?, 'login')", tc.createdAt); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		shardConn, err := conn.ShardConnectionByKey(algorithm.NewTimeKey(tc.createdAt))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if shardConn.ShardName != tc.shardName {
+			t.Fatalf("invalid shard %s for %s", shardConn.ShardName, tc.createdAt)
+		}
+		var count int64
+		if err := shardConn.Connection.QueryRow("SELECT count(*) FROM user_events WHERE created_at = ?", tc.createdAt.Format("2006-01-02 15:04:05")).Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count != 1 {
+			t.Fatalf("cannot find inserted row in %s", tc.shardName)
+		}
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM user_events WHERE created_at = '2024-02-10 10:00:00'").Scan(&name); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if name != "login" {
+		t.Fatalf("invalid name %s", name)
+	}
+}
@@ -1,15 +1,21 @@
 package sqlparser
 
 import (
+	"regexp"
+	"strconv"
+
 	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/algorithm"
 )
 
-// Identifier the type for sharding key
-type Identifier int64
+// Identifier the type for sharding key.
+// It holds integer, string or []byte value.
+type Identifier = algorithm.ShardKey
 
-const (
+var (
 	// UnknownID the identifier of default sharding key
-	UnknownID Identifier = -1
+	UnknownID = Identifier{}
 )
 
 // QueryType the type of SQL/DDL ( Select, Insert, Update, Delet, ...)
@@ -74,10 +80,11 @@ type Query interface {
 // this is used by query that excluded INSERT or DELETE.
 func NewQueryBase(stmt vtparser.Statement, query string, args []interface{}) *QueryBase {
 	return &QueryBase{
-		Text:       query,
-		Args:       args,
-		Stmt:       stmt,
-		ShardKeyID: UnknownID,
+		Text:          query,
+		Args:          args,
+		Stmt:          stmt,
+		ShardKeyID:    UnknownID,
+		NewShardKeyID: UnknownID,
 	}
 }
 
@@ -90,6 +97,23 @@ type QueryBase struct {
 	ShardKeyID                 Identifier
 	ShardKeyIDPlaceholderIndex int
 	Stmt                       vtparser.Statement
+
+	// ShardKeyIDs has sharding keys found by IN or OR conditions in WHERE clause.
+	// It is set only if multiple keys are found, and ShardKeyID is UnknownID at that time.
+	ShardKeyIDs []Identifier
+
+	// ShardKeyRange is the range of integer shard_key found by conditions like BETWEEN in WHERE clause.
+	// It is set only if sharding key isn't found.
+	ShardKeyRange *algorithm.ShardKeyRange
+
+	// NewShardKeyID is the value assigned to shard_key column by UPDATE query.
+	NewShardKeyID Identifier
+
+	// shardKeyInExprs has IN conditions for shard_key column to prune values for each shard.
+	shardKeyInExprs []*shardKeyInExpr
+
+	// shardKeyValues has value of each column for composite shard_key.
+	shardKeyValues map[string]Identifier
 }
 
 // Table returns table name
@@ -102,9 +126,104 @@ func (q *QueryBase) QueryType() QueryType {
 	return q.Type
 }
 
+// shardKeyInExpr is IN condition for shard_key column.
+// keys are values of tuple converted to sharding key.
+type shardKeyInExpr struct {
+	expr  *vtparser.ComparisonExpr
+	tuple vtparser.ValTuple
+	keys  []Identifier
+}
+
 // IsNotFoundShardKeyID returns whether sharding key is found in SQL
 func (q *QueryBase) IsNotFoundShardKeyID() bool {
-	return q.ShardKeyID == UnknownID
+	return q.ShardKeyID == UnknownID && len(q.ShardKeyIDs) == 0
+}
+
+// IsMultipleShardKeyIDs returns whether multiple sharding keys are found in SQL
+func (q *QueryBase) IsMultipleShardKeyIDs() bool {
+	return len(q.ShardKeyIDs) > 0
+}
+
+// IsShardKeyUpdated returns whether UPDATE query assigns new value to shard_key column
+func (q *QueryBase) IsShardKeyUpdated() bool {
+	return q.NewShardKeyID != UnknownID
+}
+
+// ArgByValArg returns query argument referenced by placeholder value.
+func (q *QueryBase) ArgByValArg(val *vtparser.SQLVal) (interface{}, error) {
+	index := valArgIndex(val)
+	if index == 0 || len(q.Args) < index {
+		return nil, errors.Errorf("cannot find query argument for placeholder %s", string(val.Val))
+	}
+	return q.Args[index-1], nil
+}
+
+// StringWithArgs formats statement as SQL text with '?' placeholders.
+// It returns query arguments sorted by order of placeholders in formatted text,
+// so the statement is able to be rewritten before calling this.
+func (q *QueryBase) StringWithArgs(stmt vtparser.SQLNode) (string, []interface{}, error) {
+	args := []interface{}{}
+	var formatErr error
+	buf := vtparser.NewTrackedBuffer(func(buf *vtparser.TrackedBuffer, node vtparser.SQLNode) {
+		val, ok := node.(*vtparser.SQLVal)
+		if !ok || val.Type != vtparser.ValArg {
+			node.Format(buf)
+			return
+		}
+		arg, err := q.ArgByValArg(val)
+		if err != nil {
+			formatErr = err
+			return
+		}
+		args = append(args, arg)
+		buf.Myprintf("%s", "?")
+	})
+	buf.Myprintf("%v", stmt)
+	if formatErr != nil {
+		return "", nil, errors.WithStack(formatErr)
+	}
+	return buf.String(), args, nil
+}
+
+// StringWithArgsForShardKeys formats statement like StringWithArgs,
+// but values of IN condition for shard_key column are pruned to specified keys.
+// This is used to build query for the shard that has a part of ShardKeyIDs.
+// Statement is temporarily rewritten, so this mustn't be called concurrently.
+func (q *QueryBase) StringWithArgsForShardKeys(stmt vtparser.SQLNode, keys []Identifier) (string, []interface{}, error) {
+	keySet := map[Identifier]struct{}{}
+	for _, key := range keys {
+		keySet[key] = struct{}{}
+	}
+	defer func() {
+		for _, inExpr := range q.shardKeyInExprs {
+			inExpr.expr.Right = inExpr.tuple
+		}
+	}()
+	for _, inExpr := range q.shardKeyInExprs {
+		tuple := vtparser.ValTuple{}
+		for idx, key := range inExpr.keys {
+			if _, exists := keySet[key]; exists {
+				tuple = append(tuple, inExpr.tuple[idx])
+			}
+		}
+		if len(tuple) == 0 {
+			// IN condition joined by OR may not have keys for this shard
+			continue
+		}
+		inExpr.expr.Right = tuple
+	}
+	return q.StringWithArgs(stmt)
+}
+
+var valArgPattern = regexp.MustCompile(`:v([0-9]+)`)
+
+func valArgIndex(val *vtparser.SQLVal) int {
+	results := valArgPattern.FindAllStringSubmatch(string(val.Val), -1)
+	if len(results) > 0 && len(results[0]) > 1 {
+		index, _ := strconv.Atoi(results[0][1])
+		return index
+	}
+	return 0
 }
 
 // InsertQuery a implementation of Query interface.
@@ -112,27 +231,50 @@ type InsertQuery struct {
 	*QueryBase
 	Stmt           *vtparser.Insert
 	ColumnValues   []func() *vtparser.SQLVal
-	nextSequenceID Identifier
+	nextSequenceID int64
+
+	// RowQueries has query for each row if multiple rows are inserted.
+	RowQueries []*InsertQuery
+
+	// shardColumnValue is the value of shard_column specified by REPLACE or ON DUPLICATE KEY UPDATE query.
+	shardColumnValue Identifier
 }
 
 // NewInsertQuery creates instance of InsertQuery structure.
 func NewInsertQuery(queryBase *QueryBase, stmt *vtparser.Insert) *InsertQuery {
 	values := stmt.Rows.(vtparser.Values)
 	return &InsertQuery{
-		QueryBase:    queryBase,
-		Stmt:         stmt,
-		ColumnValues: make([]func() *vtparser.SQLVal, len(values[0])),
+		QueryBase:        queryBase,
+		Stmt:             stmt,
+		ColumnValues:     make([]func() *vtparser.SQLVal, len(values[0])),
+		shardColumnValue: UnknownID,
 	}
 }
 
+// IsUpsert returns whether query is REPLACE or INSERT ... ON DUPLICATE KEY UPDATE.
+func (q *InsertQuery) IsUpsert() bool {
+	return q.Stmt.Action == vtparser.ReplaceStr || len(q.Stmt.OnDup) > 0
+}
+
+// IsReplace returns whether query is REPLACE.
+func (q *InsertQuery) IsReplace() bool {
+	return q.Stmt.Action == vtparser.ReplaceStr
+}
+
+// ShardColumnValue returns the value of shard_column specified by upsert query.
+// If it isn't specified, sequencer publishes new id.
+func (q *InsertQuery) ShardColumnValue() (Identifier, bool) {
+	return q.shardColumnValue, q.shardColumnValue != UnknownID
+}
+
 // NextSequenceID get next unique id value generated by sequencer.
 func (q *InsertQuery) NextSequenceID() Identifier {
-	return q.nextSequenceID
+	return algorithm.NewIntKey(q.nextSequenceID)
 }
 
 // SetNextSequenceID set unique id value generated by sequencer.
 func (q *InsertQuery) SetNextSequenceID(id int64) {
-	q.nextSequenceID = Identifier(id)
+	q.nextSequenceID = id
 }
 
 // String returns formatted text.
@@ -148,6 +290,47 @@ func (q *InsertQuery) String() string {
 	return vtparser.String(q.Stmt)
 }
 
+// IsMultiRows returns whether multiple rows are inserted by this query.
+func (q *InsertQuery) IsMultiRows() bool {
+	return len(q.RowQueries) > 1
+}
+
+// ValTuple returns values of first row.
+// If value includes variable like placeholder, replace it.
+func (q *InsertQuery) ValTuple() vtparser.ValTuple {
+	row := q.Stmt.Rows.(vtparser.Values)[0]
+	tuple := make(vtparser.ValTuple, len(row))
+	copy(tuple, row)
+	for idx, columnValue := range q.ColumnValues {
+		if columnValue == nil {
+			continue
+		}
+		tuple[idx] = columnValue()
+	}
+	return tuple
+}
+
+// TextAndArgs returns formatted text and query arguments.
+// Values of row are replaced like String(), and remained placeholders ( e.g. in ON DUPLICATE KEY UPDATE clause ) are returned with arguments.
+func (q *InsertQuery) TextAndArgs() (string, []interface{}, error) {
+	return q.TextAndArgsWithRowQueries([]*InsertQuery{q})
+}
+
+// TextAndArgsWithRowQueries returns formatted text and query arguments that inserts rows of specified queries.
+func (q *InsertQuery) TextAndArgsWithRowQueries(rowQueries []*InsertQuery) (string, []interface{}, error) {
+	stmt := *q.Stmt
+	values := vtparser.Values{}
+	for _, rowQuery := range rowQueries {
+		values = append(values, rowQuery.ValTuple())
+	}
+	stmt.Rows = values
+	text, args, err := q.StringWithArgs(&stmt)
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+	return text, args, nil
+}
+
 // DeleteQuery a implementation of Query interface.
 type DeleteQuery struct {
 	*QueryBase
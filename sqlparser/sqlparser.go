@@ -9,6 +9,7 @@ import (
 
 	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/algorithm"
 	"github.com/aokabi/octillery/config"
 	"github.com/aokabi/octillery/debug"
 )
@@ -35,14 +36,27 @@ func (p *Parser) shardColumnName(tableName string) string {
 	return p.cfg.ShardColumnName(tableName)
 }
 
-func (p *Parser) shardKeyColumnName(tableName string) string {
-	return p.cfg.ShardKeyColumnName(tableName)
+func (p *Parser) shardKeyColumnNames(tableName string) []string {
+	return p.cfg.ShardKeyColumnNames(tableName)
+}
+
+func (p *Parser) isCompositeShardKey(tableName string) bool {
+	return len(p.shardKeyColumnNames(tableName)) > 1
+}
+
+func (p *Parser) isShardKeyColumnName(tableName string, colName string) bool {
+	for _, shardKeyColumnName := range p.shardKeyColumnNames(tableName) {
+		if shardKeyColumnName == colName {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *Parser) isShardKeyColumn(valExpr vtparser.Expr, queryBase *QueryBase) bool {
 	switch expr := valExpr.(type) {
 	case *vtparser.ColName:
-		if p.shardKeyColumnName(queryBase.TableName) == expr.Name.String() {
+		if p.isShardKeyColumnName(queryBase.TableName, expr.Name.String()) {
 			return true
 		}
 	default:
@@ -51,6 +65,29 @@ func (p *Parser) isShardKeyColumn(valExpr vtparser.Expr, queryBase *QueryBase) b
 	return false
 }
 
+// setShardKeyValue sets value of shard_key column.
+// If table has composite shard_key, ShardKeyID is decided after values of all shard_key columns are found.
+func (p *Parser) setShardKeyValue(queryBase *QueryBase, colName string, value Identifier) {
+	columnNames := p.shardKeyColumnNames(queryBase.TableName)
+	if len(columnNames) < 2 {
+		queryBase.ShardKeyID = value
+		return
+	}
+	if queryBase.shardKeyValues == nil {
+		queryBase.shardKeyValues = map[string]Identifier{}
+	}
+	queryBase.shardKeyValues[colName] = value
+	values := make([]Identifier, 0, len(columnNames))
+	for _, columnName := range columnNames {
+		value, exists := queryBase.shardKeyValues[columnName]
+		if !exists {
+			return
+		}
+		values = append(values, value)
+	}
+	queryBase.ShardKeyID = algorithm.NewCompositeKey(values...)
+}
+
 func (p *Parser) ValueIndexByValArg(arg *vtparser.SQLVal) int {
 	r := regexp.MustCompile(`:v([0-9]+)`)
 	debug.Printf("ValArg: %s", string(arg.Val))
@@ -74,72 +111,360 @@ func (p *Parser) parseShardColumnPlaceholderIndex(valExpr vtparser.Expr) int {
 	return 0
 }
 
-func (p *Parser) parseVal(val *vtparser.SQLVal, queryBase *QueryBase) error {
-	if val.Type != vtparser.ValArg {
-		id, err := strconv.Atoi(string(val.Val))
+// shardKeyBySQLVal creates sharding key by literal value in query.
+func shardKeyBySQLVal(val *vtparser.SQLVal) (Identifier, error) {
+	switch val.Type {
+	case vtparser.IntVal:
+		if id, err := strconv.ParseInt(string(val.Val), 10, 64); err == nil {
+			return algorithm.NewIntKey(id), nil
+		}
+		id, err := strconv.ParseUint(string(val.Val), 10, 64)
 		if err != nil {
-			return errors.WithStack(err)
+			return UnknownID, errors.WithStack(err)
 		}
-		queryBase.ShardKeyID = Identifier(id)
-		return nil
+		return algorithm.NewUintKey(id), nil
+	case vtparser.StrVal:
+		return algorithm.NewStringKey(string(val.Val)), nil
+	}
+	return UnknownID, errors.Errorf("unsupport shard_key value %s", string(val.Val))
+}
+
+// parseShardKeyValue returns the value of shard_key column specified by expr.
+// If value is provided by query argument, it also returns the index of placeholder ( 1 origin ).
+// If query argument isn't passed, returned value is UnknownID.
+func (p *Parser) parseShardKeyValue(expr vtparser.Expr, args []interface{}) (Identifier, int, error) {
+	switch valExpr := expr.(type) {
+	case *vtparser.SQLVal:
+		if valExpr.Type != vtparser.ValArg {
+			key, err := shardKeyBySQLVal(valExpr)
+			if err != nil {
+				return UnknownID, 0, errors.WithStack(err)
+			}
+			return key, 0, nil
+		}
+		placeholderIndex := p.parseShardColumnPlaceholderIndex(valExpr)
+		if placeholderIndex == 0 {
+			return UnknownID, 0, errors.New("cannot parse shard_key column provided by query argument")
+		}
+		if len(args) < placeholderIndex {
+			return UnknownID, placeholderIndex, nil
+		}
+		key, err := algorithm.NewShardKey(args[placeholderIndex-1])
+		if err != nil {
+			return UnknownID, 0, errors.WithStack(err)
+		}
+		return key, placeholderIndex, nil
+	case *vtparser.ParenExpr:
+		return p.parseShardKeyValue(valExpr.Expr, args)
+	default:
 	}
+	return UnknownID, 0, errors.Errorf("parse error. expr type '%s' does not supported", reflect.TypeOf(expr))
+}
+
+// shardKeyAssignment has values of shard_key columns that satisfy a condition in WHERE clause.
+type shardKeyAssignment map[string]Identifier
 
-	placeholderIndex := p.parseShardColumnPlaceholderIndex(val)
-	if placeholderIndex == 0 {
-		return errors.New("cannot parse shard_key column provided by query argument")
+// maxShardKeyAssignmentNum is the limit of combinations of shard_key values found in WHERE clause.
+// If combinations exceed it, query is executed for all shards.
+const maxShardKeyAssignmentNum = 1000
+
+// unrestrictedShardKeyAssignments returns the condition that doesn't restrict shard_key columns.
+func unrestrictedShardKeyAssignments() []shardKeyAssignment {
+	return []shardKeyAssignment{{}}
+}
+
+func (a shardKeyAssignment) merge(other shardKeyAssignment) (shardKeyAssignment, bool) {
+	merged := shardKeyAssignment{}
+	for columnName, value := range a {
+		merged[columnName] = value
 	}
-	queryBase.ShardKeyIDPlaceholderIndex = placeholderIndex
-	if len(queryBase.Args) >= placeholderIndex {
-		arg := queryBase.Args[placeholderIndex-1]
-		switch argType := arg.(type) {
-		case int, int8, int16, int32, int64:
-			queryBase.ShardKeyID = Identifier(argType.(int64))
-		case uint, uint8, uint16, uint32, uint64:
-			queryBase.ShardKeyID = Identifier(argType.(uint64))
-		default:
-			return errors.Errorf("unsupport shard_key type %s", reflect.TypeOf(arg))
+	for columnName, value := range other {
+		if mergedValue, exists := merged[columnName]; exists && mergedValue != value {
+			return nil, false
 		}
+		merged[columnName] = value
 	}
-	return nil
+	return merged, true
 }
 
-func (p *Parser) parseExpr(expr vtparser.Expr, queryBase *QueryBase) error {
-	switch valExpr := expr.(type) {
-	case *vtparser.SQLVal:
-		// directly includes shard_key id in query
-		if err := p.parseVal(valExpr, queryBase); err != nil {
-			return errors.WithStack(err)
+func andShardKeyAssignments(left []shardKeyAssignment, right []shardKeyAssignment) []shardKeyAssignment {
+	assignments := []shardKeyAssignment{}
+	for _, leftAssignment := range left {
+		for _, rightAssignment := range right {
+			merged, ok := leftAssignment.merge(rightAssignment)
+			if !ok {
+				continue
+			}
+			assignments = append(assignments, merged)
+			if len(assignments) > maxShardKeyAssignmentNum {
+				return unrestrictedShardKeyAssignments()
+			}
 		}
+	}
+	if len(assignments) == 0 {
+		// conditions conflict with each other. it is left to shards
+		return unrestrictedShardKeyAssignments()
+	}
+	return assignments
+}
+
+func orShardKeyAssignments(left []shardKeyAssignment, right []shardKeyAssignment) []shardKeyAssignment {
+	if len(left)+len(right) > maxShardKeyAssignmentNum {
+		return unrestrictedShardKeyAssignments()
+	}
+	assignments := make([]shardKeyAssignment, 0, len(left)+len(right))
+	assignments = append(assignments, left...)
+	return append(assignments, right...)
+}
+
+// parseExpr returns combinations of shard_key values that satisfy expr.
+// Conditions joined by AND are merged, and conditions joined by OR are collected.
+// Unsupported conditions ( e.g. NOT, '<', '>' ) don't restrict shard_key columns.
+func (p *Parser) parseExpr(expr vtparser.Expr, queryBase *QueryBase) ([]shardKeyAssignment, error) {
+	switch valExpr := expr.(type) {
 	case *vtparser.AndExpr:
-		if err := p.parseExpr(valExpr.Left, queryBase); err != nil {
-			return errors.WithStack(err)
+		left, err := p.parseExpr(valExpr.Left, queryBase)
+		if err != nil {
+			return nil, errors.WithStack(err)
 		}
-		if err := p.parseExpr(valExpr.Right, queryBase); err != nil {
-			return errors.WithStack(err)
+		right, err := p.parseExpr(valExpr.Right, queryBase)
+		if err != nil {
+			return nil, errors.WithStack(err)
 		}
+		return andShardKeyAssignments(left, right), nil
+	case *vtparser.OrExpr:
+		left, err := p.parseExpr(valExpr.Left, queryBase)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		right, err := p.parseExpr(valExpr.Right, queryBase)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return orShardKeyAssignments(left, right), nil
 	case *vtparser.ComparisonExpr:
-		if err := p.parseComparisonExpr(valExpr, queryBase); err != nil {
-			return errors.WithStack(err)
+		assignments, err := p.parseComparisonExpr(valExpr, queryBase)
+		if err != nil {
+			return nil, errors.WithStack(err)
 		}
+		return assignments, nil
 	case *vtparser.ParenExpr:
-		if err := p.parseExpr(valExpr.Expr, queryBase); err != nil {
-			return errors.WithStack(err)
+		return p.parseExpr(valExpr.Expr, queryBase)
+	default:
+		debug.Printf("default: %s", reflect.TypeOf(valExpr))
+	}
+	return unrestrictedShardKeyAssignments(), nil
+}
+
+func (p *Parser) parseComparisonExpr(expr *vtparser.ComparisonExpr, queryBase *QueryBase) ([]shardKeyAssignment, error) {
+	if !p.isShardKeyColumn(expr.Left, queryBase) {
+		return unrestrictedShardKeyAssignments(), nil
+	}
+	colName := expr.Left.(*vtparser.ColName).Name.String()
+	switch expr.Operator {
+	case vtparser.EqualStr:
+		key, placeholderIndex, err := p.parseShardKeyValue(expr.Right, queryBase.Args)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if placeholderIndex > 0 {
+			queryBase.ShardKeyIDPlaceholderIndex = placeholderIndex
+		}
+		if key == UnknownID {
+			return unrestrictedShardKeyAssignments(), nil
+		}
+		return []shardKeyAssignment{{colName: key}}, nil
+	case vtparser.InStr:
+		tuple, ok := expr.Right.(vtparser.ValTuple)
+		if !ok {
+			return unrestrictedShardKeyAssignments(), nil
+		}
+		assignments := make([]shardKeyAssignment, 0, len(tuple))
+		keys := make([]Identifier, 0, len(tuple))
+		for _, valExpr := range tuple {
+			key, _, err := p.parseShardKeyValue(valExpr, queryBase.Args)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if key == UnknownID {
+				return unrestrictedShardKeyAssignments(), nil
+			}
+			assignments = append(assignments, shardKeyAssignment{colName: key})
+			keys = append(keys, key)
+		}
+		if !p.isCompositeShardKey(queryBase.TableName) {
+			queryBase.shardKeyInExprs = append(queryBase.shardKeyInExprs, &shardKeyInExpr{
+				expr:  expr,
+				tuple: tuple,
+				keys:  keys,
+			})
+		}
+		return assignments, nil
+	default:
+	}
+	return unrestrictedShardKeyAssignments(), nil
+}
+
+// shardKeysByAssignments returns sharding keys decided by combinations of shard_key values.
+// If any combination doesn't have values of all shard_key columns, it returns nil.
+func (p *Parser) shardKeysByAssignments(tableName string, assignments []shardKeyAssignment) []Identifier {
+	columnNames := p.shardKeyColumnNames(tableName)
+	if len(columnNames) == 0 {
+		return nil
+	}
+	keys := []Identifier{}
+	foundKeys := map[Identifier]struct{}{}
+	for _, assignment := range assignments {
+		values := make([]Identifier, 0, len(columnNames))
+		for _, columnName := range columnNames {
+			value, exists := assignment[columnName]
+			if !exists {
+				// rows matched by this condition may be in any shards
+				return nil
+			}
+			values = append(values, value)
+		}
+		key := values[0]
+		if len(values) > 1 {
+			key = algorithm.NewCompositeKey(values...)
+		}
+		if _, exists := foundKeys[key]; exists {
+			continue
+		}
+		foundKeys[key] = struct{}{}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// shardKeyRangeByValue returns range including only integer value of shard_key.
+func (p *Parser) shardKeyRangeByValue(expr vtparser.Expr, queryBase *QueryBase) (*algorithm.ShardKeyRange, bool) {
+	key, _, err := p.parseShardKeyValue(expr, queryBase.Args)
+	if err != nil {
+		return nil, false
+	}
+	value, ok := key.Int64()
+	if !ok {
+		return nil, false
+	}
+	return algorithm.NewShardKeyRangeByValue(value), true
+}
+
+// parseShardKeyRange returns range of integer shard_key restricted by expr.
+// Ranges of conditions joined by AND are intersected, and ranges joined by OR are united.
+// If expr doesn't restrict shard_key, it returns nil.
+func (p *Parser) parseShardKeyRange(expr vtparser.Expr, queryBase *QueryBase) *algorithm.ShardKeyRange {
+	switch valExpr := expr.(type) {
+	case *vtparser.AndExpr:
+		left := p.parseShardKeyRange(valExpr.Left, queryBase)
+		right := p.parseShardKeyRange(valExpr.Right, queryBase)
+		if left == nil {
+			return right
+		}
+		if right == nil {
+			return left
+		}
+		return left.Intersect(right)
+	case *vtparser.OrExpr:
+		left := p.parseShardKeyRange(valExpr.Left, queryBase)
+		right := p.parseShardKeyRange(valExpr.Right, queryBase)
+		if left == nil || right == nil {
+			return nil
+		}
+		return left.Union(right)
+	case *vtparser.ParenExpr:
+		return p.parseShardKeyRange(valExpr.Expr, queryBase)
+	case *vtparser.RangeCond:
+		if valExpr.Operator != vtparser.BetweenStr || !p.isShardKeyColumn(valExpr.Left, queryBase) {
+			return nil
+		}
+		from, ok := p.shardKeyRangeByValue(valExpr.From, queryBase)
+		if !ok {
+			return nil
+		}
+		to, ok := p.shardKeyRangeByValue(valExpr.To, queryBase)
+		if !ok {
+			return nil
 		}
+		return &algorithm.ShardKeyRange{From: from.From, To: to.To}
+	case *vtparser.ComparisonExpr:
+		return p.parseComparisonExprRange(valExpr, queryBase)
 	default:
-		return errors.Errorf("parse error. expr type '%s' does not supported", reflect.TypeOf(valExpr))
 	}
 	return nil
 }
 
-func (p *Parser) parseComparisonExpr(expr *vtparser.ComparisonExpr, queryBase *QueryBase) error {
+func (p *Parser) parseComparisonExprRange(expr *vtparser.ComparisonExpr, queryBase *QueryBase) *algorithm.ShardKeyRange {
 	if !p.isShardKeyColumn(expr.Left, queryBase) {
 		return nil
 	}
-	return errors.WithStack(p.parseExpr(expr.Right, queryBase))
+	if expr.Operator == vtparser.InStr {
+		tuple, ok := expr.Right.(vtparser.ValTuple)
+		if !ok || len(tuple) == 0 {
+			return nil
+		}
+		var keyRange *algorithm.ShardKeyRange
+		for _, valExpr := range tuple {
+			valueRange, ok := p.shardKeyRangeByValue(valExpr, queryBase)
+			if !ok {
+				return nil
+			}
+			if keyRange == nil {
+				keyRange = valueRange
+				continue
+			}
+			keyRange = keyRange.Union(valueRange)
+		}
+		return keyRange
+	}
+	valueRange, ok := p.shardKeyRangeByValue(expr.Right, queryBase)
+	if !ok {
+		return nil
+	}
+	switch expr.Operator {
+	case vtparser.EqualStr:
+		return valueRange
+	case vtparser.GreaterEqualStr:
+		return &algorithm.ShardKeyRange{From: valueRange.From}
+	case vtparser.GreaterThanStr:
+		if valueRange.To == nil {
+			return nil
+		}
+		return &algorithm.ShardKeyRange{From: valueRange.To}
+	case vtparser.LessThanStr:
+		return &algorithm.ShardKeyRange{To: valueRange.From}
+	case vtparser.LessEqualStr:
+		if valueRange.To == nil {
+			return nil
+		}
+		return &algorithm.ShardKeyRange{To: valueRange.To}
+	default:
+	}
+	return nil
 }
 
+// parseWhere finds sharding keys from WHERE clause.
+// If only one key is found, it is set to ShardKeyID.
+// If multiple keys are found by IN or OR conditions, they are set to ShardKeyIDs.
+// Otherwise, range of shard_key found by conditions like BETWEEN is set to ShardKeyRange.
 func (p *Parser) parseWhere(where *vtparser.Where, queryBase *QueryBase) error {
-	return errors.WithStack(p.parseExpr(where.Expr, queryBase))
+	assignments, err := p.parseExpr(where.Expr, queryBase)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	keys := p.shardKeysByAssignments(queryBase.TableName, assignments)
+	switch {
+	case len(keys) == 1:
+		queryBase.ShardKeyID = keys[0]
+	case len(keys) > 1:
+		queryBase.ShardKeyIDs = keys
+	case !p.isCompositeShardKey(queryBase.TableName):
+		keyRange := p.parseShardKeyRange(where.Expr, queryBase)
+		if keyRange != nil && !keyRange.IsEmpty() {
+			queryBase.ShardKeyRange = keyRange
+		}
+	}
+	return nil
 }
 
 func (p *Parser) parseAliasedTableExpr(stmt *vtparser.Select, tableExpr *vtparser.AliasedTableExpr, queryBase *QueryBase) error {
@@ -202,12 +527,19 @@ func (p *Parser) replaceInsertValueFromValArg(query *InsertQuery, colIndex int,
 	queryArg := query.Args[index-1]
 	switch arg := queryArg.(type) {
 	case string:
-		query.ColumnValues[colIndex] = createSQLStringTypeVal(arg)
+		p.replaceInsertValueFromValArgCaseString(query, colIndex, colName, arg)
 	case *string:
 		if arg == nil {
-			query.ColumnValues[colIndex] = createSQLNilTypeVal()
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
+				return errors.WithStack(err)
+			}
 		} else {
-			query.ColumnValues[colIndex] = createSQLStringTypeVal(*arg)
+			p.replaceInsertValueFromValArgCaseString(query, colIndex, colName, *arg)
+		}
+	case []byte:
+		// binary value is passed as query argument
+		if p.isShardKeyColumnName(query.TableName, colName) {
+			p.setShardKeyValue(query.QueryBase, colName, algorithm.NewBytesKey(arg))
 		}
 	case int:
 		p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(arg))
@@ -221,7 +553,7 @@ func (p *Parser) replaceInsertValueFromValArg(query *InsertQuery, colIndex int,
 		p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(arg))
 	case *int:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
@@ -229,7 +561,7 @@ func (p *Parser) replaceInsertValueFromValArg(query *InsertQuery, colIndex int,
 		}
 	case *int8:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
@@ -237,7 +569,7 @@ func (p *Parser) replaceInsertValueFromValArg(query *InsertQuery, colIndex int,
 		}
 	case *int16:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
@@ -245,7 +577,7 @@ func (p *Parser) replaceInsertValueFromValArg(query *InsertQuery, colIndex int,
 		}
 	case *int32:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
@@ -253,61 +585,61 @@ func (p *Parser) replaceInsertValueFromValArg(query *InsertQuery, colIndex int,
 		}
 	case *int64:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
 			p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(*arg))
 		}
 	case uint:
-		p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(arg))
+		p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(arg))
 	case uint8:
-		p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(arg))
+		p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(arg))
 	case uint16:
-		p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(arg))
+		p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(arg))
 	case uint32:
-		p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(arg))
+		p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(arg))
 	case uint64:
-		p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(arg))
+		p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(arg))
 	case *uint:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
-			p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(*arg))
+			p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(*arg))
 		}
 	case *uint8:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
-			p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(*arg))
+			p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(*arg))
 		}
 	case *uint16:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
-			p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(*arg))
+			p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(*arg))
 		}
 	case *uint32:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
-			p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(*arg))
+			p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(*arg))
 		}
 	case *uint64:
 		if arg == nil {
-			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
 				return errors.WithStack(err)
 			}
 		} else {
-			p.replaceInsertValueFromValArgCaseInt(query, colIndex, colName, int64(*arg))
+			p.replaceInsertValueFromValArgCaseUint(query, colIndex, colName, uint64(*arg))
 		}
 	case bool:
 		val := convertBoolToInt8(arg)
@@ -320,12 +652,14 @@ func (p *Parser) replaceInsertValueFromValArg(query *InsertQuery, colIndex int,
 			query.ColumnValues[colIndex] = createSQLIntTypeVal(val)
 		}
 	case time.Time:
-		query.ColumnValues[colIndex] = createSQLTimeTypeVal(arg)
+		p.replaceInsertValueFromValArgCaseTime(query, colIndex, colName, arg)
 	case *time.Time:
 		if arg == nil {
-			query.ColumnValues[colIndex] = createSQLNilTypeVal()
+			if err := p.replaceInsertValueFromValArgCaseNilPtr(query, colIndex, colName); err != nil {
+				return errors.WithStack(err)
+			}
 		} else {
-			query.ColumnValues[colIndex] = createSQLTimeTypeVal(*arg)
+			p.replaceInsertValueFromValArgCaseTime(query, colIndex, colName, *arg)
 		}
 	case nil:
 		query.ColumnValues[colIndex] = createSQLNilTypeVal()
@@ -336,22 +670,93 @@ func (p *Parser) replaceInsertValueFromValArg(query *InsertQuery, colIndex int,
 }
 
 func (p *Parser) replaceInsertValueFromValArgCaseInt(query *InsertQuery, colIndex int, colName string, arg int64) {
-	if colName == p.shardKeyColumnName(query.TableName) {
-		query.ShardKeyID = Identifier(arg)
+	if p.isShardKeyColumnName(query.TableName, colName) {
+		p.setShardKeyValue(query.QueryBase, colName, algorithm.NewIntKey(arg))
 	}
 	query.ColumnValues[colIndex] = createSQLIntTypeVal(arg)
 }
 
-func (p *Parser) replaceInsertValueFromValArgCaseIntNilPtr(query *InsertQuery, colIndex int, colName string) error {
-	if colName == p.shardKeyColumnName(query.TableName) {
+func (p *Parser) replaceInsertValueFromValArgCaseUint(query *InsertQuery, colIndex int, colName string, arg uint64) {
+	if p.isShardKeyColumnName(query.TableName, colName) {
+		p.setShardKeyValue(query.QueryBase, colName, algorithm.NewUintKey(arg))
+	}
+	query.ColumnValues[colIndex] = createSQLIntTypeVal(arg)
+}
+
+func (p *Parser) replaceInsertValueFromValArgCaseString(query *InsertQuery, colIndex int, colName string, arg string) {
+	if p.isShardKeyColumnName(query.TableName, colName) {
+		p.setShardKeyValue(query.QueryBase, colName, algorithm.NewStringKey(arg))
+	}
+	query.ColumnValues[colIndex] = createSQLStringTypeVal(arg)
+}
+
+func (p *Parser) replaceInsertValueFromValArgCaseTime(query *InsertQuery, colIndex int, colName string, arg time.Time) {
+	if p.isShardKeyColumnName(query.TableName, colName) {
+		p.setShardKeyValue(query.QueryBase, colName, algorithm.NewTimeKey(arg))
+	}
+	query.ColumnValues[colIndex] = createSQLTimeTypeVal(arg)
+}
+
+func (p *Parser) replaceInsertValueFromValArgCaseNilPtr(query *InsertQuery, colIndex int, colName string) error {
+	if p.isShardKeyColumnName(query.TableName, colName) {
 		return errors.WithStack(ErrShardingKeyNotAllowNil)
 	}
 	query.ColumnValues[colIndex] = createSQLNilTypeVal()
 	return nil
 }
 
+// parseSpecifiedShardColumnValue parses value of shard_column specified by upsert query.
+// It returns false if value is null.
+func (p *Parser) parseSpecifiedShardColumnValue(query *InsertQuery, colIndex int, colName string) (bool, error) {
+	columnValues := query.Stmt.Rows.(vtparser.Values)[0]
+	colValue, ok := columnValues[colIndex].(*vtparser.SQLVal)
+	if !ok {
+		return false, nil
+	}
+	if colValue.Type != vtparser.ValArg {
+		id, err := strconv.Atoi(string(colValue.Val))
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		query.shardColumnValue = algorithm.NewIntKey(int64(id))
+		return true, nil
+	}
+	arg, err := query.ArgByValArg(colValue)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	value := reflect.ValueOf(arg)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return false, nil
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		query.shardColumnValue = algorithm.NewIntKey(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		query.shardColumnValue = algorithm.NewUintKey(value.Uint())
+	case reflect.Invalid:
+		return false, nil
+	default:
+		return false, errors.Errorf("unsupport shard_column type %s", reflect.TypeOf(arg))
+	}
+	return true, errors.WithStack(p.replaceInsertValueFromValArg(query, colIndex, colName, string(colValue.Val)))
+}
+
 func (p *Parser) replaceInsertValue(query *InsertQuery, colIndex int, colName string) error {
 	if colName == p.shardColumnName(query.TableName) {
+		if query.IsUpsert() {
+			// use specified id to replace or update existing row
+			isSpecified, err := p.parseSpecifiedShardColumnValue(query, colIndex, colName)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if isSpecified {
+				return nil
+			}
+		}
 		query.ColumnValues[colIndex] = func() *vtparser.SQLVal {
 			return &vtparser.SQLVal{
 				Type: vtparser.IntVal,
@@ -369,12 +774,12 @@ func (p *Parser) replaceInsertValue(query *InsertQuery, colIndex int, colName st
 		if err := p.replaceInsertValueFromValArg(query, colIndex, colName, string(colValue.Val)); err != nil {
 			return errors.WithStack(err)
 		}
-	} else if colName == p.shardKeyColumnName(query.TableName) {
-		id, err := strconv.Atoi(string(colValue.Val))
+	} else if p.isShardKeyColumnName(query.TableName, colName) {
+		key, err := shardKeyBySQLVal(colValue)
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		query.ShardKeyID = Identifier(id)
+		p.setShardKeyValue(query.QueryBase, colName, key)
 	}
 	return nil
 }
@@ -383,23 +788,60 @@ func (p *Parser) parseInsertStmt(stmt *vtparser.Insert, queryBase *QueryBase) (Q
 	queryBase.Type = Insert
 	queryBase.TableName = stmt.Table.Name.String()
 	query := NewInsertQuery(queryBase, stmt)
-	for idx, column := range stmt.Columns {
-		colName := column.String()
-		if err := p.replaceInsertValue(query, idx, colName); err != nil {
+	for _, updateExpr := range stmt.OnDup {
+		colName := updateExpr.Name.Name.String()
+		if p.isShardKeyColumnName(queryBase.TableName, colName) || colName == p.shardColumnName(queryBase.TableName) {
+			return nil, errors.Errorf("cannot update %s column by ON DUPLICATE KEY UPDATE", colName)
+		}
+	}
+	if err := p.parseInsertColumns(query); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	values := stmt.Rows.(vtparser.Values)
+	if len(values) < 2 {
+		return query, nil
+	}
+	// parse each row to decide shard and sequence id for each row
+	for _, row := range values {
+		rowStmt := *stmt
+		rowStmt.Rows = vtparser.Values{row}
+		rowQueryBase := NewQueryBase(&rowStmt, queryBase.Text, queryBase.Args)
+		rowQueryBase.Type = queryBase.Type
+		rowQueryBase.TableName = queryBase.TableName
+		rowQuery := NewInsertQuery(rowQueryBase, &rowStmt)
+		if err := p.parseInsertColumns(rowQuery); err != nil {
 			return nil, errors.WithStack(err)
 		}
+		query.RowQueries = append(query.RowQueries, rowQuery)
 	}
 	return query, nil
 }
 
+func (p *Parser) parseInsertColumns(query *InsertQuery) error {
+	for idx, column := range query.Stmt.Columns {
+		colName := column.String()
+		if err := p.replaceInsertValue(query, idx, colName); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
 func (p *Parser) parseUpdateExprs(exprs vtparser.UpdateExprs, queryBase *QueryBase) error {
 	for _, updateExpr := range exprs {
-		if p.shardKeyColumnName(queryBase.TableName) != updateExpr.Name.Name.String() {
+		colName := updateExpr.Name.Name.String()
+		if !p.isShardKeyColumnName(queryBase.TableName, colName) {
 			continue
 		}
-		if err := p.parseExpr(updateExpr.Expr, queryBase); err != nil {
+		if p.isCompositeShardKey(queryBase.TableName) {
+			return errors.Errorf("cannot update %s column of composite shard_key", colName)
+		}
+		// new value of shard_key mustn't be used to decide current shard
+		newShardKeyID, _, err := p.parseShardKeyValue(updateExpr.Expr, queryBase.Args)
+		if err != nil {
 			return errors.WithStack(err)
 		}
+		queryBase.NewShardKeyID = newShardKeyID
 	}
 	return nil
 }
@@ -3,10 +3,13 @@ package sqlparser
 import (
 	"fmt"
 	"log"
+	"math"
 	"path/filepath"
 	"testing"
 	"time"
 
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/aokabi/octillery/algorithm"
 	"github.com/aokabi/octillery/config"
 	"github.com/aokabi/octillery/path"
 )
@@ -127,6 +130,21 @@ func TestSELECT(t *testing.T) {
 			}
 		})
 	})
+	t.Run("format select query with arguments", func(t *testing.T) {
+		query, err := parser.Parse("select name from users where name = ? and age > ? limit ?", "bob", 10, 5)
+		checkErr(t, err)
+		selectQuery := query.(*QueryBase)
+		stmt := *selectQuery.Stmt.(*vtparser.Select)
+		stmt.Limit = &vtparser.Limit{Rowcount: vtparser.NewIntVal([]byte("10"))}
+		text, args, err := selectQuery.StringWithArgs(&stmt)
+		checkErr(t, err)
+		if text != "select name from users where name = ? and age > ? limit 10" {
+			t.Fatalf("cannot format query: %s", text)
+		}
+		if len(args) != 2 || args[0] != "bob" || args[1] != 10 {
+			t.Fatalf("invalid arguments: %v", args)
+		}
+	})
 }
 
 func testInsertWithShardColumnTable(t *testing.T, tableName string) {
@@ -312,6 +330,26 @@ func testInsertWithShardKeyTable(t *testing.T, tableName string) {
 			t.Fatal("cannot generate parsed query")
 		}
 	})
+	t.Run("insert multiple rows", func(t *testing.T) {
+		text := fmt.Sprintf("insert into %s(id, user_id, name) values (null, 1, 'alice'), (null, ?, ?), (null, 3, 'carol')", tableName)
+		query, err := parser.Parse(text, int64(2), "bob")
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		if !insertQuery.IsMultiRows() || len(insertQuery.RowQueries) != 3 {
+			t.Fatal("cannot parse multiple rows")
+		}
+		for idx, rowQuery := range insertQuery.RowQueries {
+			if rowQuery.ShardKeyID != algorithm.NewIntKey(int64(idx+1)) {
+				t.Fatalf("cannot parse shard_key of row. %s", rowQuery.ShardKeyID)
+			}
+		}
+		rowQueries := []*InsertQuery{insertQuery.RowQueries[0], insertQuery.RowQueries[1]}
+		rowText, rowArgs, err := insertQuery.TextAndArgsWithRowQueries(rowQueries)
+		checkErr(t, err)
+		if rowText != "insert into user_items(id, user_id, name) values (null, 1, 'alice'), (null, 2, 'bob')" || len(rowArgs) != 0 {
+			t.Fatalf("cannot generate query for rows. %s", rowText)
+		}
+	})
 }
 
 func testInsertWithShardColumnAndShardKeyTable(t *testing.T, tableName string) {
@@ -766,6 +804,65 @@ func TestINSERT(t *testing.T) {
 	t.Run("not sharding table", func(t *testing.T) {
 		testInsertWithNotShardingTable(t)
 	})
+	t.Run("upsert", func(t *testing.T) {
+		testUpsert(t)
+	})
+}
+
+func testUpsert(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	t.Run("replace with specified id", func(t *testing.T) {
+		query, err := parser.Parse("replace into users(id, name) values (?, 'bob')", int64(3))
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		if !insertQuery.IsUpsert() || !insertQuery.IsReplace() {
+			t.Fatal("cannot parse replace query")
+		}
+		id, isSpecified := insertQuery.ShardColumnValue()
+		if !isSpecified || id != algorithm.NewIntKey(3) {
+			t.Fatal("cannot parse specified id")
+		}
+		if insertQuery.String() != "replace into users(id, name) values (3, 'bob')" {
+			t.Fatalf("cannot generate parsed query. %s", insertQuery.String())
+		}
+	})
+	t.Run("replace without id", func(t *testing.T) {
+		query, err := parser.Parse("replace into users(id, name) values (null, 'bob')")
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		if _, isSpecified := insertQuery.ShardColumnValue(); isSpecified {
+			t.Fatal("cannot parse specified id")
+		}
+		insertQuery.SetNextSequenceID(5) // simulate sequencer's action
+		if insertQuery.String() != "replace into users(id, name) values (5, 'bob')" {
+			t.Fatalf("cannot generate parsed query. %s", insertQuery.String())
+		}
+	})
+	t.Run("on duplicate key update with placeholder", func(t *testing.T) {
+		query, err := parser.Parse("insert into user_items(id, user_id, name) values (null, ?, 'bob') on duplicate key update name = ?", int64(1), "alice")
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		if !insertQuery.IsUpsert() || insertQuery.IsReplace() {
+			t.Fatal("cannot parse upsert query")
+		}
+		if insertQuery.ShardKeyID != algorithm.NewIntKey(1) {
+			t.Fatal("cannot parse shard_key")
+		}
+		text, args, err := insertQuery.TextAndArgs()
+		checkErr(t, err)
+		if text != "insert into user_items(id, user_id, name) values (null, 1, 'bob') on duplicate key update name = ?" {
+			t.Fatalf("cannot generate parsed query. %s", text)
+		}
+		if len(args) != 1 || args[0] != "alice" {
+			t.Fatalf("invalid arguments %v", args)
+		}
+	})
+	t.Run("update shard_key by on duplicate key update", func(t *testing.T) {
+		if _, err := parser.Parse("insert into user_items(id, user_id) values (null, 1) on duplicate key update user_id = 2"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
 }
 
 func testUpdateWithShardColumnTable(t *testing.T, tableName string) {
@@ -782,7 +879,7 @@ func testUpdateWithShardColumnTable(t *testing.T, tableName string) {
 			t.Fatal("cannot parse 'update' query")
 		}
 		updateQuery := query.(*QueryBase)
-		if updateQuery.ShardKeyID != 1 {
+		if updateQuery.ShardKeyID != algorithm.NewIntKey(1) {
 			t.Fatal("cannot parse")
 		}
 		if updateQuery.ShardKeyIDPlaceholderIndex != 0 {
@@ -800,7 +897,7 @@ func testUpdateWithShardColumnTable(t *testing.T, tableName string) {
 			t.Fatal("cannot parse 'update' query")
 		}
 		updateQuery := query.(*QueryBase)
-		if updateQuery.ShardKeyID != 1 {
+		if updateQuery.ShardKeyID != algorithm.NewIntKey(1) {
 			t.Fatal("cannot parse")
 		}
 		if updateQuery.ShardKeyIDPlaceholderIndex != 1 {
@@ -823,7 +920,7 @@ func testUpdateWithShardKeyTable(t *testing.T, tableName string) {
 			t.Fatal("cannot parse 'update' query")
 		}
 		updateQuery := query.(*QueryBase)
-		if updateQuery.ShardKeyID != 1 {
+		if updateQuery.ShardKeyID != algorithm.NewIntKey(1) {
 			t.Fatal("cannot parse")
 		}
 		if updateQuery.ShardKeyIDPlaceholderIndex != 0 {
@@ -841,13 +938,25 @@ func testUpdateWithShardKeyTable(t *testing.T, tableName string) {
 			t.Fatal("cannot parse 'update' query")
 		}
 		updateQuery := query.(*QueryBase)
-		if updateQuery.ShardKeyID != 1 {
+		if updateQuery.ShardKeyID != algorithm.NewIntKey(1) {
 			t.Fatal("cannot parse")
 		}
 		if updateQuery.ShardKeyIDPlaceholderIndex != 1 {
 			t.Fatal("cannot parse")
 		}
 	})
+	t.Run("update shard_key column", func(t *testing.T) {
+		text := fmt.Sprintf("update %s set user_id = ? where user_id = ?", tableName)
+		query, err := parser.Parse(text, int64(2), int64(1))
+		checkErr(t, err)
+		updateQuery := query.(*QueryBase)
+		if updateQuery.ShardKeyID != algorithm.NewIntKey(1) {
+			t.Fatal("cannot parse")
+		}
+		if !updateQuery.IsShardKeyUpdated() || updateQuery.NewShardKeyID != algorithm.NewIntKey(2) {
+			t.Fatal("cannot parse")
+		}
+	})
 }
 
 func testUpdateWithShardingTable(t *testing.T) {
@@ -879,7 +988,7 @@ func testDeleteWithShardColumnTable(t *testing.T, tableName string) {
 			t.Fatal("cannot parse 'delete' query")
 		}
 		deleteQuery := query.(*DeleteQuery)
-		if deleteQuery.ShardKeyID != 1 {
+		if deleteQuery.ShardKeyID != algorithm.NewIntKey(1) {
 			t.Fatal("cannot parse")
 		}
 		if deleteQuery.ShardKeyIDPlaceholderIndex != 0 {
@@ -897,7 +1006,7 @@ func testDeleteWithShardColumnTable(t *testing.T, tableName string) {
 			t.Fatal("cannot parse 'delete' query")
 		}
 		deleteQuery := query.(*DeleteQuery)
-		if deleteQuery.ShardKeyID != 1 {
+		if deleteQuery.ShardKeyID != algorithm.NewIntKey(1) {
 			t.Fatal("cannot parse")
 		}
 		if deleteQuery.ShardKeyIDPlaceholderIndex != 1 {
@@ -920,7 +1029,7 @@ func testDeleteWithShardKeyTable(t *testing.T, tableName string) {
 			t.Fatal("cannot parse 'delete' query")
 		}
 		deleteQuery := query.(*DeleteQuery)
-		if deleteQuery.ShardKeyID != 1 {
+		if deleteQuery.ShardKeyID != algorithm.NewIntKey(1) {
 			t.Fatal("cannot parse")
 		}
 		if deleteQuery.ShardKeyIDPlaceholderIndex != 0 {
@@ -938,7 +1047,7 @@ func testDeleteWithShardKeyTable(t *testing.T, tableName string) {
 			t.Fatal("cannot parse 'delete' query")
 		}
 		deleteQuery := query.(*DeleteQuery)
-		if deleteQuery.ShardKeyID != 1 {
+		if deleteQuery.ShardKeyID != algorithm.NewIntKey(1) {
 			t.Fatal("cannot parse")
 		}
 		if deleteQuery.ShardKeyIDPlaceholderIndex != 1 {
@@ -962,6 +1071,254 @@ func TestDELETE(t *testing.T) {
 	})
 }
 
+func TestStringShardKey(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	email := "bob@example.com"
+	t.Run("select with literal", func(t *testing.T) {
+		query, err := parser.Parse("select id from user_accounts where email = 'bob@example.com'")
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyID != algorithm.NewStringKey(email) {
+			t.Fatal("cannot parse string shard_key")
+		}
+	})
+	t.Run("select with placeholder", func(t *testing.T) {
+		query, err := parser.Parse("select id from user_accounts where email = ?", email)
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyID != algorithm.NewStringKey(email) {
+			t.Fatal("cannot parse string shard_key")
+		}
+		query, err = parser.Parse("select id from user_accounts where email = ?", &email)
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyID != algorithm.NewStringKey(email) {
+			t.Fatal("cannot parse string shard_key")
+		}
+	})
+	t.Run("select with int argument", func(t *testing.T) {
+		query, err := parser.Parse("select name from users where id = ?", 1)
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyID != algorithm.NewIntKey(1) {
+			t.Fatal("cannot parse int shard_key")
+		}
+	})
+	t.Run("insert with placeholder", func(t *testing.T) {
+		query, err := parser.Parse("insert into user_accounts(id, email) values (null, ?)", email)
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		if insertQuery.ShardKeyID != algorithm.NewStringKey(email) {
+			t.Fatal("cannot parse string shard_key")
+		}
+		if insertQuery.String() != "insert into user_accounts(id, email) values (null, 'bob@example.com')" {
+			t.Fatalf("cannot generate parsed query. %s", insertQuery.String())
+		}
+	})
+	t.Run("insert with binary", func(t *testing.T) {
+		uuid := []byte{0xf4, 0x7a, 0xc1, 0x0b}
+		query, err := parser.Parse("insert into user_accounts(id, email) values (null, ?)", uuid)
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		if insertQuery.ShardKeyID != algorithm.NewBytesKey(uuid) {
+			t.Fatal("cannot parse binary shard_key")
+		}
+		text, args, err := insertQuery.TextAndArgs()
+		checkErr(t, err)
+		if text != "insert into user_accounts(id, email) values (null, ?)" || len(args) != 1 {
+			t.Fatalf("cannot generate parsed query. %s", text)
+		}
+	})
+	t.Run("insert nil", func(t *testing.T) {
+		var nilEmail *string
+		if _, err := parser.Parse("insert into user_accounts(id, email) values (null, ?)", nilEmail); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestUintShardKey(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	for _, userID := range []uint64{10, math.MaxUint64} {
+		insertQuery, err := parser.Parse("insert into user_items(id, user_id, name) values (null, ?, 'bob')", userID)
+		checkErr(t, err)
+		selectQuery, err := parser.Parse("select name from user_items where user_id = ?", userID)
+		checkErr(t, err)
+		insertKey := insertQuery.(*InsertQuery).ShardKeyID
+		selectKey := selectQuery.(*QueryBase).ShardKeyID
+		if insertKey != selectKey || insertKey != algorithm.NewUintKey(userID) {
+			t.Fatalf("shard_key of insert and select must be the same. insert: %s, select: %s", insertKey, selectKey)
+		}
+		if insertQuery.(*InsertQuery).String() != fmt.Sprintf("insert into user_items(id, user_id, name) values (null, %d, 'bob')", userID) {
+			t.Fatalf("cannot generate parsed query. %s", insertQuery.(*InsertQuery).String())
+		}
+	}
+	t.Run("int and uint", func(t *testing.T) {
+		insertQuery, err := parser.Parse("insert into user_items(id, user_id, name) values (null, ?, 'bob')", uint32(10))
+		checkErr(t, err)
+		selectQuery, err := parser.Parse("select name from user_items where user_id = ?", int64(10))
+		checkErr(t, err)
+		if insertQuery.(*InsertQuery).ShardKeyID != selectQuery.(*QueryBase).ShardKeyID {
+			t.Fatal("shard_key by signed and unsigned arguments must be the same")
+		}
+	})
+}
+
+func TestCompositeShardKey(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	key := algorithm.NewCompositeKey(algorithm.NewIntKey(2), algorithm.NewIntKey(10))
+	t.Run("select with all columns", func(t *testing.T) {
+		query, err := parser.Parse("select id from user_region_items where user_id = ? and region_id = 2", int64(10))
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyID != key {
+			t.Fatalf("cannot parse composite shard_key. %s", query.(*QueryBase).ShardKeyID)
+		}
+	})
+	t.Run("select with part of columns", func(t *testing.T) {
+		query, err := parser.Parse("select id from user_region_items where user_id = ?", int64(10))
+		checkErr(t, err)
+		if !query.(*QueryBase).IsNotFoundShardKeyID() {
+			t.Fatal("part of composite shard_key must not be used")
+		}
+	})
+	t.Run("insert", func(t *testing.T) {
+		query, err := parser.Parse("insert into user_region_items(id, user_id, region_id) values (null, ?, ?)", int64(10), int64(2))
+		checkErr(t, err)
+		if query.(*InsertQuery).ShardKeyID != key {
+			t.Fatalf("cannot parse composite shard_key. %s", query.(*InsertQuery).ShardKeyID)
+		}
+	})
+	t.Run("update composite shard_key", func(t *testing.T) {
+		if _, err := parser.Parse("update user_region_items set region_id = 3 where user_id = 10 and region_id = 2"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestMultipleShardKeys(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	validateShardKeys := func(t *testing.T, query *QueryBase, ids ...int64) {
+		if len(query.ShardKeyIDs) != len(ids) {
+			t.Fatalf("cannot parse multiple shard keys. %v", query.ShardKeyIDs)
+		}
+		for idx, id := range ids {
+			if query.ShardKeyIDs[idx] != algorithm.NewIntKey(id) {
+				t.Fatalf("cannot parse multiple shard keys. %v", query.ShardKeyIDs)
+			}
+		}
+		if query.IsNotFoundShardKeyID() || !query.IsMultipleShardKeyIDs() {
+			t.Fatal("cannot parse multiple shard keys")
+		}
+	}
+	t.Run("in", func(t *testing.T) {
+		query, err := parser.Parse("select name from users where id in (1, ?, 3)", int64(2))
+		checkErr(t, err)
+		validateShardKeys(t, query.(*QueryBase), 1, 2, 3)
+	})
+	t.Run("or", func(t *testing.T) {
+		query, err := parser.Parse("select name from users where (id = 1 or id = ?) and name = 'bob'", int64(2))
+		checkErr(t, err)
+		validateShardKeys(t, query.(*QueryBase), 1, 2)
+	})
+	t.Run("in and equal", func(t *testing.T) {
+		query, err := parser.Parse("select name from users where id in (1, 2, 3) and id = 2")
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyID != algorithm.NewIntKey(2) {
+			t.Fatal("cannot parse shard key")
+		}
+	})
+	t.Run("or with other column", func(t *testing.T) {
+		query, err := parser.Parse("select name from users where id = 1 or name = 'bob'")
+		checkErr(t, err)
+		if !query.(*QueryBase).IsNotFoundShardKeyID() {
+			t.Fatal("query must be executed for all shards")
+		}
+	})
+	t.Run("not equal operator", func(t *testing.T) {
+		query, err := parser.Parse("select name from users where id > 1")
+		checkErr(t, err)
+		if !query.(*QueryBase).IsNotFoundShardKeyID() {
+			t.Fatal("query must be executed for all shards")
+		}
+	})
+	t.Run("composite shard_key", func(t *testing.T) {
+		query, err := parser.Parse("select id from user_region_items where region_id = 2 and user_id in (10, 11)")
+		checkErr(t, err)
+		keys := query.(*QueryBase).ShardKeyIDs
+		if len(keys) != 2 ||
+			keys[0] != algorithm.NewCompositeKey(algorithm.NewIntKey(2), algorithm.NewIntKey(10)) ||
+			keys[1] != algorithm.NewCompositeKey(algorithm.NewIntKey(2), algorithm.NewIntKey(11)) {
+			t.Fatalf("cannot parse multiple shard keys. %v", keys)
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		query, err := parser.Parse("delete from users where id in (1, 2)")
+		checkErr(t, err)
+		deleteQuery := query.(*DeleteQuery)
+		validateShardKeys(t, deleteQuery.QueryBase, 1, 2)
+		if deleteQuery.IsAllShardQuery || deleteQuery.IsDeleteTable {
+			t.Fatal("query must be executed for shards of keys")
+		}
+	})
+	t.Run("prune values of in condition", func(t *testing.T) {
+		query, err := parser.Parse("select name from users where id in (?, ?, ?) and name = ?", int64(1), int64(2), int64(3), "bob")
+		checkErr(t, err)
+		queryBase := query.(*QueryBase)
+		text, args, err := queryBase.StringWithArgsForShardKeys(queryBase.Stmt, []Identifier{algorithm.NewIntKey(1), algorithm.NewIntKey(3)})
+		checkErr(t, err)
+		if text != "select name from users where id in (?, ?) and name = ?" {
+			t.Fatalf("cannot prune values. %s", text)
+		}
+		if len(args) != 3 || args[0] != int64(1) || args[1] != int64(3) || args[2] != "bob" {
+			t.Fatalf("cannot prune arguments. %v", args)
+		}
+		if vtparser.String(queryBase.Stmt) != "select name from users where id in (:v1, :v2, :v3) and name = :v4" {
+			t.Fatalf("statement must be restored. %s", vtparser.String(queryBase.Stmt))
+		}
+	})
+}
+
+func TestShardKeyRange(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	for _, tc := range []struct {
+		text     string
+		args     []interface{}
+		keyRange string
+	}{
+		{"select name from users where id between ? and ?", []interface{}{int64(10), int64(20)}, "[10, 21)"},
+		{"select name from users where id >= 10 and id < 20 and name = 'bob'", nil, "[10, 20)"},
+		{"select name from users where id > 10 and id <= ?", []interface{}{int64(20)}, "[11, 21)"},
+		{"select name from users where id < 5 or id between 10 and 20", nil, "[-inf, 21)"},
+		{"delete from users where id > 100", nil, "[101, +inf)"},
+	} {
+		query, err := parser.Parse(tc.text, tc.args...)
+		checkErr(t, err)
+		var keyRange *algorithm.ShardKeyRange
+		switch q := query.(type) {
+		case *QueryBase:
+			keyRange = q.ShardKeyRange
+		case *DeleteQuery:
+			keyRange = q.ShardKeyRange
+		}
+		if keyRange == nil || keyRange.String() != tc.keyRange {
+			t.Fatalf("cannot parse range of shard_key by '%s'. %v", tc.text, keyRange)
+		}
+	}
+	for _, text := range []string{
+		"select name from users where id > 10 or name = 'bob'",
+		"select name from users where id not between 10 and 20",
+		"select name from users where id > 20 and id < 10",
+		"select name from users where id > 'a'",
+	} {
+		query, err := parser.Parse(text)
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyRange != nil {
+			t.Fatalf("range of shard_key must not be found by '%s'", text)
+		}
+	}
+}
+
 func TestERROR(t *testing.T) {
 	parser, err := New()
 	checkErr(t, err)
@@ -2,12 +2,15 @@ package octillery
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/pkg/errors"
+	"github.com/aokabi/octillery/config"
+	"github.com/aokabi/octillery/connection"
 	"github.com/aokabi/octillery/database/sql"
 	"github.com/aokabi/octillery/path"
 )
@@ -263,6 +266,168 @@ func TestDistributedTransactionCriticalError(t *testing.T) {
 	}
 }
 
+func TestRecoverByCoordinatorLog(t *testing.T) {
+	initializeTables(t)
+	dir, err := ioutil.TempDir("", "octillery_coordinator_log")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := connection.NewFileCoordinatorLogStore(dir)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	connection.SetCoordinatorLogStore(store)
+	defer connection.SetCoordinatorLogStore(nil)
+	BeforeCommitCallback(func(tx *sql.Tx, writeQueries []*sql.QueryLog) error {
+		return nil
+	})
+	AfterCommitCallback(func(*sql.Tx) error {
+		return nil
+	}, func(tx *sql.Tx, isCriticalError bool, failureQueries []*sql.QueryLog) error {
+		return nil
+	})
+
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	insertRecords(tx, t)
+	// fail to commit to user_stages after committed to the other databases
+	if err := os.Remove("/tmp/user_stage.bin"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if err := os.Remove("/tmp/user_stage.bin-journal"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	tx.Rollback()
+	logs, err := store.Logs()
+	checkErr(t, err)
+	if len(logs) != 1 || len(logs[0].Shards) != 4 || logs[0].Shards[3].Committed {
+		t.Fatal("cannot record in-doubt transaction")
+	}
+
+	newDB, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := newDB.Exec(`
+CREATE TABLE IF NOT EXISTS user_stages(
+    id integer NOT NULL PRIMARY KEY autoincrement,
+    user_id integer NOT NULL,
+    name varchar(255) NOT NULL,
+    age integer NOT NULL
+)`); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	countUserStages := func() int {
+		var count int
+		checkErr(t, newDB.QueryRow("SELECT COUNT(*) FROM user_stages").Scan(&count))
+		return count
+	}
+	t.Run("skip transaction in grace period", func(t *testing.T) {
+		results, err := newDB.Recover()
+		checkErr(t, err)
+		if len(results) != 0 || countUserStages() != 0 {
+			t.Fatalf("transaction in grace period must not be recovered %v", results)
+		}
+	})
+	// simulate that grace period has passed
+	connection.SetCoordinatorLogGracePeriod(0)
+	defer connection.SetCoordinatorLogGracePeriod(config.DefaultCoordinatorLogGracePeriod)
+	t.Run("roll forward", func(t *testing.T) {
+		results, err := newDB.Recover()
+		checkErr(t, err)
+		if len(results) != 1 || results[0].Discarded || len(results[0].RolledForwardQueries) != 1 {
+			t.Fatalf("cannot recover transaction %v", results)
+		}
+		if countUserStages() != 1 {
+			t.Fatal("cannot roll forward transaction")
+		}
+	})
+	t.Run("discard", func(t *testing.T) {
+		checkErr(t, store.Write(&connection.TransactionLog{
+			ID: "not_committed_transaction",
+			Shards: []*connection.ShardTransactionLog{
+				{
+					DSN: "/tmp/user_stage.bin",
+					WriteQueries: []*connection.QueryLog{
+						{Query: "INSERT INTO user_stages(user_id, name, age) values (20, 'carol', 10)", LastInsertID: 2},
+					},
+				},
+				{
+					DSN: "/tmp/user_item_shard_1.bin",
+					WriteQueries: []*connection.QueryLog{
+						{Query: "INSERT INTO user_items(id, user_id) VALUES (null, 20)", LastInsertID: 2},
+					},
+				},
+			},
+		}))
+		results, err := newDB.Recover()
+		checkErr(t, err)
+		if len(results) != 1 || !results[0].Discarded {
+			t.Fatalf("cannot discard transaction %v", results)
+		}
+		if countUserStages() != 1 {
+			t.Fatal("discarded transaction must not be executed")
+		}
+	})
+	t.Run("XA transaction", func(t *testing.T) {
+		xaLog := &connection.TransactionLog{
+			ID:            "xa_transaction",
+			XA:            true,
+			CommitDecided: true,
+			Shards: []*connection.ShardTransactionLog{
+				{
+					DSN:       "/tmp/user_stage.bin",
+					XABranch:  1,
+					Committed: true,
+					WriteQueries: []*connection.QueryLog{
+						{Query: "INSERT INTO user_stages(user_id, name, age) values (20, 'carol', 10)", LastInsertID: 2},
+					},
+				},
+				{
+					DSN:      "/tmp/user_item_shard_1.bin",
+					XABranch: 2,
+					WriteQueries: []*connection.QueryLog{
+						{Query: "INSERT INTO user_stages(user_id, name, age) values (30, 'dave', 10)", LastInsertID: 3},
+					},
+				},
+			},
+		}
+		checkErr(t, store.Write(xaLog))
+		// sqlite3 doesn't support XA RECOVER, so prepared transaction cannot be found
+		if _, err := newDB.Recover(); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if countUserStages() != 1 {
+			t.Fatal("write queries of XA transaction must not be executed again")
+		}
+		xaLog.Shards[1].Committed = true
+		checkErr(t, store.Write(xaLog))
+		results, err := newDB.Recover()
+		checkErr(t, err)
+		if len(results) != 1 || !results[0].XA || results[0].Discarded || len(results[0].RolledForwardQueries) != 0 {
+			t.Fatalf("cannot recover XA transaction %v", results)
+		}
+		if countUserStages() != 1 {
+			t.Fatal("write queries of XA transaction must not be executed again")
+		}
+	})
+	logs, err = store.Logs()
+	checkErr(t, err)
+	if len(logs) != 0 {
+		t.Fatal("cannot delete recovered transaction")
+	}
+}
+
 func TestCommitErrorByAfterCommitCallback(t *testing.T) {
 	db, err := sql.Open("", "")
 	if err != nil {
@@ -439,3 +604,52 @@ func TestIsAlreadyCommittedUpdateQueryLog(t *testing.T) {
 		Args:  []interface{}{"alice", 5, 10},
 	})
 }
+
+func TestSavepointAcrossShards(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	insertToUserStages(tx, t)
+	if err := tx.Savepoint("sp1"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	insertToUserItems(tx, t)
+	insertToUserStages(tx, t)
+	if err := tx.RollbackToSavepoint("sp1"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	BeforeCommitCallback(func(tx *sql.Tx, writeQueries []*sql.QueryLog) error {
+		if len(writeQueries) != 1 {
+			t.Fatal("cannot discard write queries after savepoint")
+		}
+		return nil
+	})
+	AfterCommitCallback(func(*sql.Tx) error {
+		return nil
+	}, func(tx *sql.Tx, isCriticalError bool, failureQueries []*sql.QueryLog) error {
+		t.Fatal("cannot commit")
+		return nil
+	})
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_stages").Scan(&count); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if count != 1 {
+		t.Fatalf("invalid user_stages count %d", count)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id = 10").Scan(&count); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if count != 0 {
+		t.Fatalf("invalid user_items count %d", count)
+	}
+}